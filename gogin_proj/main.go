@@ -1,6 +1,9 @@
 package main
 
 import (
+	"flag"
+	"log"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -74,6 +77,38 @@ func main() {
 		##################END gin.New #######################
 	*/
 
+	/*
+		################## START routes + OpenAPI #######################
+	*/
+	/*
+		Routes and their OpenAPI document are built together (see openapi.go):
+			GET /openapi.json   the document
+			GET /docs           a browsable page
+	*/
+	addr := flag.String("addr", ":8080", "listen address")
+	write := flag.String("openapi-write", "", "write the OpenAPI document to `file` and exit")
+	check := flag.String("openapi-check", "", "exit 1 if `file` differs from the generated OpenAPI document")
+	flag.Parse()
+
+	r, spec := newRouter()
+	if *write != "" {
+		if err := spec.WriteFile(*write); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *check != "" {
+		if err := spec.Check(*check); err != nil {
+			log.Fatalf("%v\nrun go generate to update it", err)
+		}
+		return
+	}
+	if err := r.Run(*addr); err != nil {
+		log.Fatal(err)
+	}
+	/*
+		################## END routes + OpenAPI #######################
+	*/
 }
 
 type APIResponse struct {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"middleware/openapi"
+)
+
+/*
+OpenAPI for gin
+
+gin has its own router, so each route is registered through docRoutes,
+which adds it to gin AND to the spec in one call; a route cannot be added
+without being documented:
+
+	api := docRoutes{group: &r.RouterGroup, spec: spec}
+	api.handle("GET", "/info", GinHandlerFunc,
+		openapi.Returns(200, APIResponse{}))
+
+Paths are joined to the group's base path the way gin joins them, so a
+route on the root group is "/info", not "//info".
+
+	go generate             → rewrite openapi.json
+	go run . -openapi-check openapi.json   → exit 1 when it is out of date (CI)
+*/
+
+//go:generate go run . -openapi-write openapi.json
+
+// docRoutes registers routes on a gin group and documents them in spec.
+type docRoutes struct {
+	group *gin.RouterGroup
+	spec  *openapi.Spec
+}
+
+func (d docRoutes) handle(method, path string, h gin.HandlerFunc, opts ...openapi.Option) {
+	d.group.Handle(method, path, h)
+	d.spec.Add(method, joinPaths(d.group.BasePath(), path), opts...)
+}
+
+// joinPaths mirrors gin's own joining: cleaned, with a trailing slash kept
+// when the route has one.
+func joinPaths(base, rel string) string {
+	if rel == "" {
+		return base
+	}
+	p := path.Join(base, rel)
+	if strings.HasSuffix(rel, "/") && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+// newRouter builds the routes from main.go's examples and their OpenAPI
+// document.
+func newRouter() (*gin.Engine, *openapi.Spec) {
+	spec := openapi.New("gogin_proj", "1.0.0")
+
+	r := gin.New()
+	r.Use(gin.Logger(), gin.Recovery())
+
+	api := docRoutes{group: &r.RouterGroup, spec: spec}
+	api.handle("GET", "/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "pong"})
+	}, openapi.Tags("health"), openapi.Summary("Liveness check"), openapi.Returns(http.StatusOK, gin.H{}))
+	api.handle("GET", "/info", GinHandlerFunc,
+		openapi.Tags("info"), openapi.Summary("Read the info message"), openapi.Returns(http.StatusOK, APIResponse{}))
+	api.handle("POST", "/info-submit", GinHandlerFunc,
+		openapi.Tags("info"), openapi.Summary("Submit to the info handler"), openapi.Returns(http.StatusOK, APIResponse{}))
+	api.handle("GET", "/panic", func(c *gin.Context) {
+		panic("something went wrong")
+	}, openapi.Tags("health"), openapi.Summary("Panic; gin.Recovery answers 500"),
+		openapi.Returns(http.StatusInternalServerError, nil))
+
+	r.GET("/openapi.json", gin.WrapH(spec.Handler()))
+	r.GET("/docs", gin.WrapH(openapi.DocsHandler("gogin_proj API", "/openapi.json")))
+	return r, spec
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Admin server: runtime introspection on its own port
+
+Operators should never need the public port to look inside the process.
+The admin server listens on admin_addr (default 127.0.0.1:9091, so only
+the host itself can reach it) and every endpoint requires a bearer token:
+admin_token, or, when that is empty, one generated at startup and written
+to state_dir/admin-token with mode 0600. The log names that file, never
+the token, since logs usually end up in shared storage:
+
+	curl -H "Authorization: Bearer $(cat .httpserv/admin-token)" http://127.0.0.1:9091/config
+
+There is no tokenless mode; loopback is not a security boundary on a
+shared host.
+
+	GET /                 index of the endpoints below
+	GET /buildinfo        module, Go version, VCS revision, dependencies
+	GET /config           effective settings and their sources, secrets masked
+	GET /runtime          goroutines, GOMAXPROCS, every runtime/metrics value
+	GET /routes           patterns mounted on the public mux
+	GET /health           checks (503 if any fails) and component status
+	GET /debug/pprof/     profiles: go tool pprof http://127.0.0.1:9091/debug/pprof/heap
+	GET /metrics          connection gauges in Prometheus text format (see connmgr.go)
+	GET /proxy/stats      every upstream's state, with proxy_config (see proxy.go)
+	GET /rewrite          URL rewrite dry run, with rewrite_rules (see rewrite/)
+
+Importing net/http/pprof also registers its handlers on
+http.DefaultServeMux. The public server has its own mux, so they are only
+reachable here.
+*/
+
+// RouteTable is a ServeMux that remembers its patterns.
+type RouteTable struct {
+	*http.ServeMux
+
+	mu       sync.Mutex
+	patterns []string
+}
+
+// NewRouteTable returns an empty table.
+func NewRouteTable() *RouteTable {
+	return &RouteTable{ServeMux: http.NewServeMux()}
+}
+
+// Handle registers h for pattern.
+func (t *RouteTable) Handle(pattern string, h http.Handler) {
+	t.ServeMux.Handle(pattern, h)
+	t.mu.Lock()
+	t.patterns = append(t.patterns, pattern)
+	t.mu.Unlock()
+}
+
+// HandleFunc registers h for pattern.
+func (t *RouteTable) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	t.Handle(pattern, http.HandlerFunc(h))
+}
+
+// Routes returns the registered patterns sorted by path, then method.
+func (t *RouteTable) Routes() []string {
+	t.mu.Lock()
+	out := append([]string(nil), t.patterns...)
+	t.mu.Unlock()
+	path := func(p string) string {
+		if _, after, ok := strings.Cut(p, " "); ok {
+			return after
+		}
+		return p
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if pi, pj := path(out[i]), path(out[j]); pi != pj {
+			return pi < pj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// AdminServer serves the admin endpoints.
+type AdminServer struct {
+	Config *ServerConfig
+	Routes *RouteTable
+	// Token is the bearer token every endpoint requires: admin_token, or a
+	// random one when that is empty (see WriteToken). An empty Token
+	// refuses every request.
+	Token string
+	// CheckTimeout bounds each health check, default 2 seconds.
+	CheckTimeout time.Duration
+
+	started   time.Time
+	mux       *http.ServeMux
+	endpoints []string
+	checks    []adminCheck
+	status    []adminStatus
+}
+
+type adminCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+type adminStatus struct {
+	name string
+	fn   func() any
+}
+
+// NewAdminServer returns an admin server for cfg and the public routes.
+func NewAdminServer(cfg *ServerConfig, routes *RouteTable) *AdminServer {
+	a := &AdminServer{Config: cfg, Routes: routes, Token: cfg.AdminToken, CheckTimeout: 2 * time.Second, started: time.Now(), mux: http.NewServeMux()}
+	if a.Token == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		a.Token = hex.EncodeToString(b)
+	}
+	a.mux.HandleFunc("GET /{$}", a.index)
+	a.Handle("GET /buildinfo", http.HandlerFunc(a.buildInfo))
+	a.Handle("GET /config", http.HandlerFunc(a.config))
+	a.Handle("GET /runtime", http.HandlerFunc(a.runtimeStats))
+	a.Handle("GET /routes", http.HandlerFunc(a.routes))
+	a.Handle("GET /health", http.HandlerFunc(a.health))
+	a.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return a
+}
+
+// WriteToken stores Token in dir/admin-token, readable only by the
+// server's user, and returns the file's path. A file left by an earlier
+// run is replaced rather than rewritten, so it cannot keep a looser mode.
+func (a *AdminServer) WriteToken(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "admin-token")
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(a.Token + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return path, err
+}
+
+// Handle adds an endpoint of another component, e.g. the rewrite dry run;
+// it is listed by the index and sits behind the same token.
+func (a *AdminServer) Handle(pattern string, h http.Handler) {
+	a.mux.Handle(pattern, h)
+	_, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		path = pattern
+	}
+	a.endpoints = append(a.endpoints, path)
+}
+
+// AddCheck adds a health check; an error marks the service unhealthy.
+func (a *AdminServer) AddCheck(name string, fn func(ctx context.Context) error) {
+	a.checks = append(a.checks, adminCheck{name: name, fn: fn})
+}
+
+// AddStatus adds a component whose state is shown by /health.
+func (a *AdminServer) AddStatus(name string, fn func() any) {
+	a.status = append(a.status, adminStatus{name: name, fn: fn})
+}
+
+// Handler returns the admin routes behind the admin token. Unlike
+// BearerAuthMiddleware, an empty token lets nobody in.
+func (a *AdminServer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Token == "" || !hasBearer(r, a.Token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="httpServ admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		a.mux.ServeHTTP(w, r)
+	})
+}
+
+// NewServer returns the http.Server for the admin port. There is no write
+// timeout: CPU profiles and traces stream for as long as asked.
+func (a *AdminServer) NewServer() *http.Server {
+	return &http.Server{
+		Addr:              a.Config.AdminAddr,
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: a.Config.ReadHeaderTimeout,
+		IdleTimeout:       a.Config.IdleTimeout,
+	}
+}
+
+func (a *AdminServer) index(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"endpoints": a.endpoints,
+	})
+}
+
+type buildInfo struct {
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	GoVersion string            `json:"go_version"`
+	Settings  map[string]string `json:"settings"` // vcs.revision, GOOS, -tags, ...
+	Deps      []string          `json:"deps"`     // path@version, "=> replacement" when replaced
+	PID       int               `json:"pid"`
+	Started   time.Time         `json:"started"`
+}
+
+func (a *AdminServer) buildInfo(w http.ResponseWriter, r *http.Request) {
+	out := buildInfo{GoVersion: runtime.Version(), Settings: map[string]string{}, Deps: []string{}, PID: os.Getpid(), Started: a.started}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		out.Path = bi.Main.Path
+		out.Version = bi.Main.Version
+		for _, s := range bi.Settings {
+			out.Settings[s.Key] = s.Value
+		}
+		for _, d := range bi.Deps {
+			dep := d.Path + "@" + d.Version
+			if d.Replace != nil {
+				dep += " => " + d.Replace.Path
+				if d.Replace.Version != "" {
+					dep += "@" + d.Replace.Version
+				}
+			}
+			out.Deps = append(out.Deps, dep)
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, out)
+}
+
+func (a *AdminServer) config(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, map[string]any{"settings": a.Config.Settings()})
+}
+
+func (a *AdminServer) runtimeStats(w http.ResponseWriter, r *http.Request) {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+	values := make(map[string]any, len(samples))
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			values[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			values[s.Name] = jsonFloat(s.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			values[s.Name] = summarize(s.Value.Float64Histogram())
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"goroutines": runtime.NumGoroutine(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"num_cpu":    runtime.NumCPU(),
+		"uptime":     time.Since(a.started).Round(time.Second).String(),
+		"metrics":    values,
+	})
+}
+
+// histogramSummary condenses a runtime/metrics histogram; quantiles are
+// bucket upper bounds.
+type histogramSummary struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+func summarize(h *metrics.Float64Histogram) histogramSummary {
+	var s histogramSummary
+	for _, c := range h.Counts {
+		s.Count += c
+	}
+	if s.Count == 0 {
+		return s
+	}
+	// bound is the finite upper edge of bucket i.
+	bound := func(i int) float64 {
+		if hi := h.Buckets[i+1]; !math.IsInf(hi, 1) {
+			return hi
+		}
+		return jsonFloat(h.Buckets[i])
+	}
+	quantile := func(q float64) float64 {
+		want := uint64(math.Ceil(q * float64(s.Count)))
+		var seen uint64
+		for i, c := range h.Counts {
+			if seen += c; seen >= want && c > 0 {
+				return bound(i)
+			}
+		}
+		return 0
+	}
+	s.P50, s.P90, s.P99 = quantile(0.5), quantile(0.9), quantile(0.99)
+	for i := len(h.Counts) - 1; i >= 0; i-- {
+		if h.Counts[i] > 0 {
+			s.Max = bound(i)
+			break
+		}
+	}
+	return s
+}
+
+// jsonFloat maps NaN and infinities, which JSON cannot encode, to 0.
+func jsonFloat(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return f
+}
+
+func (a *AdminServer) routes(w http.ResponseWriter, r *http.Request) {
+	var routes []string
+	if a.Routes != nil {
+		routes = a.Routes.Routes()
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"routes": routes})
+}
+
+type checkResult struct {
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+func (a *AdminServer) health(w http.ResponseWriter, r *http.Request) {
+	results := make(map[string]checkResult, len(a.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range a.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), a.CheckTimeout)
+			defer cancel()
+			start := time.Now()
+			err := c.fn(ctx)
+			res := checkResult{OK: err == nil, Duration: time.Since(start).Round(time.Microsecond).String()}
+			if err != nil {
+				res.Error = err.Error()
+			}
+			mu.Lock()
+			results[c.name] = res
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, res := range results {
+		if !res.OK {
+			status, code = "failing", http.StatusServiceUnavailable
+		}
+	}
+	components := make(map[string]any, len(a.status))
+	for _, s := range a.status {
+		components[s.name] = s.fn()
+	}
+	writeAdminJSON(w, code, map[string]any{
+		"status":     status,
+		"checks":     results,
+		"components": components,
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("admin: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdminWriteToken(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	a := &AdminServer{Token: "first"}
+	path, err := a.WriteToken(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "admin-token") {
+		t.Errorf("path = %s", path)
+	}
+
+	// A file from an earlier run with a looser mode is replaced.
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a.Token = "second"
+	if _, err := a.WriteToken(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second\n" {
+		t.Errorf("token file = %q", data)
+	}
+	for name, want := range map[string]os.FileMode{path: 0o600, dir: 0o700} {
+		if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != want {
+			t.Errorf("%s: mode %v, %v; want %v", name, fi.Mode().Perm(), err, want)
+		}
+	}
+}
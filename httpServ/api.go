@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"httpServ/jsonrpc"
+	"httpServ/sse"
+	"middleware/openapi"
+	"middleware/typed"
+)
+
+/*
+JSON API with typed handlers
+
+Handlers are plain functions; typed.Handle does the HTTP part (see
+middleware/typed):
+
+	POST /items {"name": "pen", "quantity": 3}
+	  ↓ body → CreateItemReq, Validate()        → 422 problem+json on bad input
+	  ↓ createItem(ctx, req) (Item, error)
+	  ↓ Item → 201 application/json
+
+	GET /items/7          → 200 Item, or 404 problem+json (typed.ErrNotFound)
+	GET /items?limit=abc  → 422 {"pointer": "/query/limit", ...}
+
+The same functions are JSON-RPC methods (RegisterRPC):
+
+	{"jsonrpc": "2.0", "method": "items.get", "params": {"id": 7}, "id": 1}
+	{"jsonrpc": "2.0", "method": "items.get", "params": [7], "id": 2}
+
+Changes are published as item.created / item.deleted events when Events is
+set.
+
+Auth is one policy for both transports: with WriteToken set, creating and
+deleting items needs "Authorization: Bearer <write_token>" whether it comes
+in as POST/DELETE /items or as items.create/items.delete on POST /rpc.
+Reads stay open either way. The check sits in the store methods themselves,
+so a new transport cannot forget it.
+*/
+
+// Item is one stored item.
+type Item struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Quantity  int       `json:"quantity"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateItemReq is the body of POST /items.
+type CreateItemReq struct {
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+func (r CreateItemReq) Validate() error {
+	var v typed.ValidationError
+	if strings.TrimSpace(r.Name) == "" {
+		v.Add("/body/name", "is required")
+	}
+	if r.Quantity < 0 {
+		v.Add("/body/quantity", "must not be negative")
+	}
+	return v.Err()
+}
+
+// GetItemReq addresses one item.
+type GetItemReq struct {
+	ID int `path:"id" json:"id"`
+}
+
+// ListItemsReq pages through items.
+type ListItemsReq struct {
+	Limit int `query:"limit" json:"limit"`
+}
+
+// ItemList is the response of GET /items.
+type ItemList struct {
+	Items []Item `json:"items"`
+}
+
+// ItemStore keeps items in memory.
+type ItemStore struct {
+	Events *sse.Broker // optional
+	// WriteToken, when set, is required to create or delete items.
+	WriteToken string
+
+	mu    sync.Mutex
+	next  int
+	items map[int]Item
+}
+
+// NewItemStore returns an empty store.
+func NewItemStore() *ItemStore {
+	return &ItemStore{next: 1, items: make(map[int]Item)}
+}
+
+// authorizeWrite applies WriteToken to the HTTP request behind ctx, which
+// typed (REST) and jsonrpc both provide.
+func (s *ItemStore) authorizeWrite(ctx context.Context) error {
+	if s.WriteToken == "" {
+		return nil
+	}
+	r := typed.Request(ctx)
+	if r == nil {
+		r = jsonrpc.HTTPRequest(ctx)
+	}
+	if r == nil || !hasBearer(r, s.WriteToken) {
+		return typed.ErrUnauthorized
+	}
+	return nil
+}
+
+func (s *ItemStore) create(ctx context.Context, req CreateItemReq) (Item, error) {
+	if err := s.authorizeWrite(ctx); err != nil {
+		return Item{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, it := range s.items {
+		if strings.EqualFold(it.Name, req.Name) {
+			return Item{}, typed.Errorf(http.StatusConflict, "an item named %q already exists", req.Name)
+		}
+	}
+	it := Item{ID: s.next, Name: req.Name, Quantity: req.Quantity, CreatedAt: time.Now().UTC()}
+	s.items[it.ID] = it
+	s.next++
+	s.publish("item.created", it)
+	return it, nil
+}
+
+func (s *ItemStore) get(ctx context.Context, req GetItemReq) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.items[req.ID]
+	if !ok {
+		return Item{}, fmt.Errorf("item %d: %w", req.ID, typed.ErrNotFound)
+	}
+	return it, nil
+}
+
+func (s *ItemStore) list(ctx context.Context, req ListItemsReq) (ItemList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := ItemList{Items: make([]Item, 0, len(s.items))}
+	for _, it := range s.items {
+		out.Items = append(out.Items, it)
+	}
+	sort.Slice(out.Items, func(i, j int) bool { return out.Items[i].ID < out.Items[j].ID })
+	if req.Limit > 0 && len(out.Items) > req.Limit {
+		out.Items = out.Items[:req.Limit]
+	}
+	return out, nil
+}
+
+func (s *ItemStore) remove(ctx context.Context, req GetItemReq) (typed.Empty, error) {
+	if err := s.authorizeWrite(ctx); err != nil {
+		return typed.Empty{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[req.ID]; !ok {
+		return typed.Empty{}, fmt.Errorf("item %d: %w", req.ID, typed.ErrNotFound)
+	}
+	delete(s.items, req.ID)
+	s.publish("item.deleted", map[string]int{"id": req.ID})
+	return typed.Empty{}, nil
+}
+
+func (s *ItemStore) publish(event string, v any) {
+	if s.Events == nil {
+		return
+	}
+	data, _ := json.Marshal(v)
+	s.Events.Publish(sse.Event{Event: event, Data: string(data)})
+}
+
+// Register adds the item routes to mux; their request and response types
+// document them in the OpenAPI spec.
+func (s *ItemStore) Register(mux *openapi.Mux) {
+	tag := openapi.Tags("items")
+	create := typed.Handle(s.create)
+	create.Status = http.StatusCreated
+	mux.Handle("POST /items", create, tag, openapi.Summary("Create an item"))
+	mux.Handle("GET /items", typed.Handle(s.list), tag, openapi.Summary("List items by ID"))
+	mux.Handle("GET /items/{id}", typed.Handle(s.get), tag, openapi.Summary("Get an item"))
+	mux.Handle("DELETE /items/{id}", typed.Handle(s.remove), tag, openapi.Summary("Delete an item"))
+}
+
+// RegisterRPC adds the item methods (items.create, items.get, items.list,
+// items.delete) to rpc.
+func (s *ItemStore) RegisterRPC(rpc *jsonrpc.Server) {
+	jsonrpc.Register(rpc, "items.create", s.create)
+	jsonrpc.Register(rpc, "items.get", s.get)
+	jsonrpc.Register(rpc, "items.list", s.list)
+	jsonrpc.Register(rpc, "items.delete", s.remove)
+}
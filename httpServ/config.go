@@ -0,0 +1,535 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Server configuration
+
+Every setting can come from four places. Later ones win:
+
+	default  <  config file (YAML or JSON)  <  environment  <  command-line flag
+
+Each setting has one name, spelled the same way in every place:
+
+	file key   read_header_timeout
+	env        HTTPSERV_READ_HEADER_TIMEOUT
+	flag       -read-header-timeout
+
+httpServ -h lists every setting with its env name and default; the list
+is built from the fields in DefaultServerConfig, so it cannot drift.
+
+The config file is chosen with -config or HTTPSERV_CONFIG. JSON is valid
+YAML, so one parser reads both.
+
+Why the timeouts matter: with all of them left at zero a client can open a
+connection and send one header byte every few seconds (slowloris) and hold a
+goroutine and a socket forever.
+
+	ReadHeaderTimeout – time to read the request headers
+	ReadTimeout       – time to read headers + body
+	WriteTimeout      – time from end of headers read to end of response write
+	IdleTimeout       – how long a keep-alive connection may sit unused
+	MaxHeaderBytes    – cap on request header size
+
+Run with -print-config to see the effective values and where each one came from.
+*/
+
+// ServerConfig holds the effective server settings.
+type ServerConfig struct {
+	Addr              string
+	Listen            []string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	DrainDelay        time.Duration
+	MaxHeaderBytes    int
+	MaxConns          int
+	MaxConnsPerIP     int
+
+	TLSCertFiles      []string
+	TLSKeyFiles       []string
+	TLSMinVersion     string
+	TLSCipherSuites   []string
+	TLSReloadInterval time.Duration
+	TLSDev            bool
+	TLSDevDir         string
+
+	H2C       bool
+	HTTP3     bool
+	HTTP3Addr string
+	AccessLog bool
+
+	RestartTimeout time.Duration
+
+	StaticDir     string
+	StaticListing bool
+	SPA           bool
+
+	ProxyConfig string
+
+	ProxyProtocol      bool
+	ProxyTrustedCIDRs  []string
+	ProxyHeaderTimeout time.Duration
+
+	WriteToken string
+	RPCToken   string
+
+	RewriteRules string
+
+	AdminAddr  string
+	AdminToken string
+	StateDir   string
+
+	// PrintConfig is set by -print-config.
+	PrintConfig bool
+	// OpenAPIWrite and OpenAPICheck are set by -openapi-write FILE and
+	// -openapi-check FILE: write the API document, or fail when FILE
+	// differs from it, then exit.
+	OpenAPIWrite string
+	OpenAPICheck string
+
+	fields  []*configField
+	sources map[string]string
+}
+
+// configField describes one setting and how to parse it from text.
+type configField struct {
+	name   string // file key, e.g. "read_timeout"
+	usage  string
+	set    func(string) error
+	get    func() string
+	bool   bool // flag may be given without a value, e.g. -tls-dev
+	secret bool // value is masked by Print
+}
+
+// flagText captures the raw text of a flag; it is applied after the file
+// and the environment so flags win.
+type flagText struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagText) String() string     { return f.value }
+func (f *flagText) Set(s string) error { f.value = s; return nil }
+func (f *flagText) IsBoolFlag() bool   { return f.isBool }
+
+func (f *configField) env() string {
+	return "HTTPSERV_" + strings.ToUpper(f.name)
+}
+
+func (f *configField) flag() string {
+	return strings.ReplaceAll(f.name, "_", "-")
+}
+
+// DefaultServerConfig returns production defaults.
+func DefaultServerConfig() *ServerConfig {
+	c := &ServerConfig{
+		Addr:               ":8080",
+		ReadTimeout:        15 * time.Second,
+		ReadHeaderTimeout:  5 * time.Second,
+		WriteTimeout:       30 * time.Second,
+		IdleTimeout:        120 * time.Second,
+		ShutdownTimeout:    10 * time.Second,
+		MaxHeaderBytes:     1 << 20,
+		TLSMinVersion:      "1.2",
+		TLSReloadInterval:  10 * time.Second,
+		TLSDevDir:          ".devcerts",
+		AccessLog:          true,
+		SPA:                true,
+		RestartTimeout:     30 * time.Second,
+		ProxyHeaderTimeout: 5 * time.Second,
+		AdminAddr:          "127.0.0.1:9091",
+		StateDir:           ".httpserv",
+		sources:            make(map[string]string),
+	}
+	c.fields = []*configField{
+		stringField("addr", "listen address, host:port", &c.Addr),
+		listField("listen", "listener specs, comma-separated (tcp://host:port, unix:///path?mode=0660, unix://@name); overrides addr", &c.Listen),
+		durationField("read_timeout", "max time to read the whole request", &c.ReadTimeout),
+		durationField("read_header_timeout", "max time to read request headers", &c.ReadHeaderTimeout),
+		durationField("write_timeout", "max time to write the response", &c.WriteTimeout),
+		durationField("idle_timeout", "max keep-alive idle time", &c.IdleTimeout),
+		durationField("shutdown_timeout", "graceful shutdown deadline", &c.ShutdownTimeout),
+		durationField("drain_delay", "wait after readiness goes off before shutdown", &c.DrainDelay),
+		intField("max_header_bytes", "max request header size in bytes", &c.MaxHeaderBytes),
+		intField("max_conns", "max open connections, 0 for unlimited", &c.MaxConns),
+		intField("max_conns_per_ip", "max open connections per client IP, 0 for unlimited", &c.MaxConnsPerIP),
+		listField("tls_cert_files", "TLS certificate files, comma-separated (enables HTTPS)", &c.TLSCertFiles),
+		listField("tls_key_files", "TLS key files, same order as tls_cert_files", &c.TLSKeyFiles),
+		stringField("tls_min_version", "minimum TLS version, 1.2 or 1.3", &c.TLSMinVersion),
+		listField("tls_cipher_suites", "TLS 1.2 cipher suites, comma-separated Go names", &c.TLSCipherSuites),
+		durationField("tls_reload_interval", "how often to check certificate files for changes", &c.TLSReloadInterval),
+		boolField("tls_dev", "serve HTTPS with a generated local CA and localhost certificate", &c.TLSDev),
+		stringField("tls_dev_dir", "directory for the generated development certificates", &c.TLSDevDir),
+		boolField("h2c", "accept HTTP/2 without TLS (prior knowledge and Upgrade)", &c.H2C),
+		boolField("http3", "also serve HTTP/3 over QUIC (requires TLS)", &c.HTTP3),
+		stringField("http3_addr", "UDP address for HTTP/3, default addr", &c.HTTP3Addr),
+		boolField("access_log", "log one line per request", &c.AccessLog),
+		durationField("restart_timeout", "how long a restarted process may take to become ready (SIGUSR2)", &c.RestartTimeout),
+		stringField("static_dir", "serve files from this directory instead of the built-in web/ files", &c.StaticDir),
+		boolField("static_listing", "list directories that have no index.html", &c.StaticListing),
+		boolField("spa", "serve index.html for unknown paths without a file extension", &c.SPA),
+		stringField("proxy_config", "gateway pools and routes file (YAML or JSON); enables reverse proxy routes", &c.ProxyConfig),
+		boolField("proxy_protocol", "read PROXY protocol v1/v2 headers from trusted load balancers", &c.ProxyProtocol),
+		listField("proxy_trusted_cidrs", "load balancer addresses allowed to send PROXY headers, comma-separated CIDRs", &c.ProxyTrustedCIDRs),
+		durationField("proxy_header_timeout", "max time to read a PROXY header", &c.ProxyHeaderTimeout),
+		stringField("rewrite_rules", "URL rewrite and redirect rules file (YAML or JSON)", &c.RewriteRules),
+		secretField("rpc_token", "bearer token required on every POST /rpc call, on top of write_token for writes; empty leaves it open", &c.RPCToken),
+		secretField("write_token", "bearer token required to create or delete items, over REST and JSON-RPC alike; empty leaves writes open", &c.WriteToken),
+		stringField("admin_addr", "admin and debug server address (pprof, config, routes, metrics, upstream stats); empty disables it", &c.AdminAddr),
+		secretField("admin_token", "bearer token required by the admin server; needed when admin_addr is not loopback; when empty one is generated and written to state_dir/admin-token (mode 0600)", &c.AdminToken),
+		stringField("state_dir", "directory for files the server writes while running, such as the generated admin token", &c.StateDir),
+	}
+	for _, f := range c.fields {
+		c.sources[f.name] = "default"
+	}
+	return c
+}
+
+func stringField(name, usage string, p *string) *configField {
+	return &configField{name: name, usage: usage,
+		set: func(s string) error { *p = s; return nil },
+		get: func() string { return *p },
+	}
+}
+
+// secretField is a stringField whose value Print does not show.
+func secretField(name, usage string, p *string) *configField {
+	f := stringField(name, usage, p)
+	f.secret = true
+	return f
+}
+
+func durationField(name, usage string, p *time.Duration) *configField {
+	return &configField{name: name, usage: usage,
+		set: func(s string) error {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%q is not a duration (use e.g. 15s, 2m)", s)
+			}
+			*p = d
+			return nil
+		},
+		get: func() string { return p.String() },
+	}
+}
+
+func intField(name, usage string, p *int) *configField {
+	return &configField{name: name, usage: usage,
+		set: func(s string) error {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("%q is not an integer", s)
+			}
+			*p = n
+			return nil
+		},
+		get: func() string { return strconv.Itoa(*p) },
+	}
+}
+
+func boolField(name, usage string, p *bool) *configField {
+	return &configField{name: name, usage: usage,
+		set: func(s string) error {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%q is not a boolean", s)
+			}
+			*p = b
+			return nil
+		},
+		get:  func() string { return strconv.FormatBool(*p) },
+		bool: true,
+	}
+}
+
+// listField reads a comma-separated list (or a YAML sequence in the file).
+func listField(name, usage string, p *[]string) *configField {
+	return &configField{name: name, usage: usage,
+		set: func(s string) error {
+			*p = nil
+			for _, v := range strings.Split(s, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					*p = append(*p, v)
+				}
+			}
+			return nil
+		},
+		get: func() string { return strings.Join(*p, ",") },
+	}
+}
+
+// LoadServerConfig builds the config from defaults, the config file, the
+// environment and args, in that order of precedence. All problems are
+// reported together.
+func LoadServerConfig(args []string, lookupEnv func(string) (string, bool)) (*ServerConfig, error) {
+	c := DefaultServerConfig()
+
+	fs := flag.NewFlagSet("httpServ", flag.ContinueOnError)
+	configPath := fs.String("config", "", "YAML or JSON config file (env HTTPSERV_CONFIG)")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print the effective config and exit")
+	fs.StringVar(&c.OpenAPIWrite, "openapi-write", "", "write the OpenAPI document to this file and exit")
+	fs.StringVar(&c.OpenAPICheck, "openapi-check", "", "exit 1 when this OpenAPI file is out of date")
+	flagVals := make(map[string]*flagText, len(c.fields))
+	for _, f := range c.fields {
+		flagVals[f.name] = &flagText{isBool: f.bool}
+		fs.Var(flagVals[f.name], f.flag(), fmt.Sprintf("%s (env %s, default %q)", f.usage, f.env(), f.get()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	apply := func(f *configField, value, source string) {
+		if err := f.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s from %s: %w", f.name, source, err))
+			return
+		}
+		c.sources[f.name] = source
+	}
+
+	if *configPath == "" {
+		*configPath, _ = lookupEnv("HTTPSERV_CONFIG")
+	}
+	if *configPath != "" {
+		values, err := readConfigFile(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		known := make(map[string]bool, len(c.fields))
+		for _, f := range c.fields {
+			known[f.name] = true
+			if v, ok := values[f.name]; ok {
+				apply(f, v, "file "+*configPath)
+			}
+		}
+		for k := range values {
+			if !known[k] {
+				errs = append(errs, fmt.Errorf("unknown setting %q in %s", k, *configPath))
+			}
+		}
+	}
+
+	for _, f := range c.fields {
+		if v, ok := lookupEnv(f.env()); ok {
+			apply(f, v, "env "+f.env())
+		}
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range c.fields {
+			if f.flag() == fl.Name {
+				apply(f, flagVals[f.name].value, "flag -"+fl.Name)
+			}
+		}
+	})
+
+	if len(errs) == 0 {
+		errs = c.validate()
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return c, nil
+}
+
+// readConfigFile flattens the top level of a YAML/JSON document to strings.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch t := v.(type) {
+		case nil:
+		case []any:
+			parts := make([]string, len(t))
+			for i, p := range t {
+				parts[i] = fmt.Sprint(p)
+			}
+			values[k] = strings.Join(parts, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("config file %s: %s must be a scalar or a list", path, k)
+		default:
+			values[k] = fmt.Sprint(t)
+		}
+	}
+	return values, nil
+}
+
+func (c *ServerConfig) validate() []error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr %q: want host:port, e.g. :8080", c.Addr))
+	}
+	for _, spec := range c.Listen {
+		if _, err := ParseListenerSpec(spec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, d := range []struct {
+		name string
+		v    time.Duration
+	}{
+		{"read_timeout", c.ReadTimeout},
+		{"read_header_timeout", c.ReadHeaderTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"restart_timeout", c.RestartTimeout},
+	} {
+		if d.v <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", d.name, d.v))
+		}
+	}
+	if c.DrainDelay < 0 {
+		errs = append(errs, fmt.Errorf("drain_delay must not be negative, got %s", c.DrainDelay))
+	}
+	if c.ReadHeaderTimeout > c.ReadTimeout {
+		errs = append(errs, fmt.Errorf("read_header_timeout (%s) must not exceed read_timeout (%s)", c.ReadHeaderTimeout, c.ReadTimeout))
+	}
+	if len(c.TLSCertFiles) != len(c.TLSKeyFiles) {
+		errs = append(errs, fmt.Errorf("tls_cert_files has %d entries but tls_key_files has %d", len(c.TLSCertFiles), len(c.TLSKeyFiles)))
+	}
+	if c.TLSDev && len(c.TLSCertFiles) > 0 {
+		errs = append(errs, fmt.Errorf("tls_dev cannot be combined with tls_cert_files"))
+	}
+	if c.TLSMinVersion != "1.2" && c.TLSMinVersion != "1.3" {
+		errs = append(errs, fmt.Errorf("tls_min_version must be 1.2 or 1.3, got %q", c.TLSMinVersion))
+	}
+	if c.TLSReloadInterval <= 0 {
+		errs = append(errs, fmt.Errorf("tls_reload_interval must be positive, got %s", c.TLSReloadInterval))
+	}
+	if c.H2C && c.TLSEnabled() {
+		errs = append(errs, fmt.Errorf("h2c is for plain HTTP; with TLS, HTTP/2 is negotiated automatically"))
+	}
+	if c.HTTP3 && !c.TLSEnabled() {
+		errs = append(errs, fmt.Errorf("http3 requires TLS (tls_cert_files or tls_dev)"))
+	}
+	if c.HTTP3Addr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTP3Addr); err != nil {
+			errs = append(errs, fmt.Errorf("http3_addr %q: want host:port", c.HTTP3Addr))
+		}
+	}
+	if _, err := ParseCIDRs(c.ProxyTrustedCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("proxy_trusted_cidrs: %w", err))
+	}
+	if c.ProxyProtocol && len(c.ProxyTrustedCIDRs) == 0 {
+		errs = append(errs, fmt.Errorf("proxy_protocol requires proxy_trusted_cidrs"))
+	}
+	if c.ProxyHeaderTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("proxy_header_timeout must be positive, got %s", c.ProxyHeaderTimeout))
+	}
+	if c.MaxConns < 0 || c.MaxConnsPerIP < 0 {
+		errs = append(errs, fmt.Errorf("max_conns and max_conns_per_ip must not be negative"))
+	}
+	if c.MaxConns > 0 && c.MaxConnsPerIP > c.MaxConns {
+		errs = append(errs, fmt.Errorf("max_conns_per_ip (%d) must not exceed max_conns (%d)", c.MaxConnsPerIP, c.MaxConns))
+	}
+	if c.MaxHeaderBytes < 4<<10 || c.MaxHeaderBytes > 64<<20 {
+		errs = append(errs, fmt.Errorf("max_header_bytes must be between 4096 and 67108864, got %d", c.MaxHeaderBytes))
+	}
+	if c.AdminAddr != "" {
+		host, _, err := net.SplitHostPort(c.AdminAddr)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("admin_addr %q: want host:port, e.g. 127.0.0.1:9091", c.AdminAddr))
+		case c.AdminToken == "" && !isLoopback(host):
+			errs = append(errs, fmt.Errorf("admin_addr %q is reachable from other hosts; set admin_token or bind it to 127.0.0.1", c.AdminAddr))
+		}
+	}
+	return errs
+}
+
+// isLoopback reports whether host only accepts local connections.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ListenerSpecs returns the parsed listen specs, or addr when none are set.
+// Specs are validated by LoadServerConfig.
+func (c *ServerConfig) ListenerSpecs() []ListenerSpec {
+	if len(c.Listen) == 0 {
+		return []ListenerSpec{{Network: "tcp", Address: c.Addr}}
+	}
+	specs := make([]ListenerSpec, 0, len(c.Listen))
+	for _, s := range c.Listen {
+		spec, _ := ParseListenerSpec(s)
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// TLSEnabled reports whether the server should speak HTTPS.
+func (c *ServerConfig) TLSEnabled() bool {
+	return c.TLSDev || len(c.TLSCertFiles) > 0
+}
+
+// NewServer returns an http.Server using the configured address and limits.
+func (c *ServerConfig) NewServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              c.Addr,
+		Handler:           h,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+}
+
+// Setting is one effective setting and where it came from.
+type Setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Settings lists every setting in declaration order; secret values are
+// masked.
+func (c *ServerConfig) Settings() []Setting {
+	out := make([]Setting, 0, len(c.fields))
+	for _, f := range c.fields {
+		value := f.get()
+		if f.secret && value != "" {
+			value = "********"
+		}
+		out = append(out, Setting{Name: f.name, Value: value, Source: c.sources[f.name]})
+	}
+	return out
+}
+
+// Print writes every setting with its effective value and source.
+func (c *ServerConfig) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range c.Settings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.Value, s.Source)
+	}
+	return tw.Flush()
+}
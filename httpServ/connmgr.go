@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Connection tracking
+
+http.Server reports every state change of every connection through the
+ConnState hook:
+
+	StateNew ──→ StateActive ──→ StateIdle ──→ StateActive ──→ ... ──→ StateClosed
+	                  │                                          (keep-alive)
+	                  └──→ StateHijacked   (WebSocket etc., server lets go)
+
+ConnManager listens to that hook to know how many connections are in which
+state, and wraps each net.Listener so limits are enforced at Accept time,
+before a goroutine or a TLS handshake is spent on the connection:
+
+	Accept ─→ total >= max_conns?         ─→ close an idle keep-alive conn, or refuse
+	       ─→ this IP >= max_conns_per_ip? ─→ close one of ITS idle conns, or refuse
+
+An idle keep-alive connection is the cheapest thing to drop: no request is
+in flight and a well-behaved client simply reconnects. The same rule applies
+on shutdown: idle connections are closed first, active ones are left to
+finish.
+
+Refused connections are closed without a response (the listener sits below
+TLS, so there is no safe way to send an HTTP error). HTTP/3 runs over UDP
+and is not counted here.
+*/
+
+// ConnStats is a snapshot of the tracked connections.
+type ConnStats struct {
+	New      int    `json:"new"`
+	Active   int    `json:"active"`
+	Idle     int    `json:"idle"`
+	Hijacked int    `json:"hijacked"`
+	Total    int    `json:"total"`
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"`
+	Evicted  uint64 `json:"evicted"`
+}
+
+// ConnManager tracks connections by state and caps them in total and per
+// client IP. Zero limits mean unlimited.
+type ConnManager struct {
+	MaxConns      int
+	MaxConnsPerIP int
+
+	mu       sync.Mutex
+	conns    map[*trackedConn]*connEntry
+	perIP    map[string]int
+	accepted uint64
+	rejected uint64
+	evicted  uint64
+}
+
+type connEntry struct {
+	state http.ConnState
+	since time.Time // when the connection entered state
+}
+
+// NewConnManager returns a manager with the given limits.
+func NewConnManager(maxConns, maxPerIP int) *ConnManager {
+	return &ConnManager{
+		MaxConns:      maxConns,
+		MaxConnsPerIP: maxPerIP,
+		conns:         make(map[*trackedConn]*connEntry),
+		perIP:         make(map[string]int),
+	}
+}
+
+// Attach installs the ConnState hook on srv (keeping any existing hook) and
+// closes idle connections as soon as srv.Shutdown starts.
+func (cm *ConnManager) Attach(srv *http.Server) {
+	prev := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		cm.ConnState(c, state)
+		if prev != nil {
+			prev(c, state)
+		}
+	}
+	srv.RegisterOnShutdown(func() { cm.CloseIdle(0) })
+}
+
+// Listener wraps ln so accepted connections are counted and capped.
+func (cm *ConnManager) Listener(ln net.Listener) net.Listener {
+	return &limitListener{Listener: ln, cm: cm}
+}
+
+// ConnState is the http.Server.ConnState hook.
+func (cm *ConnManager) ConnState(c net.Conn, state http.ConnState) {
+	tc := unwrapTracked(c)
+	if tc == nil || state == http.StateClosed {
+		return // closing is accounted for in trackedConn.Close
+	}
+	cm.mu.Lock()
+	if e, ok := cm.conns[tc]; ok {
+		e.state, e.since = state, time.Now()
+	}
+	cm.mu.Unlock()
+}
+
+// Stats returns the current counts.
+func (cm *ConnManager) Stats() ConnStats {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	s := ConnStats{Total: len(cm.conns), Accepted: cm.accepted, Rejected: cm.rejected, Evicted: cm.evicted}
+	for _, e := range cm.conns {
+		switch e.state {
+		case http.StateNew:
+			s.New++
+		case http.StateActive:
+			s.Active++
+		case http.StateIdle:
+			s.Idle++
+		case http.StateHijacked:
+			s.Hijacked++
+		}
+	}
+	return s
+}
+
+// CloseIdle closes up to n idle connections, longest idle first; n <= 0
+// closes all of them. It returns how many were closed.
+func (cm *ConnManager) CloseIdle(n int) int {
+	cm.mu.Lock()
+	victims := cm.idleLocked("")
+	if n > 0 && len(victims) > n {
+		victims = victims[:n]
+	}
+	for _, tc := range victims {
+		cm.forgetLocked(tc)
+	}
+	cm.evicted += uint64(len(victims))
+	cm.mu.Unlock()
+
+	for _, tc := range victims {
+		tc.Conn.Close()
+	}
+	return len(victims)
+}
+
+// MetricsHandler serves the counts in the Prometheus text format.
+func (cm *ConnManager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := cm.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# TYPE httpserv_connections gauge")
+		for _, g := range []struct {
+			state string
+			n     int
+		}{{"new", s.New}, {"active", s.Active}, {"idle", s.Idle}, {"hijacked", s.Hijacked}} {
+			fmt.Fprintf(w, "httpserv_connections{state=%q} %d\n", g.state, g.n)
+		}
+		fmt.Fprintln(w, "# TYPE httpserv_connections_accepted_total counter")
+		fmt.Fprintf(w, "httpserv_connections_accepted_total %d\n", s.Accepted)
+		fmt.Fprintln(w, "# TYPE httpserv_connections_rejected_total counter")
+		fmt.Fprintf(w, "httpserv_connections_rejected_total %d\n", s.Rejected)
+		fmt.Fprintln(w, "# TYPE httpserv_connections_evicted_total counter")
+		fmt.Fprintf(w, "httpserv_connections_evicted_total %d\n", s.Evicted)
+	})
+}
+
+// admit decides whether a new connection from ip may be served. To make
+// room it may pick an idle connection to evict, which the caller closes.
+func (cm *ConnManager) admit(tc *trackedConn) (ok bool, evict *trackedConn) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.MaxConnsPerIP > 0 && tc.ip != "" && cm.perIP[tc.ip] >= cm.MaxConnsPerIP {
+		if evict = cm.oldestIdleLocked(tc.ip); evict == nil {
+			cm.rejected++
+			return false, nil
+		}
+	} else if cm.MaxConns > 0 && len(cm.conns) >= cm.MaxConns {
+		if evict = cm.oldestIdleLocked(""); evict == nil {
+			cm.rejected++
+			return false, nil
+		}
+	}
+	if evict != nil {
+		cm.forgetLocked(evict)
+		cm.evicted++
+	}
+	cm.conns[tc] = &connEntry{state: http.StateNew, since: time.Now()}
+	if tc.ip != "" {
+		cm.perIP[tc.ip]++
+	}
+	cm.accepted++
+	return true, evict
+}
+
+// release forgets tc; it is a no-op when tc was already evicted.
+func (cm *ConnManager) release(tc *trackedConn) {
+	cm.mu.Lock()
+	cm.forgetLocked(tc)
+	cm.mu.Unlock()
+}
+
+func (cm *ConnManager) forgetLocked(tc *trackedConn) {
+	if _, ok := cm.conns[tc]; !ok {
+		return
+	}
+	delete(cm.conns, tc)
+	if tc.ip != "" {
+		if cm.perIP[tc.ip]--; cm.perIP[tc.ip] <= 0 {
+			delete(cm.perIP, tc.ip)
+		}
+	}
+}
+
+// idleLocked lists idle connections (of ip, or all when ip is ""), longest
+// idle first.
+func (cm *ConnManager) idleLocked(ip string) []*trackedConn {
+	var idle []*trackedConn
+	for tc, e := range cm.conns {
+		if e.state == http.StateIdle && (ip == "" || tc.ip == ip) {
+			idle = append(idle, tc)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return cm.conns[idle[i]].since.Before(cm.conns[idle[j]].since)
+	})
+	return idle
+}
+
+func (cm *ConnManager) oldestIdleLocked(ip string) *trackedConn {
+	var oldest *trackedConn
+	for tc, e := range cm.conns {
+		if e.state != http.StateIdle || (ip != "" && tc.ip != ip) {
+			continue
+		}
+		if oldest == nil || e.since.Before(cm.conns[oldest].since) {
+			oldest = tc
+		}
+	}
+	return oldest
+}
+
+// limitListener admits or refuses each accepted connection.
+type limitListener struct {
+	net.Listener
+	cm *ConnManager
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		tc := &trackedConn{Conn: c, cm: l.cm, ip: clientIP(c.RemoteAddr())}
+		ok, evict := l.cm.admit(tc)
+		if evict != nil {
+			evict.Conn.Close()
+		}
+		if !ok {
+			c.Close()
+			continue
+		}
+		return tc, nil
+	}
+}
+
+// trackedConn removes itself from the manager when closed.
+type trackedConn struct {
+	net.Conn
+	cm   *ConnManager
+	ip   string
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.cm.release(c) })
+	return c.Conn.Close()
+}
+
+func (c *trackedConn) NetConn() net.Conn { return c.Conn }
+
+// unwrapTracked finds the trackedConn below c; TLS connections reach
+// ConnState as *tls.Conn wrapping it.
+func unwrapTracked(c net.Conn) *trackedConn {
+	for c != nil {
+		if tc, ok := c.(*trackedConn); ok {
+			return tc
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		c = u.NetConn()
+	}
+	return nil
+}
+
+// clientIP returns the IP of a TCP peer, or "" for unix sockets, which are
+// not capped per client.
+func clientIP(addr net.Addr) string {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a.IP.String()
+	}
+	return ""
+}
@@ -0,0 +1,23 @@
+module httpServ
+
+go 1.24.0
+
+require (
+	github.com/quic-go/quic-go v0.54.0
+	golang.org/x/net v0.42.0
+	gopkg.in/yaml.v3 v3.0.1
+	middleware v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/quic-go/qpack v0.5.1 // indirect
+	go.uber.org/mock v0.5.0 // indirect
+	golang.org/x/crypto v0.40.0 // indirect
+	golang.org/x/mod v0.25.0 // indirect
+	golang.org/x/sync v0.16.0 // indirect
+	golang.org/x/sys v0.34.0 // indirect
+	golang.org/x/text v0.27.0 // indirect
+	golang.org/x/tools v0.34.0 // indirect
+)
+
+replace middleware => ../middleware
@@ -0,0 +1,150 @@
+package httpclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the host while its breaker
+// is open.
+var ErrCircuitOpen = errors.New("httpclient: circuit open")
+
+// BreakerState is the state of a host's circuit breaker.
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOptions configures the per-host breakers.
+type BreakerOptions struct {
+	FailureThreshold int           // consecutive failures that open it, default 5; <0 disables
+	OpenFor          time.Duration // before probing again, default 30s
+	HalfOpenProbes   int           // probes let through, all must succeed, default 1
+}
+
+// DefaultBreakerOptions is used by New.
+var DefaultBreakerOptions = BreakerOptions{
+	FailureThreshold: 5,
+	OpenFor:          30 * time.Second,
+	HalfOpenProbes:   1,
+}
+
+// BreakerStats is a snapshot of one host's breaker.
+type BreakerStats struct {
+	Host     string `json:"host"`
+	State    string `json:"state"`
+	Requests uint64 `json:"requests"`
+	Failures uint64 `json:"failures"`
+	Rejected uint64 `json:"rejected"` // short-circuited while open
+	Opened   uint64 `json:"opened"`   // times it tripped
+}
+
+type outcome int
+
+const (
+	success outcome = iota
+	failure
+	ignored // cancelled by the caller or lost a hedge race
+)
+
+type breaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int // consecutive, while closed
+	openedAt  time.Time
+	probes    int // let through while half-open
+	succeeded int // probes that succeeded
+
+	requests, failed, rejected, opened uint64
+}
+
+// allow admits a request. probe is passed back to done so a result that
+// was let through while closed does not count as a probe.
+func (b *breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.opts.FailureThreshold < 0 {
+		b.requests++
+		return false, nil
+	}
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.opts.OpenFor {
+			b.rejected++
+			return false, ErrCircuitOpen
+		}
+		b.state, b.probes, b.succeeded = StateHalfOpen, 0, 0
+		fallthrough
+	case StateHalfOpen:
+		if b.probes >= b.opts.HalfOpenProbes {
+			b.rejected++
+			return false, ErrCircuitOpen
+		}
+		b.probes++
+		b.requests++
+		return true, nil
+	}
+	b.requests++
+	return false, nil
+}
+
+func (b *breaker) done(probe bool, o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if o == failure {
+		b.failed++
+	}
+	switch {
+	case probe && b.state == StateHalfOpen:
+		switch o {
+		case success:
+			if b.succeeded++; b.succeeded >= b.opts.HalfOpenProbes {
+				b.state, b.failures = StateClosed, 0
+			}
+		case failure:
+			b.trip()
+		case ignored:
+			b.probes-- // let another probe through
+		}
+	case !probe && b.state == StateClosed:
+		switch o {
+		case success:
+			b.failures = 0
+		case failure:
+			if b.failures++; b.opts.FailureThreshold >= 0 && b.failures >= b.opts.FailureThreshold {
+				b.trip()
+			}
+		}
+	}
+}
+
+func (b *breaker) trip() {
+	b.state, b.openedAt, b.failures = StateOpen, b.now(), 0
+	b.opened++
+}
+
+func (b *breaker) stats(host string) BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{Host: host, State: b.state.String(), Requests: b.requests,
+		Failures: b.failed, Rejected: b.rejected, Opened: b.opened}
+}
@@ -0,0 +1,104 @@
+package httpclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// clock is a manual time source for breakers.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time                      { return c.t }
+func (c *clock) advance(d time.Duration)             { c.t = c.t.Add(d) }
+func newBreaker(o BreakerOptions, c *clock) *breaker { return &breaker{opts: o, now: c.now} }
+
+// call runs one request through b with the given outcome and reports
+// whether it was admitted.
+func call(b *breaker, o outcome) bool {
+	probe, err := b.allow()
+	if err != nil {
+		if !errors.Is(err, ErrCircuitOpen) {
+			panic(err)
+		}
+		return false
+	}
+	b.done(probe, o)
+	return true
+}
+
+func TestBreaker(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	b := newBreaker(BreakerOptions{FailureThreshold: 3, OpenFor: 10 * time.Second, HalfOpenProbes: 2}, clk)
+	steps := []struct {
+		name    string
+		advance time.Duration
+		o       outcome
+		allowed bool
+		state   BreakerState
+	}{
+		{"failure 1", 0, failure, true, StateClosed},
+		{"failure 2", 0, failure, true, StateClosed},
+		{"success resets the count", 0, success, true, StateClosed},
+		{"failure 1 again", 0, failure, true, StateClosed},
+		{"ignored does not count", 0, ignored, true, StateClosed},
+		{"failure 2 again", 0, failure, true, StateClosed},
+		{"failure 3 trips", 0, failure, true, StateOpen},
+		{"rejected while open", 9 * time.Second, success, false, StateOpen},
+		{"first probe", time.Second, success, true, StateHalfOpen},
+		{"second probe closes", 0, success, true, StateClosed},
+		{"closed again", 0, failure, true, StateClosed},
+		{"failure 2 once more", 0, failure, true, StateClosed},
+		{"trips again", 0, failure, true, StateOpen},
+		{"probe after OpenFor", 10 * time.Second, failure, true, StateOpen},
+		{"failed probe restarts the wait", 9 * time.Second, success, false, StateOpen},
+	}
+	for _, s := range steps {
+		clk.advance(s.advance)
+		if got := call(b, s.o); got != s.allowed {
+			t.Fatalf("%s: allowed = %v, want %v", s.name, got, s.allowed)
+		}
+		if b.state != s.state {
+			t.Fatalf("%s: state = %v, want %v", s.name, b.state, s.state)
+		}
+	}
+	want := BreakerStats{Host: "h", State: "open", Requests: 13, Failures: 9, Rejected: 2, Opened: 3}
+	if got := b.stats("h"); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	clk := &clock{t: time.Unix(1000, 0)}
+	b := newBreaker(BreakerOptions{FailureThreshold: 1, OpenFor: time.Second, HalfOpenProbes: 1}, clk)
+	call(b, failure)
+	clk.advance(time.Second)
+
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow = %v, %v; want a probe", probe, err)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second request while probing: %v, want ErrCircuitOpen", err)
+	}
+	// A cancelled probe frees its slot for another.
+	b.done(probe, ignored)
+	if b.state != StateHalfOpen {
+		t.Fatalf("state = %v after an ignored probe", b.state)
+	}
+	if !call(b, success) || b.state != StateClosed {
+		t.Fatalf("state = %v, want closed after a good probe", b.state)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(BreakerOptions{FailureThreshold: -1}, &clock{})
+	for range 100 {
+		if !call(b, failure) {
+			t.Fatal("disabled breaker rejected a request")
+		}
+	}
+	if s := b.stats("h"); s.State != "closed" || s.Requests != 100 || s.Opened != 0 {
+		t.Errorf("stats = %+v", s)
+	}
+}
@@ -0,0 +1,332 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client sends requests with retries, per-host circuit breakers, hedging
+// and header propagation. Set the fields before the first request.
+type Client struct {
+	HTTP    *http.Client // does the actual sending; its Timeout should stay 0
+	Retry   RetryPolicy
+	Breaker BreakerOptions
+
+	// AttemptTimeout bounds each attempt (0 = none); the caller's context
+	// still bounds the whole call, retries included.
+	AttemptTimeout time.Duration
+	// HedgeAfter sends another copy of a GET or HEAD that has not answered
+	// after this long (0 = off), up to MaxHedges extra copies.
+	HedgeAfter time.Duration
+	MaxHedges  int
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	retries  atomic.Uint64
+	hedges   atomic.Uint64
+}
+
+// New returns a Client with its own pooled transport and the default
+// retry and breaker settings.
+func New() *Client {
+	return &Client{
+		HTTP:           &http.Client{Transport: NewTransport(TransportOptions{})},
+		Retry:          DefaultRetryPolicy,
+		Breaker:        DefaultBreakerOptions,
+		AttemptTimeout: 10 * time.Second,
+		MaxHedges:      1,
+		breakers:       make(map[string]*breaker),
+	}
+}
+
+// Get is a convenience for a GET bound to ctx.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req. Like http.Client.Do, a non-2xx status is not an error;
+// the last response is returned once retries are exhausted.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	req = req.Clone(ctx)
+	propagate(ctx, req)
+
+	policy := c.retryPolicy()
+	attempts := policy.MaxAttempts
+	if !replayable(req) {
+		attempts = 1
+	}
+	for n := 1; ; n++ {
+		resp, err := c.attempt(ctx, req, policy)
+		if n >= attempts || ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) || !policy.retryable(resp, err) {
+			return resp, err
+		}
+		wait, ok := policy.delay(n, resp)
+		if !ok {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err // the caller would give up while we sleep
+		}
+		discard(resp)
+		c.retries.Add(1)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// attempt sends req once, or races hedged copies of it; policy judges
+// which copy's answer is good enough to win.
+func (c *Client) attempt(ctx context.Context, req *http.Request, policy RetryPolicy) (*http.Response, error) {
+	if c.HedgeAfter <= 0 || c.MaxHedges <= 0 || (req.Method != http.MethodGet && req.Method != http.MethodHead) || !replayable(req) {
+		return c.send(ctx, req)
+	}
+
+	type result struct {
+		resp *http.Response
+		err  error
+		i    int
+	}
+	results := make(chan result, c.MaxHedges+1)
+	var cancels []context.CancelFunc
+	launch := func() {
+		hctx, cancel := context.WithCancel(ctx)
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := c.send(hctx, req)
+			results <- result{resp, err, i}
+		}()
+	}
+	// Losers are cancelled and their late responses thrown away.
+	finish := func(winner int, pending int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+		go func() {
+			for ; pending > 0; pending-- {
+				discard((<-results).resp)
+			}
+		}()
+	}
+
+	launch()
+	pending := 1
+	timer := time.NewTimer(c.HedgeAfter)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) <= c.MaxHedges {
+				launch()
+				pending++
+				c.hedges.Add(1)
+				timer.Reset(c.HedgeAfter)
+			}
+		case r := <-results:
+			pending--
+			good := r.err == nil && !policy.retryable(r.resp, nil)
+			if !good && pending > 0 {
+				discard(r.resp) // another copy may still do better
+				continue
+			}
+			finish(r.i, pending)
+			if r.resp == nil {
+				cancels[r.i]() // nothing left to read
+				return nil, r.err
+			}
+			r.resp.Body = &cancelBody{ReadCloser: r.resp.Body, cancel: cancels[r.i]}
+			return r.resp, r.err
+		}
+	}
+}
+
+// send makes one round trip through the host's breaker.
+func (c *Client) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	b := c.breaker(req.URL.Host)
+	probe, err := b.allow()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", req.URL.Host, err)
+	}
+
+	actx, cancel := ctx, context.CancelFunc(func() {})
+	if c.AttemptTimeout > 0 {
+		actx, cancel = context.WithTimeout(ctx, c.AttemptTimeout)
+	}
+	out := req.Clone(actx)
+	if req.GetBody != nil {
+		if out.Body, err = req.GetBody(); err != nil {
+			cancel()
+			b.done(probe, ignored)
+			return nil, err
+		}
+	}
+	if tp := out.Header.Get("Traceparent"); tp != "" {
+		out.Header.Set("Traceparent", childTraceparent(tp))
+	}
+
+	resp, err := c.HTTP.Do(out)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		b.done(probe, ignored) // the caller (or a hedge winner) gave up
+	case err != nil || resp.StatusCode >= 500:
+		b.done(probe, failure)
+	default:
+		b.done(probe, success)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// retryPolicy is c.Retry with zero fields taken from DefaultRetryPolicy.
+func (c *Client) retryPolicy() RetryPolicy {
+	p := c.Retry
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = DefaultRetryPolicy.MaxRetryAfter
+	}
+	return p
+}
+
+func (c *Client) breaker(host string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.breakers == nil {
+		c.breakers = make(map[string]*breaker)
+	}
+	b, ok := c.breakers[host]
+	if !ok {
+		opts := c.Breaker
+		if opts.FailureThreshold == 0 {
+			opts.FailureThreshold = DefaultBreakerOptions.FailureThreshold
+		}
+		if opts.OpenFor <= 0 {
+			opts.OpenFor = DefaultBreakerOptions.OpenFor
+		}
+		if opts.HalfOpenProbes <= 0 {
+			opts.HalfOpenProbes = DefaultBreakerOptions.HalfOpenProbes
+		}
+		b = &breaker{opts: opts, now: time.Now}
+		c.breakers[host] = b
+	}
+	return b
+}
+
+// Stats is a snapshot of the client's counters.
+type Stats struct {
+	Retries  uint64         `json:"retries"`
+	Hedges   uint64         `json:"hedges"`
+	Breakers []BreakerStats `json:"breakers"`
+}
+
+// Stats returns the retry and hedge counts and every host's breaker.
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	s := Stats{Retries: c.retries.Load(), Hedges: c.hedges.Load()}
+	for host, b := range c.breakers {
+		s.Breakers = append(s.Breakers, b.stats(host))
+	}
+	c.mu.Unlock()
+	sort.Slice(s.Breakers, func(i, j int) bool { return s.Breakers[i].Host < s.Breakers[j].Host })
+	return s
+}
+
+// MetricsHandler serves Stats in the Prometheus text format.
+func (c *Client) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := c.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# TYPE httpclient_retries_total counter")
+		fmt.Fprintf(w, "httpclient_retries_total %d\n", s.Retries)
+		fmt.Fprintln(w, "# TYPE httpclient_hedges_total counter")
+		fmt.Fprintf(w, "httpclient_hedges_total %d\n", s.Hedges)
+		fmt.Fprintln(w, "# TYPE httpclient_breaker_state gauge")
+		for _, b := range s.Breakers {
+			for _, st := range []BreakerState{StateClosed, StateOpen, StateHalfOpen} {
+				v := 0
+				if b.State == st.String() {
+					v = 1
+				}
+				fmt.Fprintf(w, "httpclient_breaker_state{host=%q,state=%q} %d\n", b.Host, st, v)
+			}
+		}
+		for _, m := range []struct {
+			name string
+			get  func(BreakerStats) uint64
+		}{
+			{"httpclient_requests_total", func(b BreakerStats) uint64 { return b.Requests }},
+			{"httpclient_failures_total", func(b BreakerStats) uint64 { return b.Failures }},
+			{"httpclient_breaker_rejected_total", func(b BreakerStats) uint64 { return b.Rejected }},
+			{"httpclient_breaker_opened_total", func(b BreakerStats) uint64 { return b.Opened }},
+		} {
+			fmt.Fprintf(w, "# TYPE %s counter\n", m.name)
+			for _, b := range s.Breakers {
+				fmt.Fprintf(w, "%s{host=%q} %d\n", m.name, b.Host, m.get(b))
+			}
+		}
+	})
+}
+
+// replayable reports whether req may be sent more than once.
+func replayable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// discard drains and closes resp so its connection can be reused.
+func discard(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	io.CopyN(io.Discard, resp.Body, 64<<10)
+	resp.Body.Close()
+}
+
+// cancelBody releases an attempt's context once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
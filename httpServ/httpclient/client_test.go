@@ -0,0 +1,301 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client with short delays and no hedging.
+func newTestClient() *Client {
+	c := New()
+	c.Retry.BaseDelay = time.Millisecond
+	c.Retry.MaxDelay = time.Millisecond
+	return c
+}
+
+// statusServer answers each request with the next status in codes, then
+// repeats the last one.
+func statusServer(t *testing.T, header http.Header, codes ...int) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(hits.Add(1))
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(codes[min(n, len(codes))-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header // on the request
+		codes  []int
+		status int
+		hits   int32
+	}{
+		{"succeeds after 503s", "GET", nil, []int{503, 503, 200}, 200, 3},
+		{"gives up after MaxAttempts", "GET", nil, []int{502}, 502, 3},
+		{"404 is final", "GET", nil, []int{404, 200}, 404, 1},
+		{"500 is final", "PUT", nil, []int{500, 200}, 500, 1},
+		{"POST is not retried", "POST", nil, []int{503, 200}, 503, 1},
+		{"POST with an idempotency key is", "POST", http.Header{"Idempotency-Key": {"k1"}}, []int{503, 200}, 200, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := statusServer(t, nil, tt.codes...)
+			c := newTestClient()
+			req, _ := http.NewRequest(tt.method, srv.URL, strings.NewReader("body"))
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || hits.Load() != tt.hits {
+				t.Errorf("status %d after %d requests, want %d after %d", resp.StatusCode, hits.Load(), tt.status, tt.hits)
+			}
+			if got := c.Stats().Retries; got != uint64(tt.hits-1) {
+				t.Errorf("Retries = %d, want %d", got, tt.hits-1)
+			}
+		})
+	}
+}
+
+func TestRetryAfterHonoured(t *testing.T) {
+	srv, hits := statusServer(t, http.Header{"Retry-After": {"1"}}, 429, 200)
+	c := newTestClient()
+	start := time.Now()
+	resp, err := c.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || hits.Load() != 2 {
+		t.Fatalf("status %d after %d requests", resp.StatusCode, hits.Load())
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %v, before Retry-After", waited)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *Client) context.Context
+	}{
+		{"over MaxRetryAfter", func(c *Client) context.Context {
+			c.Retry.MaxRetryAfter = time.Minute
+			return context.Background()
+		}},
+		{"past the caller's deadline", func(c *Client) context.Context {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			t.Cleanup(cancel)
+			return ctx
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := statusServer(t, http.Header{"Retry-After": {"120"}}, 503, 200)
+			c := newTestClient()
+			c.Retry.MaxRetryAfter = time.Hour
+			resp, err := c.Get(tt.setup(c), srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 503 || hits.Load() != 1 {
+				t.Errorf("status %d after %d requests, want the 503 at once", resp.StatusCode, hits.Load())
+			}
+		})
+	}
+}
+
+func TestBreakerShortCircuits(t *testing.T) {
+	srv, hits := statusServer(t, nil, 500)
+	c := newTestClient()
+	c.Retry.MaxAttempts = 1
+	c.Breaker = BreakerOptions{FailureThreshold: 2, OpenFor: time.Hour}
+	for range 2 {
+		resp, err := c.Get(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := c.Get(context.Background(), srv.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("server saw %d requests, want 2", hits.Load())
+	}
+	s := c.Stats().Breakers
+	if len(s) != 1 || s[0].State != "open" || s[0].Rejected != 1 || s[0].Opened != 1 {
+		t.Errorf("breakers = %+v", s)
+	}
+}
+
+func TestHedging(t *testing.T) {
+	// The first copy hangs until the client gives up on it; the hedge
+	// answers at once.
+	var hits atomic.Int32
+	loserGone := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			<-r.Context().Done()
+			close(loserGone)
+			return
+		}
+		io.WriteString(w, "fast")
+	}))
+	defer srv.Close()
+
+	c := newTestClient()
+	c.HedgeAfter = 20 * time.Millisecond
+	start := time.Now()
+	resp, err := c.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "fast" || time.Since(start) > time.Second {
+		t.Errorf("got %q after %v, want the hedge's answer", body, time.Since(start))
+	}
+	if s := c.Stats(); s.Hedges != 1 || s.Retries != 0 {
+		t.Errorf("stats = %+v, want one hedge", s)
+	}
+	select {
+	case <-loserGone:
+	case <-time.After(2 * time.Second):
+		t.Error("the losing copy was not cancelled")
+	}
+}
+
+func TestHedgingSkipsRetryableAnswers(t *testing.T) {
+	// The first copy answers 503 after the hedge went out; the hedge's 200
+	// is worth waiting for.
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			time.Sleep(40 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(80 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c := newTestClient()
+	c.Retry.MaxAttempts = 1
+	c.HedgeAfter = 20 * time.Millisecond
+	resp, err := c.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want the hedge's 200", resp.StatusCode)
+	}
+}
+
+// failingTransport fails every request and remembers its context.
+type failingTransport struct{ ctx chan context.Context }
+
+func (f failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.ctx <- r.Context()
+	return nil, errors.New("connection refused")
+}
+
+func TestHedgedErrorReleasesContext(t *testing.T) {
+	tr := failingTransport{ctx: make(chan context.Context, 1)}
+	c := newTestClient()
+	c.HTTP = &http.Client{Transport: tr}
+	c.Retry.MaxAttempts = 1
+	c.AttemptTimeout = 0 // the attempt runs on the hedge's own context
+	c.HedgeAfter = time.Hour
+
+	if _, err := c.Get(context.Background(), "http://backend.test/"); err == nil {
+		t.Fatal("no error")
+	}
+	if err := (<-tr.ctx).Err(); err == nil {
+		t.Error("the failed winner's context is still live")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	got := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	c := newTestClient()
+	front := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := c.Get(r.Context(), backend.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}))
+
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"incoming id and trace", http.Header{"X-Request-Id": {"req-1"}, "Traceparent": {tp}, "Baggage": {"user=7"}}},
+		{"no id", http.Header{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header = tt.header
+			w := httptest.NewRecorder()
+			front.ServeHTTP(w, r)
+			out := <-got
+
+			id := w.Header().Get(RequestIDHeader)
+			if want := tt.header.Get(RequestIDHeader); want != "" && id != want || len(id) == 0 {
+				t.Errorf("echoed request ID = %q", id)
+			}
+			if out.Get(RequestIDHeader) != id {
+				t.Errorf("forwarded request ID = %q, want %q", out.Get(RequestIDHeader), id)
+			}
+			if out.Get("Baggage") != tt.header.Get("Baggage") {
+				t.Errorf("Baggage = %q", out.Get("Baggage"))
+			}
+			if tt.header.Get("Traceparent") == "" {
+				if out.Get("Traceparent") != "" {
+					t.Errorf("Traceparent = %q, want none", out.Get("Traceparent"))
+				}
+				return
+			}
+			child := out.Get("Traceparent")
+			if child == tp || child[:36] != tp[:36] || child[52:] != tp[52:] || len(child) != len(tp) {
+				t.Errorf("Traceparent = %q, want a new span of %q", child, tp)
+			}
+		})
+	}
+}
+
+func TestChildTraceparent(t *testing.T) {
+	for _, tp := range []string{"", "garbage", "00-abc-def-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"} {
+		if got := childTraceparent(tp); got != tp {
+			t.Errorf("childTraceparent(%q) = %q, want it unchanged", tp, got)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Package httpclient is the client side of service-to-service calls: a
+// pooled transport plus the things a bare http.Get leaves out.
+//
+//	c := httpclient.New()
+//	resp, err := c.Get(r.Context(), "http://inventory:8080/items/7")
+//
+// Every request goes through these steps:
+//
+//	Do(req)
+//	  ↓ copy X-Request-Id / traceparent / tracestate / baggage from ctx
+//	  ↓ attempt ──→ breaker for req.URL.Host open?   → ErrCircuitOpen, no network
+//	  ↓             per-attempt timeout (AttemptTimeout), a child of req's ctx
+//	  ↓             GET/HEAD slower than HedgeAfter? → send a second copy,
+//	  ↓                                               first good answer wins
+//	  ↓ error, 429, 502, 503, 504 and the request is idempotent?
+//	  ↓     → wait max(backoff, Retry-After), try again (Retry.MaxAttempts)
+//	  ↓ response
+//
+// Backoff is exponential with full jitter: attempt n waits a random time in
+// [0, min(MaxDelay, BaseDelay·2ⁿ⁻¹)], so clients that failed together do
+// not retry together. A Retry-After longer than MaxRetryAfter, or one that
+// would outlive the caller's deadline, ends the retries and the response is
+// returned as is.
+//
+// The circuit breaker is kept per host:
+//
+//	closed ──(FailureThreshold consecutive failures)──→ open
+//	open ──(OpenFor elapsed)──→ half-open: HalfOpenProbes requests go through
+//	half-open ──(all probes succeed)──→ closed
+//	half-open ──(a probe fails)──→ open
+//
+// A failure is a transport error or a 5xx; a request the caller cancelled
+// counts neither way. Stats and MetricsHandler report the breakers, retries
+// and hedges.
+//
+// Retries and hedges only happen for idempotent methods (GET, HEAD,
+// OPTIONS, TRACE, PUT, DELETE) or requests with an Idempotency-Key, and
+// only if the body can be replayed (req.GetBody, set by http.NewRequest for
+// in-memory bodies).
+//
+// Propagation starts on the server: Middleware stores the incoming request
+// ID (generating one when missing) and trace headers in the context, and
+// the client copies them onto every outgoing request made with that
+// context. Each attempt gets its own traceparent span ID.
+package httpclient
@@ -0,0 +1,80 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries the ID that ties log lines across services.
+const RequestIDHeader = "X-Request-Id"
+
+// PropagatedHeaders are copied from the incoming request's context onto
+// outgoing requests (W3C Trace Context and Baggage, plus the request ID).
+var PropagatedHeaders = []string{RequestIDHeader, "Traceparent", "Tracestate", "Baggage"}
+
+type headersKey struct{}
+
+// WithHeaders returns a context carrying the PropagatedHeaders found in h.
+func WithHeaders(ctx context.Context, h http.Header) context.Context {
+	keep := make(http.Header)
+	for _, name := range PropagatedHeaders {
+		if v := h.Get(name); v != "" {
+			keep.Set(name, v)
+		}
+	}
+	return context.WithValue(ctx, headersKey{}, keep)
+}
+
+// RequestID returns the request ID stored in ctx, or "".
+func RequestID(ctx context.Context) string {
+	h, _ := ctx.Value(headersKey{}).(http.Header)
+	return h.Get(RequestIDHeader)
+}
+
+// Middleware stores the propagated headers of each request in its context
+// so the client can forward them. A request without an ID gets a new one,
+// which is also echoed in the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = newID(16)
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithHeaders(r.Context(), r.Header)))
+	})
+}
+
+// propagate sets the headers from ctx that req does not already have.
+func propagate(ctx context.Context, req *http.Request) {
+	h, _ := ctx.Value(headersKey{}).(http.Header)
+	for name, v := range h {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = v
+		}
+	}
+}
+
+// childTraceparent keeps the trace ID and flags of a traceparent and gives
+// it a new parent (span) ID: each attempt is its own outgoing call.
+//
+//	00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+//	   └───────── trace id ───────────┘ └─ span id ───┘ flags
+func childTraceparent(tp string) string {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return tp
+	}
+	parts[2] = newID(8)
+	return strings.Join(parts, "-")
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
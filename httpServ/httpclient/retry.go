@@ -0,0 +1,85 @@
+package httpclient
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether and when to try a request again. Zero fields
+// take their value from DefaultRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts   int           // including the first; 1 disables retries, default 3
+	BaseDelay     time.Duration // default 100ms
+	MaxDelay      time.Duration // backoff cap, default 5s
+	MaxRetryAfter time.Duration // longer Retry-After values are not waited for, default 30s
+
+	// Retryable reports whether a result is worth another attempt. The
+	// default retries transport errors and 429, 502, 503 and 504.
+	Retryable func(resp *http.Response, err error) bool
+}
+
+// DefaultRetryPolicy is used by New.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   3,
+	BaseDelay:     100 * time.Millisecond,
+	MaxDelay:      5 * time.Second,
+	MaxRetryAfter: 30 * time.Second,
+}
+
+func (p RetryPolicy) retryable(resp *http.Response, err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(resp, err)
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// delay returns how long to wait before attempt n+1, or false when the
+// server asked for a longer pause than MaxRetryAfter.
+func (p RetryPolicy) delay(n int, resp *http.Response) (time.Duration, bool) {
+	d := p.backoff(n)
+	if resp != nil {
+		if ra, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if ra > p.MaxRetryAfter {
+				return 0, false
+			}
+			d = max(d, ra)
+		}
+	}
+	return d, true
+}
+
+// backoff is full jitter: uniform in [0, min(MaxDelay, BaseDelay·2ⁿ⁻¹)].
+func (p RetryPolicy) backoff(n int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < n && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, p.MaxDelay)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// retryAfter parses a Retry-After value: delay-seconds or an HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
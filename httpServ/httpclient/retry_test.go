@@ -0,0 +1,98 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		v    string
+		want time.Duration
+		ok   bool
+	}{
+		{"seconds", "120", 2 * time.Minute, true},
+		{"zero", "0", 0, true},
+		{"http date", "Wed, 01 May 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"rfc 850 date", "Wednesday, 01-May-24 12:01:00 GMT", time.Minute, true},
+		{"date in the past", "Wed, 01 May 2024 11:00:00 GMT", 0, true},
+		{"empty", "", 0, false},
+		{"negative", "-5", 0, false},
+		{"fraction", "1.5", 0, false},
+		{"garbage", "soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.v, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.v, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for _, tt := range []struct {
+		n       int
+		ceiling time.Duration
+	}{{1, 100 * time.Millisecond}, {2, 200 * time.Millisecond}, {4, 800 * time.Millisecond}, {5, time.Second}, {30, time.Second}} {
+		for range 200 {
+			if d := p.backoff(tt.n); d < 0 || d > tt.ceiling {
+				t.Fatalf("backoff(%d) = %v, want within [0, %v]", tt.n, d, tt.ceiling)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("backoff with no delays = %v", d)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetryAfter: 10 * time.Second}
+	withRetryAfter := func(v string) *http.Response {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {v}}}
+	}
+	tests := []struct {
+		name string
+		resp *http.Response
+		min  time.Duration
+		max  time.Duration
+		ok   bool
+	}{
+		{"no response", nil, 0, time.Millisecond, true},
+		{"no header", &http.Response{Header: http.Header{}}, 0, time.Millisecond, true},
+		{"retry-after wins over backoff", withRetryAfter("3"), 3 * time.Second, 3 * time.Second, true},
+		{"retry-after at the cap", withRetryAfter("10"), 10 * time.Second, 10 * time.Second, true},
+		{"retry-after over the cap", withRetryAfter("11"), 0, 0, false},
+		{"unparsable retry-after", withRetryAfter("later"), 0, time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := p.delay(1, tt.resp)
+			if ok != tt.ok || d < tt.min || d > tt.max {
+				t.Errorf("delay = %v, %v; want [%v, %v], %v", d, ok, tt.min, tt.max, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	var p RetryPolicy
+	for code, want := range map[int]bool{200: false, 404: false, 500: false, 429: true, 502: true, 503: true, 504: true} {
+		if got := p.retryable(&http.Response{StatusCode: code}, nil); got != want {
+			t.Errorf("retryable(%d) = %v, want %v", code, got, want)
+		}
+	}
+	if !p.retryable(nil, errors.New("connection reset")) {
+		t.Error("transport error not retryable")
+	}
+	p.Retryable = func(resp *http.Response, err error) bool { return resp != nil && resp.StatusCode == 500 }
+	if !p.retryable(&http.Response{StatusCode: 500}, nil) || p.retryable(&http.Response{StatusCode: 503}, nil) {
+		t.Error("custom Retryable not used")
+	}
+}
@@ -0,0 +1,415 @@
+// Package jsonrpc serves JSON-RPC 2.0 over HTTP.
+//
+//	rpc := jsonrpc.NewServer()
+//	jsonrpc.Register(rpc, "items.get", items.get) // func(ctx, GetItemReq) (Item, error)
+//	mux.Handle("POST /rpc", AuthMiddleware(rpc))
+//
+// One POST carries a call, a notification (no "id": no answer) or a batch
+// (a JSON array of those):
+//
+//	→ {"jsonrpc": "2.0", "method": "items.get", "params": {"id": 7}, "id": 1}
+//	← {"jsonrpc": "2.0", "result": {"id": 7, ...}, "id": 1}
+//
+//	→ [{...call id 1...}, {...notification...}, {...call id 2...}]
+//	← [{...result id 1...}, {...result id 2...}]   (only a notification batch → 204)
+//
+// Params may be an object (decoded into the params type) or an array
+// (positional: mapped to the struct fields in order, or decoded into a
+// slice type). If the params type has a Validate() error method it runs
+// before the call.
+//
+// Errors use the spec's codes:
+//
+//	-32700 parse error        body is not JSON
+//	-32600 invalid request    not a request object, empty or oversized batch
+//	-32601 method not found
+//	-32602 invalid params     wrong shape, or a *typed.ValidationError (details in data)
+//	-32603 internal error     unexpected error (logged, not sent)
+//	-32000 server error       typed errors and sentinels (typed.ErrNotFound ...), HTTP status in data
+//
+// Return an *Error to choose the code yourself. "rpc.discover" lists the
+// registered methods with their params and result types.
+//
+// Because the Server is an http.Handler, authentication, logging and the
+// rest of the middleware chain wrap it like any other endpoint.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"middleware/typed"
+)
+
+// Spec error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("jsonrpc %d: %s", e.Code, e.Message) }
+
+// Server dispatches JSON-RPC requests to registered methods.
+type Server struct {
+	MaxBatch int         // default 100
+	MaxBody  int64       // default 1 MiB
+	Logger   *log.Logger // internal errors, and every call when LogCalls is set
+	LogCalls bool
+
+	mu      sync.RWMutex
+	methods map[string]*method
+}
+
+type method struct {
+	info MethodInfo
+	call func(ctx context.Context, params json.RawMessage) (any, error)
+}
+
+// MethodInfo describes a registered method (see rpc.discover).
+type MethodInfo struct {
+	Name   string `json:"name"`
+	Params string `json:"params"`
+	Result string `json:"result"`
+}
+
+// NewServer returns a Server with rpc.discover registered.
+func NewServer() *Server {
+	s := &Server{MaxBatch: 100, MaxBody: 1 << 20, Logger: log.Default(), methods: make(map[string]*method)}
+	Register(s, "rpc.discover", func(ctx context.Context, _ struct{}) ([]MethodInfo, error) {
+		return s.Methods(), nil
+	})
+	return s
+}
+
+// Register adds a typed method. Registering a name twice replaces it.
+func Register[P, R any](s *Server, name string, fn func(ctx context.Context, params P) (R, error)) {
+	s.add(name, reflect.TypeFor[P](), reflect.TypeFor[R](), func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var p P
+		if err := decodeParams(raw, &p); err != nil {
+			return nil, err
+		}
+		if v, ok := any(&p).(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		return fn(ctx, p)
+	})
+}
+
+var (
+	ctxType   = reflect.TypeFor[context.Context]()
+	errorType = reflect.TypeFor[error]()
+)
+
+// RegisterService adds every exported method of rcvr shaped like
+// func(context.Context, P) (R, error) as "prefix.Method". Other methods
+// are skipped. It fails when nothing matches.
+func (s *Server) RegisterService(prefix string, rcvr any) error {
+	v := reflect.ValueOf(rcvr)
+	t := v.Type()
+	n := 0
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		ft := m.Type // includes the receiver
+		if ft.NumIn() != 3 || ft.NumOut() != 2 || ft.In(1) != ctxType || ft.Out(1) != errorType {
+			continue
+		}
+		fn := v.Method(i)
+		pt := ft.In(2)
+		s.add(prefix+"."+m.Name, pt, ft.Out(0), func(ctx context.Context, raw json.RawMessage) (any, error) {
+			p := reflect.New(pt)
+			if err := decodeParams(raw, p.Interface()); err != nil {
+				return nil, err
+			}
+			if val, ok := p.Interface().(interface{ Validate() error }); ok {
+				if err := val.Validate(); err != nil {
+					return nil, err
+				}
+			}
+			out := fn.Call([]reflect.Value{reflect.ValueOf(ctx), p.Elem()})
+			err, _ := out[1].Interface().(error)
+			return out[0].Interface(), err
+		})
+		n++
+	}
+	if n == 0 {
+		return fmt.Errorf("jsonrpc: %T has no methods of the form func(context.Context, P) (R, error)", rcvr)
+	}
+	return nil
+}
+
+func (s *Server) add(name string, params, result reflect.Type, call func(context.Context, json.RawMessage) (any, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[name] = &method{
+		info: MethodInfo{Name: name, Params: params.String(), Result: result.String()},
+		call: call,
+	}
+}
+
+// Methods lists the registered methods by name.
+func (s *Server) Methods() []MethodInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]MethodInfo, 0, len(s.methods))
+	for _, m := range s.methods {
+		out = append(out, m.info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Request is a call, or a notification when ID is absent.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Response carries Result or Error for the call with the same ID.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var nullID = json.RawMessage("null")
+
+type requestKey struct{}
+
+// HTTPRequest returns the HTTP request a call arrived in.
+func HTTPRequest(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestKey{}).(*http.Request)
+	return r
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "JSON-RPC needs POST", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, s.MaxBody+1))
+	if err != nil {
+		writeJSON(w, Response{Error: &Error{Code: CodeInvalidRequest, Message: "could not read request body"}, ID: nullID})
+		return
+	}
+	if int64(len(body)) > s.MaxBody {
+		writeJSON(w, Response{Error: &Error{Code: CodeInvalidRequest, Message: "request too large"}, ID: nullID})
+		return
+	}
+	ctx := context.WithValue(r.Context(), requestKey{}, r)
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, Response{Error: &Error{Code: CodeParseError, Message: "parse error"}, ID: nullID})
+			return
+		}
+		if len(batch) == 0 || len(batch) > s.MaxBatch {
+			writeJSON(w, Response{Error: &Error{Code: CodeInvalidRequest, Message: fmt.Sprintf("batch must hold 1 to %d requests", s.MaxBatch)}, ID: nullID})
+			return
+		}
+		// The spec allows any order; running calls in sequence keeps
+		// "create then list" batches predictable.
+		var out []*Response
+		for _, raw := range batch {
+			if res := s.handle(ctx, raw); res != nil {
+				out = append(out, res)
+			}
+		}
+		if len(out) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, out)
+		return
+	}
+
+	if !json.Valid(body) {
+		writeJSON(w, Response{Error: &Error{Code: CodeParseError, Message: "parse error"}, ID: nullID})
+		return
+	}
+	res := s.handle(ctx, body)
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, res)
+}
+
+// handle runs one request; it returns nil for notifications.
+func (s *Server) handle(ctx context.Context, raw json.RawMessage) *Response {
+	var req Request
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" || !validID(req.ID) || !validParams(req.Params) {
+		return &Response{JSONRPC: "2.0", Error: &Error{Code: CodeInvalidRequest, Message: "invalid request"}, ID: idOrNull(req.ID)}
+	}
+	notification := req.ID == nil
+
+	s.mu.RLock()
+	m := s.methods[req.Method]
+	s.mu.RUnlock()
+
+	start := time.Now()
+	var result any
+	var rpcErr *Error
+	if m == nil {
+		rpcErr = &Error{Code: CodeMethodNotFound, Message: "method not found: " + req.Method}
+	} else {
+		var err error
+		result, err = safeCall(ctx, m, req.Params)
+		if err != nil {
+			rpcErr = s.toError(ctx, req.Method, err)
+		}
+	}
+	if s.LogCalls && s.Logger != nil {
+		code := 0
+		if rpcErr != nil {
+			code = rpcErr.Code
+		}
+		s.Logger.Printf("jsonrpc: %s code=%d %s", req.Method, code, time.Since(start))
+	}
+
+	if notification {
+		return nil
+	}
+	if rpcErr != nil {
+		return &Response{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+	}
+	if result == nil {
+		result = json.RawMessage("null") // "result" is required on success
+	}
+	return &Response{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+// safeCall turns a panicking method into an internal error.
+func safeCall(ctx context.Context, m *method, params json.RawMessage) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return m.call(ctx, params)
+}
+
+// toError maps a method's error to a JSON-RPC error object.
+func (s *Server) toError(ctx context.Context, method string, err error) *Error {
+	var rpcErr *Error
+	var verr *typed.ValidationError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.As(err, &verr):
+		return &Error{Code: CodeInvalidParams, Message: "invalid params", Data: verr.Details}
+	}
+	p, internal := typed.ProblemFor(err, HTTPRequest(ctx))
+	if internal {
+		if s.Logger != nil {
+			s.Logger.Printf("jsonrpc: %s: %v", method, err)
+		}
+		return &Error{Code: CodeInternalError, Message: "internal error"}
+	}
+	msg := p.Detail
+	if msg == "" {
+		msg = http.StatusText(p.Status)
+	}
+	return &Error{Code: CodeServerError, Message: msg, Data: map[string]int{"status": p.Status}}
+}
+
+// decodeParams fills dst (a pointer) from an object or a positional array.
+func decodeParams(raw json.RawMessage, dst any) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil
+	}
+	invalid := func(err error) error {
+		return &Error{Code: CodeInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	if raw[0] == '[' && v.Kind() == reflect.Struct {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return invalid(err)
+		}
+		var fields []reflect.Value
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.IsExported() && f.Tag.Get("json") != "-" {
+				fields = append(fields, v.Field(i))
+			}
+		}
+		if len(items) > len(fields) {
+			return invalid(fmt.Errorf("got %d positional params, want at most %d", len(items), len(fields)))
+		}
+		for i, item := range items {
+			if err := json.Unmarshal(item, fields[i].Addr().Interface()); err != nil {
+				return invalid(fmt.Errorf("param %d: %w", i, err))
+			}
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return invalid(err)
+	}
+	return nil
+}
+
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true // notification
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+func validParams(p json.RawMessage) bool {
+	p = bytes.TrimSpace(p)
+	return len(p) == 0 || p[0] == '{' || p[0] == '['
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if id == nil || !validID(id) {
+		return nullID
+	}
+	return id
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	if res, ok := v.(Response); ok && res.JSONRPC == "" {
+		res.JSONRPC = "2.0"
+		v = res
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,226 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"middleware/typed"
+)
+
+type addParams struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type nameParams struct {
+	Name string `json:"name"`
+}
+
+func (p nameParams) Validate() error {
+	var v typed.ValidationError
+	if p.Name == "" {
+		v.Add("/params/name", "is required")
+	}
+	return v.Err()
+}
+
+type mathService struct{}
+
+func (mathService) Mul(ctx context.Context, p addParams) (int, error) { return p.A * p.B, nil }
+func (mathService) Helper() int                                       { return 0 }
+
+func newTestServer(notified *atomic.Int64) *Server {
+	s := NewServer()
+	s.Logger = log.New(io.Discard, "", 0)
+	s.MaxBatch = 3
+	s.MaxBody = 1024
+	Register(s, "add", func(ctx context.Context, p addParams) (int, error) { return p.A + p.B, nil })
+	Register(s, "sum", func(ctx context.Context, p []int) (int, error) {
+		n := 0
+		for _, v := range p {
+			n += v
+		}
+		return n, nil
+	})
+	Register(s, "hello", func(ctx context.Context, p nameParams) (string, error) { return "hello " + p.Name, nil })
+	Register(s, "notify", func(ctx context.Context, _ struct{}) (typed.Empty, error) {
+		notified.Add(1)
+		return typed.Empty{}, nil
+	})
+	Register(s, "missing", func(ctx context.Context, _ struct{}) (int, error) {
+		return 0, typed.ErrNotFound
+	})
+	Register(s, "custom", func(ctx context.Context, _ struct{}) (int, error) {
+		return 0, &Error{Code: 42, Message: "custom"}
+	})
+	Register(s, "boom", func(ctx context.Context, _ struct{}) (int, error) { panic("boom") })
+	Register(s, "oops", func(ctx context.Context, _ struct{}) (int, error) { return 0, errors.New("db down") })
+	Register(s, "nothing", func(ctx context.Context, _ struct{}) (any, error) { return nil, nil })
+	if err := s.RegisterService("math", mathService{}); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		want     string // JSON, compared semantically; "" for no body
+		notified int64
+	}{
+		{"call with named params", `{"jsonrpc": "2.0", "method": "add", "params": {"a": 1, "b": 2}, "id": 1}`,
+			200, `{"jsonrpc": "2.0", "result": 3, "id": 1}`, 0},
+		{"call with positional params", `{"jsonrpc": "2.0", "method": "add", "params": [4, 5], "id": "x"}`,
+			200, `{"jsonrpc": "2.0", "result": 9, "id": "x"}`, 0},
+		{"positional params into a slice", `{"jsonrpc": "2.0", "method": "sum", "params": [1, 2, 3], "id": 1}`,
+			200, `{"jsonrpc": "2.0", "result": 6, "id": 1}`, 0},
+		{"null result is sent", `{"jsonrpc": "2.0", "method": "nothing", "id": 1}`,
+			200, `{"jsonrpc": "2.0", "result": null, "id": 1}`, 0},
+		{"service method", `{"jsonrpc": "2.0", "method": "math.Mul", "params": {"a": 3, "b": 4}, "id": 1}`,
+			200, `{"jsonrpc": "2.0", "result": 12, "id": 1}`, 0},
+		{"notification", `{"jsonrpc": "2.0", "method": "notify"}`,
+			204, "", 1},
+
+		{"empty batch", `[]`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "batch must hold 1 to 3 requests"}, "id": null}`, 0},
+		{"batch over the limit", `[{}, {}, {}, {}]`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "batch must hold 1 to 3 requests"}, "id": null}`, 0},
+		{"notifications-only batch", `[{"jsonrpc": "2.0", "method": "notify"}, {"jsonrpc": "2.0", "method": "notify"}]`,
+			204, "", 2},
+		{"mixed batch answers calls in order", `[
+			{"jsonrpc": "2.0", "method": "add", "params": [1, 1], "id": 1},
+			{"jsonrpc": "2.0", "method": "notify"},
+			{"jsonrpc": "2.0", "method": "add", "params": [2, 2], "id": 2}]`,
+			200, `[{"jsonrpc": "2.0", "result": 2, "id": 1}, {"jsonrpc": "2.0", "result": 4, "id": 2}]`, 1},
+		{"batch with an invalid entry", `[1, {"jsonrpc": "2.0", "method": "add", "params": [1, 1], "id": 1}]`,
+			200, `[{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": null},
+				{"jsonrpc": "2.0", "result": 2, "id": 1}]`, 0},
+		{"batch that is not JSON", `[{"jsonrpc": "2.0",`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "parse error"}, "id": null}`, 0},
+
+		{"parse error", `{"jsonrpc": "2.0", "method"`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "parse error"}, "id": null}`, 0},
+		{"empty body", ``,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "parse error"}, "id": null}`, 0},
+		{"wrong version", `{"jsonrpc": "1.0", "method": "add", "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": 1}`, 0},
+		{"scalar params", `{"jsonrpc": "2.0", "method": "add", "params": 1, "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": 1}`, 0},
+		{"object id", `{"jsonrpc": "2.0", "method": "add", "id": {}}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": null}`, 0},
+		{"method not found", `{"jsonrpc": "2.0", "method": "nope", "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32601, "message": "method not found: nope"}, "id": 1}`, 0},
+		{"unknown param", `{"jsonrpc": "2.0", "method": "add", "params": {"c": 1}, "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "invalid params: json: unknown field \"c\""}, "id": 1}`, 0},
+		{"too many positional params", `{"jsonrpc": "2.0", "method": "add", "params": [1, 2, 3], "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "invalid params: got 3 positional params, want at most 2"}, "id": 1}`, 0},
+		{"validation error", `{"jsonrpc": "2.0", "method": "hello", "params": {}, "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "invalid params",
+				"data": [{"pointer": "/params/name", "message": "is required"}]}, "id": 1}`, 0},
+		{"typed sentinel", `{"jsonrpc": "2.0", "method": "missing", "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32000, "message": "not found", "data": {"status": 404}}, "id": 1}`, 0},
+		{"custom error", `{"jsonrpc": "2.0", "method": "custom", "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": 42, "message": "custom"}, "id": 1}`, 0},
+		{"panic", `{"jsonrpc": "2.0", "method": "boom", "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "internal error"}, "id": 1}`, 0},
+		{"unexpected error is not leaked", `{"jsonrpc": "2.0", "method": "oops", "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "internal error"}, "id": 1}`, 0},
+		{"body over MaxBody", `{"jsonrpc": "2.0", "method": "sum", "params": [` + strings.Repeat("1,", 600) + `1], "id": 1}`,
+			200, `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "request too large"}, "id": null}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var notified atomic.Int64
+			s := newTestServer(&notified)
+			rr := httptest.NewRecorder()
+			s.ServeHTTP(rr, httptest.NewRequest("POST", "/rpc", strings.NewReader(tt.body)))
+
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d", rr.Code, tt.status)
+			}
+			if tt.want == "" {
+				if rr.Body.Len() != 0 {
+					t.Errorf("body = %q, want none", rr.Body.String())
+				}
+			} else {
+				assertJSON(t, rr.Body.Bytes(), tt.want)
+			}
+			if got := notified.Load(); got != tt.notified {
+				t.Errorf("notify ran %d times, want %d", got, tt.notified)
+			}
+		})
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestServeHTTPBodyReadError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	NewServer().ServeHTTP(rr, httptest.NewRequest("POST", "/rpc", failingReader{}))
+	assertJSON(t, rr.Body.Bytes(), `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "could not read request body"}, "id": null}`)
+}
+
+func TestServeHTTPMethod(t *testing.T) {
+	rr := httptest.NewRecorder()
+	NewServer().ServeHTTP(rr, httptest.NewRequest("GET", "/rpc", nil))
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "POST" {
+		t.Errorf("GET: status %d, Allow %q; want 405, POST", rr.Code, rr.Header().Get("Allow"))
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	var notified atomic.Int64
+	s := newTestServer(&notified)
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"jsonrpc": "2.0", "method": "rpc.discover", "id": 1}`)))
+	var res struct {
+		Result []MethodInfo `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range res.Result {
+		names = append(names, m.Name)
+		if m.Name == "add" && (m.Params != "jsonrpc.addParams" || m.Result != "int") {
+			t.Errorf("add described as %+v", m)
+		}
+	}
+	want := []string{"add", "boom", "custom", "hello", "math.Mul", "missing", "nothing", "notify", "oops", "rpc.discover", "sum"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("methods = %q, want %q", names, want)
+	}
+}
+
+func TestRegisterServiceWithoutMethods(t *testing.T) {
+	if err := NewServer().RegisterService("x", struct{}{}); err == nil {
+		t.Error("RegisterService accepted a value without RPC methods")
+	}
+}
+
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("body is not JSON: %v (%q)", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("bad expectation: %v", err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("body = %s\nwant   %s", got, want)
+	}
+}
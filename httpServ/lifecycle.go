@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*
+Lifecycle: run servers + background components, stop them in order
+
+	Run()
+	  ↓  start every server and component, readiness ON
+	  ↓  wait: SIGINT / SIGTERM / ctx cancelled / Stop() / something failed
+	  ↓  readiness OFF      → load balancer stops sending new traffic
+	  ↓  sleep DrainDelay   → give it time to notice
+	  ↓  srv.Shutdown(ctx)  → finish in-flight requests within ShutdownTimeout
+	  ↓  cancel components  → background workers stop
+	  ↓  shutdown hooks     → reverse registration order, each with its own timeout
+	  ↓
+	return errors.Join(every failure)
+
+A second signal while shutting down, draining included, skips the rest of
+the grace period and closes connections immediately; Run then returns a
+*ForcedShutdownError and ExitCode turns it into 128+signal, as a shell
+would report a process killed by that signal. Failures exit 1, a clean
+shutdown 0.
+
+http.ErrServerClosed is what ListenAndServe returns after Shutdown: it means
+"stopped on purpose", not a failure.
+*/
+
+// Lifecycle runs servers and components and shuts them down in order.
+type Lifecycle struct {
+	DrainDelay      time.Duration // wait after readiness goes off, before Shutdown
+	ShutdownTimeout time.Duration // deadline for servers and components to stop
+	HookTimeout     time.Duration // default per-hook timeout
+	Signals         []os.Signal   // default SIGINT, SIGTERM
+	Logger          *log.Logger
+
+	ready      atomic.Bool
+	stop       chan string
+	onReady    []func()
+	servers    []*lifecycleServer
+	components []lifecycleComponent
+	hooks      []lifecycleHook
+}
+
+type lifecycleServer struct {
+	name  string
+	srv   *http.Server
+	serve func() error
+}
+
+type lifecycleComponent struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+type lifecycleHook struct {
+	name    string
+	timeout time.Duration
+	fn      func(ctx context.Context) error
+}
+
+// NewLifecycle returns a Lifecycle using the config's shutdown deadline.
+func NewLifecycle(cfg *ServerConfig) *Lifecycle {
+	return &Lifecycle{
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		HookTimeout:     5 * time.Second,
+		Signals:         []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+		Logger:          log.Default(),
+		stop:            make(chan string, 1),
+	}
+}
+
+// AddServer registers srv. serve starts it and blocks (srv.ListenAndServe
+// when nil); http.ErrServerClosed is treated as a clean stop.
+func (l *Lifecycle) AddServer(name string, srv *http.Server, serve func() error) {
+	if serve == nil {
+		serve = srv.ListenAndServe
+	}
+	l.servers = append(l.servers, &lifecycleServer{name: name, srv: srv, serve: serve})
+}
+
+// AddComponent registers a background component. run must return once ctx
+// is cancelled; returning earlier with an error triggers shutdown.
+func (l *Lifecycle) AddComponent(name string, run func(ctx context.Context) error) {
+	l.components = append(l.components, lifecycleComponent{name: name, run: run})
+}
+
+// OnShutdown registers a hook run after servers and components have
+// stopped. Hooks run in reverse registration order; timeout <= 0 uses
+// HookTimeout.
+func (l *Lifecycle) OnShutdown(name string, timeout time.Duration, fn func(ctx context.Context) error) {
+	if timeout <= 0 {
+		timeout = l.HookTimeout
+	}
+	l.hooks = append(l.hooks, lifecycleHook{name: name, timeout: timeout, fn: fn})
+}
+
+// OnReady registers fn to run once every server and component has started.
+func (l *Lifecycle) OnReady(fn func()) {
+	l.onReady = append(l.onReady, fn)
+}
+
+// Stop asks Run to shut down as if a signal had arrived. It does not block.
+func (l *Lifecycle) Stop(reason string) {
+	select {
+	case l.stop <- reason:
+	default:
+	}
+}
+
+// Ready reports whether the service should receive traffic.
+func (l *Lifecycle) Ready() bool {
+	return l.ready.Load()
+}
+
+// ReadinessHandler answers 200 while ready and 503 once shutdown has begun.
+func (l *Lifecycle) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Ready() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+// Run starts everything and blocks until a signal arrives, ctx is
+// cancelled, or a server or component fails; then it shuts down in order.
+// The returned error joins every failure; nil means a clean shutdown.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, l.Signals...)
+	defer signal.Stop(sigCh)
+
+	compCtx, stopComponents := context.WithCancel(context.Background())
+	defer stopComponents()
+
+	type result struct {
+		name string
+		err  error
+	}
+	failed := make(chan result, len(l.servers)+len(l.components))
+	var running sync.WaitGroup
+
+	for _, s := range l.servers {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			l.Logger.Printf("lifecycle: starting server %s", s.name)
+			if err := s.serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- result{"server " + s.name, err}
+			}
+		}()
+	}
+	var compWG sync.WaitGroup
+	for _, c := range l.components {
+		running.Add(1)
+		compWG.Add(1)
+		go func() {
+			defer running.Done()
+			defer compWG.Done()
+			if err := c.run(compCtx); err != nil && !errors.Is(err, context.Canceled) {
+				failed <- result{"component " + c.name, err}
+			}
+		}()
+	}
+	l.ready.Store(true)
+	for _, fn := range l.onReady {
+		fn()
+	}
+
+	var errs []error
+	select {
+	case sig := <-sigCh:
+		l.Logger.Printf("lifecycle: received %s, shutting down", sig)
+	case <-ctx.Done():
+		l.Logger.Printf("lifecycle: context done, shutting down")
+	case reason := <-l.stop:
+		l.Logger.Printf("lifecycle: %s, shutting down", reason)
+	case r := <-failed:
+		l.Logger.Printf("lifecycle: %s failed: %v", r.name, r.err)
+		errs = append(errs, fmt.Errorf("%s: %w", r.name, r.err))
+	}
+
+	// A second signal, during the drain or after it, cuts the grace period
+	// short: forceCtx is the parent of shutdownCtx.
+	forceCtx, force := context.WithCancel(context.Background())
+	defer force()
+	var forcedBy os.Signal
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		select {
+		case sig := <-sigCh:
+			l.Logger.Printf("lifecycle: received %s again, forcing close", sig)
+			forcedBy = sig
+			force()
+		case <-forceCtx.Done():
+		}
+	}()
+
+	l.ready.Store(false)
+	if len(errs) == 0 && l.DrainDelay > 0 {
+		l.Logger.Printf("lifecycle: draining for %s", l.DrainDelay)
+		select {
+		case <-time.After(l.DrainDelay):
+		case <-forceCtx.Done():
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(forceCtx, l.ShutdownTimeout)
+	defer cancel()
+
+	var srvWG sync.WaitGroup
+	var mu sync.Mutex
+	for _, s := range l.servers {
+		srvWG.Add(1)
+		go func() {
+			defer srvWG.Done()
+			if err := s.srv.Shutdown(shutdownCtx); err != nil {
+				s.srv.Close()
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("server %s shutdown: %w", s.name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	srvWG.Wait()
+
+	stopComponents()
+	if !waitTimeout(&compWG, shutdownCtx) {
+		errs = append(errs, fmt.Errorf("components did not stop before the shutdown deadline"))
+	}
+
+	// Collect failures that raced with the shutdown signal.
+	go func() { running.Wait(); close(failed) }()
+	if waitTimeout(&running, shutdownCtx) {
+		for r := range failed {
+			errs = append(errs, fmt.Errorf("%s: %w", r.name, r.err))
+		}
+	}
+
+	for i := len(l.hooks) - 1; i >= 0; i-- {
+		if err := l.runHook(l.hooks[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Stop the signal watcher so forcedBy is settled before it is read.
+	force()
+	<-watching
+	if forcedBy != nil {
+		errs = append(errs, &ForcedShutdownError{Signal: forcedBy})
+	}
+
+	err := errors.Join(errs...)
+	if err == nil {
+		l.Logger.Printf("lifecycle: shutdown complete")
+	}
+	return err
+}
+
+func (l *Lifecycle) runHook(h lifecycleHook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- h.fn(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("shutdown hook %s: %w", h.name, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown hook %s: timed out after %s", h.name, h.timeout)
+	}
+}
+
+// waitTimeout waits for wg and reports false if ctx expires first.
+func waitTimeout(wg *sync.WaitGroup, ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ForcedShutdownError reports that a second signal cut the graceful
+// shutdown short.
+type ForcedShutdownError struct {
+	Signal os.Signal
+}
+
+func (e *ForcedShutdownError) Error() string {
+	return fmt.Sprintf("shutdown forced by %s", e.Signal)
+}
+
+// ExitCode maps the result of Run to a process exit status: 0 for a clean
+// shutdown, 128+signal when a second signal forced it, 1 for any failure.
+func ExitCode(err error) int {
+	var forced *ForcedShutdownError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &forced):
+		if sig, ok := forced.Signal.(syscall.Signal); ok {
+			return 128 + int(sig)
+		}
+		return 1
+	}
+	return 1
+}
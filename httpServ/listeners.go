@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+Multiple listeners
+
+One http.Server can Serve() on any number of listeners at once; Shutdown
+closes all of them. A listener spec says what to bind:
+
+	tcp://:8080                        all interfaces, port 8080
+	tcp://127.0.0.1:0                  loopback, any free port (logged once bound)
+	unix:///run/httpServ.sock          socket file
+	unix:///run/httpServ.sock?mode=0660&owner=www-data&group=www-data
+	unix://@httpServ                   Linux abstract socket (no file on disk)
+
+Socket files:
+  - a stale file left by a crashed process is removed on start
+    (nobody answers on it → safe to delete)
+  - a socket someone still answers on is an error, not silently stolen
+  - the file is removed again on exit
+*/
+
+// ListenerSpec is one parsed listener spec.
+type ListenerSpec struct {
+	Network string // "tcp" or "unix"
+	Address string // host:port, socket path, or "@name" for abstract sockets
+	Mode    os.FileMode
+	Owner   string
+	Group   string
+}
+
+func (s ListenerSpec) String() string {
+	return s.Network + "://" + s.Address
+}
+
+// Abstract reports whether s is a Linux abstract unix socket.
+func (s ListenerSpec) Abstract() bool {
+	return s.Network == "unix" && strings.HasPrefix(s.Address, "@")
+}
+
+// ParseListenerSpec parses "tcp://host:port" or "unix://path?mode=&owner=&group=".
+// A bare "host:port" is treated as tcp.
+func ParseListenerSpec(spec string) (ListenerSpec, error) {
+	if !strings.Contains(spec, "://") {
+		spec = "tcp://" + spec
+	}
+	scheme, rest, _ := strings.Cut(spec, "://")
+	switch scheme {
+	case "tcp", "tcp4", "tcp6":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return ListenerSpec{}, fmt.Errorf("listener %q: want tcp://host:port", spec)
+		}
+		return ListenerSpec{Network: scheme, Address: rest}, nil
+	case "unix":
+		path, query, _ := strings.Cut(rest, "?")
+		if path == "" || path == "@" {
+			return ListenerSpec{}, fmt.Errorf("listener %q: missing socket path", spec)
+		}
+		ls := ListenerSpec{Network: "unix", Address: path}
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			return ListenerSpec{}, fmt.Errorf("listener %q: %w", spec, err)
+		}
+		if m := q.Get("mode"); m != "" {
+			mode, err := strconv.ParseUint(m, 8, 32)
+			if err != nil || mode > 0o777 {
+				return ListenerSpec{}, fmt.Errorf("listener %q: mode %q is not an octal permission", spec, m)
+			}
+			ls.Mode = os.FileMode(mode)
+		}
+		ls.Owner, ls.Group = q.Get("owner"), q.Get("group")
+		if ls.Abstract() && (ls.Mode != 0 || ls.Owner != "" || ls.Group != "") {
+			return ListenerSpec{}, fmt.Errorf("listener %q: abstract sockets have no file mode or owner", spec)
+		}
+		return ls, nil
+	default:
+		return ListenerSpec{}, fmt.Errorf("listener %q: unsupported scheme %q (want tcp or unix)", spec, scheme)
+	}
+}
+
+// ListenSpec binds spec, reusing an inherited socket when there is one.
+func (ls *ListenerSet) ListenSpec(spec ListenerSpec) (net.Listener, error) {
+	if spec.Network == "unix" && !spec.Abstract() && !ls.inherits(spec.Network, spec.Address) {
+		if err := removeStaleSocket(spec.Address); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := ls.Listen(spec.Network, spec.Address)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Network == "unix" && !spec.Abstract() {
+		// Inherited unix listeners do not unlink on close by default; this
+		// process now owns the file.
+		ln.(*net.UnixListener).SetUnlinkOnClose(true)
+		if err := applySocketPerms(spec); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	log.Printf("listening on %s://%s", ln.Addr().Network(), ln.Addr())
+	return ln, nil
+}
+
+// removeStaleSocket deletes path if it is a socket nobody listens on.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	log.Printf("removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+func applySocketPerms(spec ListenerSpec) error {
+	if spec.Mode != 0 {
+		if err := os.Chmod(spec.Address, spec.Mode); err != nil {
+			return err
+		}
+	}
+	if spec.Owner == "" && spec.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if spec.Owner != "" {
+		u, err := user.Lookup(spec.Owner)
+		if err != nil {
+			return err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if spec.Group != "" {
+		g, err := user.LookupGroup(spec.Group)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return os.Chown(spec.Address, uid, gid)
+}
+
+// serveAll serves srv on every listener and returns the first error.
+func serveAll(srv *http.Server, lns []net.Listener) func() error {
+	// Decide once: Serve sets srv.TLSConfig itself while configuring HTTP/2.
+	useTLS := srv.TLSConfig != nil
+	return func() error {
+		errc := make(chan error, len(lns))
+		for _, ln := range lns {
+			go func() {
+				if useTLS {
+					errc <- srv.ServeTLS(ln, "", "")
+				} else {
+					errc <- srv.Serve(ln)
+				}
+			}()
+		}
+		return <-errc
+	}
+}
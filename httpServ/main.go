@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"time"
+
+	"httpServ/httpclient"
+	"httpServ/jsonrpc"
+	"httpServ/rewrite"
+	"httpServ/sse"
+	"httpServ/ws"
+	"middleware/openapi"
 )
 
 //MUX
@@ -35,12 +42,47 @@ Shutdown() or Close()
 
 Server exits
 */
+//go:generate go run . -openapi-write openapi.json
+
 func main() {
-	mux := http.NewServeMux()
+	// mux remembers its patterns for the admin server's /routes (admin.go).
+	mux := NewRouteTable()
+	// API routes go through api so they land in the OpenAPI document
+	// (GET /openapi.json, GET /docs); see middleware/openapi.
+	spec := openapi.New("httpServ", "1.0.0")
+	api := openapi.NewMux(mux, spec)
 
 	mux.HandleFunc("/information", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Hellow Server: Wipro"))
 	})
+	// Live updates for dashboards: GET /events is a Server-Sent Events
+	// stream (see sse/), item changes are published to it.
+	events := sse.NewBroker()
+	api.Handle("GET /events", events, openapi.Tags("events"),
+		openapi.Summary("Stream item changes (Server-Sent Events, resumable with Last-Event-ID)"),
+		openapi.Produces(http.StatusOK, "text/event-stream", "Event stream"))
+
+	// Chat-style rooms over WebSocket: GET /ws?room=ops (see ws/).
+	hub := ws.NewHub()
+	hub.Upgrade.Compression = true
+	hub.OnConnect = func(c *ws.Client, r *http.Request) error {
+		room := r.URL.Query().Get("room")
+		if room == "" {
+			room = "lobby"
+		}
+		c.Join(room)
+		return nil
+	}
+	hub.OnMessage = func(c *ws.Client, typ int, data []byte) {
+		for _, room := range c.Rooms() {
+			hub.Broadcast(room, typ, data)
+		}
+	}
+	mux.Handle("GET /ws", hub)
+
+	items := NewItemStore()
+	items.Events = events
+	items.Register(api) // typed JSON handlers, see api.go
 
 	/**
 	Common fields:
@@ -49,30 +91,206 @@ func main() {
 		ReadTimeout, WriteTimeout, IdleTimeout – connection timeouts
 		TLSConfig – TLS settings (optional)
 	*/
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+	cfg, err := LoadServerConfig(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if cfg.PrintConfig {
+		cfg.Print(os.Stdout)
+		return
 	}
 
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Fatalf("Server error %v", err)
+	/*
+		Everything the mux does not route is a static file or, for app
+		routes like /settings/profile, the SPA's index.html (see static.go).
+	*/
+	files, err := staticFS(cfg.StaticDir)
+	if err != nil {
+		log.Fatalf("static: %v", err)
+	}
+	/*
+		Gateway mode (see proxy.go): routes from proxy_config are forwarded
+		to upstream pools.
+	*/
+	var gw *Gateway
+	if cfg.ProxyConfig != "" {
+		pc, err := LoadProxyConfig(cfg.ProxyConfig)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}()
+		gw = NewGateway(pc)
+		gw.Register(mux)
+	}
+	/*
+		JSON-RPC 2.0 (see jsonrpc/): the item methods behind one endpoint.
+		It is a plain handler, so auth and the access log wrap it as usual:
+		rpc_token guards the endpoint, and write_token still guards
+		items.create and items.delete exactly as it guards POST/DELETE
+		/items (see api.go).
+	*/
+	items.WriteToken = cfg.WriteToken
+	rpc := jsonrpc.NewServer()
+	items.RegisterRPC(rpc)
+	api.Handle("POST /rpc", BearerAuthMiddleware(cfg.RPCToken)(rpc), openapi.Tags("rpc"),
+		openapi.Summary("JSON-RPC 2.0: a call, a notification or a batch; rpc.discover lists the methods"),
+		openapi.Request(jsonrpc.Request{}), openapi.Returns(http.StatusOK, jsonrpc.Response{}),
+		openapi.Returns(http.StatusNoContent, nil), openapi.Returns(http.StatusUnauthorized, nil))
+
+	mux.Handle("GET /openapi.json", spec.Handler())
+	mux.Handle("GET /docs", openapi.DocsHandler("httpServ API", "/openapi.json"))
+	if cfg.OpenAPIWrite != "" {
+		if err := spec.WriteFile(cfg.OpenAPIWrite); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if cfg.OpenAPICheck != "" {
+		if err := spec.Check(cfg.OpenAPICheck); err != nil {
+			log.Fatalf("%v\nrun go generate to update it", err)
+		}
+		return
+	}
 
-	quit := make(chan os.Signal, 1)
+	mux.Handle("/", NewStaticHandler(files, StaticOptions{SPA: cfg.SPA, Listing: cfg.StaticListing}))
 
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	/*
+		Legacy URLs (see rewrite/): rules from rewrite_rules redirect or
+		rewrite before the mux routes; the access log keeps the original URL.
+	*/
+	var handler http.Handler = mux
+	var rewrites *rewrite.Engine
+	if cfg.RewriteRules != "" {
+		rc, err := rewrite.LoadFile(cfg.RewriteRules)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if rewrites, err = rewrite.New(rc); err != nil {
+			log.Fatalf("rewrite rules %s:\n%v", cfg.RewriteRules, err)
+		}
+		handler = rewrites.Middleware(handler)
+	}
+	// Request IDs and trace headers ride along on calls made with
+	// httpclient from inside handlers (see httpclient/).
+	handler = httpclient.Middleware(handler)
+	if cfg.AccessLog {
+		handler = AccessLogMiddleware(handler)
+	}
+	srv := cfg.NewServer(handler)
+	srv.RegisterOnShutdown(events.Close) // streams never finish on their own
 
 	/*
-		Graceful shutdown
-			The 10 seconds is a deadline:
+		Graceful shutdown (see lifecycle.go)
+			SIGINT or SIGTERM → readiness off → DrainDelay → srv.Shutdown
+			ShutdownTimeout (default 10 seconds) is a deadline:
 				“Give existing requests up to 10 seconds to finish.”
 	*/
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	srv.Shutdown(ctx)
+	lc := NewLifecycle(cfg)
+	lc.DrainDelay = cfg.DrainDelay
+	// Hijacked connections are not tracked by srv.Shutdown; the hub says
+	// goodbye to each with a 1001 close frame.
+	lc.OnShutdown("websocket-hub", 0, hub.Shutdown)
+	// The only unauthenticated probe: "ok" or 503, nothing else. Stats and
+	// metrics are on the admin server.
+	mux.Handle("/readyz", lc.ReadinessHandler())
+
+	/*
+		Connection tracking and caps (see connmgr.go): every listener is
+		wrapped, idle keep-alive connections are the first to go.
+	*/
+	cm := NewConnManager(cfg.MaxConns, cfg.MaxConnsPerIP)
+	cm.Attach(srv)
+
+	/*
+		Sockets come from a parent process (SIGUSR2 restart), from systemd
+		socket activation, or are opened here (see restart.go).
+	*/
+	ls, err := NewListenerSet()
+	if err != nil {
+		log.Fatalf("listeners: %v", err)
+	}
+	if cfg.TLSEnabled() {
+		if err := setupTLS(cfg, srv, lc); err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+	}
+	if err := setupProtocols(cfg, srv, lc, ls); err != nil {
+		log.Fatalf("protocols: %v", err)
+	}
+	/*
+		Behind a TCP load balancer the PROXY header (see proxyproto.go) carries
+		the real client address; it is read before connection caps apply so
+		per-IP limits count clients, not the balancer.
+	*/
+	trusted, _ := ParseCIDRs(cfg.ProxyTrustedCIDRs)
+	if cfg.ProxyProtocol {
+		srv.ConnContext = ProxyConnContext
+	}
+	var lns []net.Listener
+	for _, spec := range cfg.ListenerSpecs() {
+		ln, err := ls.ListenSpec(spec)
+		if err != nil {
+			log.Fatalf("listen %s: %v", spec, err)
+		}
+		if cfg.ProxyProtocol {
+			ln = NewProxyListener(ln, trusted, cfg.ProxyHeaderTimeout)
+		}
+		lns = append(lns, cm.Listener(ln))
+	}
+	/*
+		Admin server (see admin.go): build info, config, runtime and
+		connection metrics, upstream stats, routes, health detail and pprof
+		on a separate, by default loopback-only port, always behind a token.
+		Its socket is inherited on restart like the public ones.
+	*/
+	if cfg.AdminAddr != "" {
+		admin := NewAdminServer(cfg, mux)
+		if cfg.AdminToken == "" {
+			path, err := admin.WriteToken(cfg.StateDir)
+			if err != nil {
+				log.Fatalf("admin: admin_token is not set and the generated one cannot be saved: %v", err)
+			}
+			log.Printf("admin: admin_token is not set; the token for this run is in %s", path)
+		}
+		admin.Handle("GET /metrics", cm.MetricsHandler())
+		admin.AddCheck("ready", func(ctx context.Context) error {
+			if !lc.Ready() {
+				return errors.New("shutting down")
+			}
+			return nil
+		})
+		admin.AddStatus("connections", func() any { return cm.Stats() })
+		admin.AddStatus("websocket", func() any { return map[string]int{"clients": hub.Len()} })
+		admin.AddStatus("events", func() any {
+			return map[string]any{"clients": events.Clients(), "evicted": events.Evicted()}
+		})
+		if gw != nil {
+			admin.AddCheck("gateway", gw.Check)
+			admin.AddStatus("gateway", func() any { return gw.Stats() })
+			admin.Handle("GET /proxy/stats", gw.StatsHandler())
+		}
+		if rewrites != nil {
+			admin.Handle("GET /rewrite", rewrites.ExplainHandler())
+		}
+		ln, err := ls.Listen("tcp", cfg.AdminAddr)
+		if err != nil {
+			log.Fatalf("admin: listen %s: %v", cfg.AdminAddr, err)
+		}
+		log.Printf("admin server on http://%s", ln.Addr())
+		adminSrv := admin.NewServer()
+		lc.AddServer("admin", adminSrv, func() error { return adminSrv.Serve(ln) })
+	}
+	ls.CloseUnused()
+	lc.AddServer("http", srv, serveAll(srv, lns))
+	if gw != nil {
+		lc.AddComponent("health-checks", gw.RunHealthChecks)
+	}
+	lc.AddComponent("restarter", ls.RunRestarter(lc, cfg.RestartTimeout))
+	lc.OnReady(ls.NotifyReady)
+
+	if err := lc.Run(context.Background()); err != nil {
+		log.Printf("shutdown: %v", err)
+		os.Exit(ExitCode(err))
+	}
 
 	/*
 		Immediate shutdown (force close)
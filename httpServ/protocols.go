@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+/*
+HTTP/1.1, HTTP/2 and HTTP/3 side by side
+
+	                 TCP :8080                               UDP :8080
+	              ┌──────────────┐                        ┌────────────┐
+	plain HTTP →  │ HTTP/1.1     │                        │ HTTP/3     │ ← QUIC (always TLS)
+	              │ h2c (-h2c)   │ ── Alt-Svc: h3=":8080" │ (-http3)   │
+	TLS       →   │ HTTP/1.1, h2 │    tells clients ───→  │            │
+	              └──────────────┘                        └────────────┘
+
+h2c = HTTP/2 over cleartext TCP, for service-to-service traffic inside the
+mesh where TLS is terminated elsewhere. Two ways in:
+  - prior knowledge: the client starts straight with the HTTP/2 preface
+  - Upgrade: an HTTP/1.1 request with "Upgrade: h2c" switches the connection
+
+HTTP/3 runs over QUIC (UDP) and needs TLS. Browsers find it through the
+Alt-Svc header sent on TCP responses, then switch on a later request.
+
+Every request carries the protocol it arrived on in r.Proto
+("HTTP/1.1", "HTTP/2.0", "HTTP/3.0"); AccessLogMiddleware logs it.
+*/
+
+// setupProtocols wraps srv.Handler for h2c and Alt-Svc and registers the
+// HTTP/3 listener with lc. It must run after setupTLS.
+func setupProtocols(cfg *ServerConfig, srv *http.Server, lc *Lifecycle, ls *ListenerSet) error {
+	handler := srv.Handler
+
+	if cfg.H2C {
+		srv.Handler = h2c.NewHandler(handler, &http2.Server{
+			IdleTimeout:          cfg.IdleTimeout,
+			MaxReadFrameSize:     1 << 20,
+			MaxConcurrentStreams: 250,
+		})
+	}
+
+	if cfg.HTTP3 {
+		if srv.TLSConfig == nil {
+			return errors.New("http3 requires TLS")
+		}
+		addr := cfg.HTTP3Addr
+		if addr == "" {
+			addr = cfg.Addr
+		}
+		_, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("http3 addr %q: %w", addr, err)
+		}
+		port, _ := strconv.Atoi(portStr)
+
+		h3 := &http3.Server{
+			Addr:           addr,
+			Port:           port,
+			Handler:        handler,
+			TLSConfig:      http3.ConfigureTLSConfig(srv.TLSConfig),
+			MaxHeaderBytes: cfg.MaxHeaderBytes,
+			IdleTimeout:    cfg.IdleTimeout,
+		}
+		// Bind now so a busy port fails at startup, and so the socket can be
+		// handed over on restart like the TCP ones.
+		pc, err := ls.ListenPacket("udp", addr)
+		if err != nil {
+			return fmt.Errorf("http3: %w", err)
+		}
+		srv.Handler = altSvcMiddleware(h3, srv.Handler)
+		lc.AddComponent("http3", func(ctx context.Context) error {
+			defer pc.Close()
+			errc := make(chan error, 1)
+			go func() { errc <- h3.Serve(pc) }()
+			select {
+			case err := <-errc:
+				return err
+			case <-ctx.Done():
+				sctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+				defer cancel()
+				if err := h3.Shutdown(sctx); err != nil {
+					h3.Close()
+				}
+				return nil
+			}
+		})
+	}
+	return nil
+}
+
+// altSvcMiddleware advertises the HTTP/3 endpoint on TCP responses.
+func altSvcMiddleware(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,670 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Reverse proxy (gateway mode)
+
+	client ──→ mux route /api/ ──→ httputil.ReverseProxy
+	                                 ↓ Rewrite: strip/add path prefix, X-Forwarded-*
+	                                 ↓ Transport = Pool
+	                                        ↓ pick an upstream that is healthy and not ejected
+	                                        ↓   round_robin      next one in turn
+	                                        ↓   least_conn       fewest requests in flight
+	                                        ↓   consistent_hash  same key → same upstream
+	                                        ↓ send; connection error or 502/503/504?
+	                                        ↓   → count a failure (max_fails in a row → eject for eject_for)
+	                                        ↓   → idempotent request? try ANOTHER upstream (retries)
+	                                 ↓ response streamed back
+
+Two ways an upstream is taken out of rotation:
+
+	active   health_check.path is polled every interval; `unhealthy` failures
+	         in a row mark it down, `healthy` passes in a row bring it back
+	passive  real traffic fails max_fails times in a row → ejected for eject_for
+	         (max_fails: -1 turns passive ejection off)
+
+Only idempotent requests (GET, HEAD, OPTIONS, PUT, DELETE, or anything with
+an Idempotency-Key header) without a body that cannot be replayed are
+retried: a POST that reached the upstream may already have had its effect.
+
+The pools and routes live in their own file (proxy_config):
+
+	pools:
+	  api:
+	    balance: least_conn
+	    upstreams: [http://10.0.0.1:8080, http://10.0.0.2:8080]
+	    health_check: {path: /healthz, interval: 5s}
+	    retries: 2
+	routes:
+	  - prefix: /api/
+	    pool: api
+	    strip_prefix: /api
+	    add_prefix: /v1
+
+GET /proxy/stats on the admin server (see admin.go) shows every upstream's
+state.
+*/
+
+// ProxyConfig is the gateway configuration file.
+type ProxyConfig struct {
+	Pools  map[string]*PoolConfig `yaml:"pools"`
+	Routes []RouteConfig          `yaml:"routes"`
+}
+
+// PoolConfig describes one upstream pool.
+type PoolConfig struct {
+	Balance     string            `yaml:"balance"`  // round_robin (default), least_conn, consistent_hash
+	HashKey     string            `yaml:"hash_key"` // ip (default), header:<name>, cookie:<name>
+	Upstreams   []string          `yaml:"upstreams"`
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	MaxFails    int               `yaml:"max_fails"` // default 3, -1 never ejects
+	EjectFor    time.Duration     `yaml:"eject_for"` // default 30s
+	Retries     int               `yaml:"retries"`
+}
+
+// HealthCheckConfig configures active health checks; no path disables them.
+type HealthCheckConfig struct {
+	Path      string        `yaml:"path"`
+	Interval  time.Duration `yaml:"interval"`  // default 5s
+	Timeout   time.Duration `yaml:"timeout"`   // default 2s
+	Healthy   int           `yaml:"healthy"`   // passes in a row to come back, default 2
+	Unhealthy int           `yaml:"unhealthy"` // failures in a row to go down, default 3
+}
+
+// RouteConfig maps a mux pattern to a pool.
+type RouteConfig struct {
+	Prefix      string `yaml:"prefix"` // mux pattern; a trailing / matches the subtree
+	Pool        string `yaml:"pool"`
+	StripPrefix string `yaml:"strip_prefix"`
+	AddPrefix   string `yaml:"add_prefix"`
+}
+
+// LoadProxyConfig reads and validates a gateway file (YAML or JSON).
+func LoadProxyConfig(path string) (*ProxyConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("proxy config: %w", err)
+	}
+	defer f.Close()
+	var pc ProxyConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&pc); err != nil {
+		return nil, fmt.Errorf("proxy config %s: %w", path, err)
+	}
+
+	var errs []error
+	for name, p := range pc.Pools {
+		if p == nil {
+			errs = append(errs, fmt.Errorf("pool %s: empty", name))
+			continue
+		}
+		p.setDefaults()
+		errs = append(errs, p.validate(name)...)
+	}
+	for i, rt := range pc.Routes {
+		if !strings.HasPrefix(rt.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("route %d: prefix %q must start with /", i, rt.Prefix))
+		}
+		if _, ok := pc.Pools[rt.Pool]; !ok {
+			errs = append(errs, fmt.Errorf("route %s: unknown pool %q", rt.Prefix, rt.Pool))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("proxy config %s:\n%w", path, errors.Join(errs...))
+	}
+	return &pc, nil
+}
+
+func (p *PoolConfig) setDefaults() {
+	if p.Balance == "" {
+		p.Balance = "round_robin"
+	}
+	if p.HashKey == "" {
+		p.HashKey = "ip"
+	}
+	if p.MaxFails == 0 {
+		p.MaxFails = 3
+	}
+	if p.EjectFor == 0 {
+		p.EjectFor = 30 * time.Second
+	}
+	hc := &p.HealthCheck
+	if hc.Interval == 0 {
+		hc.Interval = 5 * time.Second
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = 2 * time.Second
+	}
+	if hc.Healthy == 0 {
+		hc.Healthy = 2
+	}
+	if hc.Unhealthy == 0 {
+		hc.Unhealthy = 3
+	}
+}
+
+func (p *PoolConfig) validate(name string) []error {
+	var errs []error
+	switch p.Balance {
+	case "round_robin", "least_conn", "consistent_hash":
+	default:
+		errs = append(errs, fmt.Errorf("pool %s: unknown balance %q", name, p.Balance))
+	}
+	if kind, arg, _ := strings.Cut(p.HashKey, ":"); !(kind == "ip" || (kind == "header" || kind == "cookie") && arg != "") {
+		errs = append(errs, fmt.Errorf("pool %s: hash_key %q: want ip, header:<name> or cookie:<name>", name, p.HashKey))
+	}
+	if len(p.Upstreams) == 0 {
+		errs = append(errs, fmt.Errorf("pool %s: no upstreams", name))
+	}
+	for _, u := range p.Upstreams {
+		if pu, err := url.Parse(u); err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+			errs = append(errs, fmt.Errorf("pool %s: upstream %q: want http(s)://host:port", name, u))
+		}
+	}
+	if p.MaxFails < -1 {
+		errs = append(errs, fmt.Errorf("pool %s: max_fails must be -1 (never eject) or more", name))
+	}
+	if p.EjectFor < 0 || p.Retries < 0 {
+		errs = append(errs, fmt.Errorf("pool %s: eject_for and retries must not be negative", name))
+	}
+	return errs
+}
+
+// Upstream is one backend server and its health.
+type Upstream struct {
+	URL *url.URL
+
+	healthy  atomic.Bool
+	active   atomic.Int64 // requests in flight
+	requests atomic.Int64
+	failures atomic.Int64
+
+	mu           sync.Mutex
+	passiveFails int // consecutive failures of real requests
+	checkPasses  int // consecutive health check results
+	checkFails   int
+	ejectedUntil time.Time
+	lastError    string
+	lastCheck    time.Time
+}
+
+func (u *Upstream) available(now time.Time) bool {
+	if !u.healthy.Load() {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !now.Before(u.ejectedUntil)
+}
+
+// Pool balances requests over its upstreams. It is the Transport of the
+// pool's reverse proxies.
+type Pool struct {
+	Name string
+	cfg  *PoolConfig
+
+	upstreams []*Upstream
+	ring      []ringPoint // consistent_hash only
+	next      atomic.Uint64
+	transport http.RoundTripper
+	logger    *log.Logger
+}
+
+type ringPoint struct {
+	hash uint32
+	up   *Upstream
+}
+
+// virtualNodes per upstream on the hash ring; more nodes spread keys more
+// evenly.
+const virtualNodes = 100
+
+var errNoUpstream = errors.New("no healthy upstream")
+
+// NewPool builds a pool; every upstream starts healthy.
+func NewPool(name string, cfg *PoolConfig) *Pool {
+	p := &Pool{Name: name, cfg: cfg, transport: http.DefaultTransport.(*http.Transport).Clone(), logger: log.Default()}
+	for _, raw := range cfg.Upstreams {
+		u, _ := url.Parse(raw) // validated by LoadProxyConfig
+		up := &Upstream{URL: u}
+		up.healthy.Store(true)
+		p.upstreams = append(p.upstreams, up)
+		for i := 0; i < virtualNodes; i++ {
+			p.ring = append(p.ring, ringPoint{hash: hash32(raw + "#" + strconv.Itoa(i)), up: up})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p
+}
+
+// hash32 is FNV-1a with a murmur3 finalizer; plain FNV clusters on keys
+// that differ only in their last characters (u1, u2, ... or host#1, host#2).
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// pick chooses an available upstream that is not in tried.
+func (p *Pool) pick(r *http.Request, tried []*Upstream) *Upstream {
+	now := time.Now()
+	ok := func(u *Upstream) bool {
+		for _, t := range tried {
+			if t == u {
+				return false
+			}
+		}
+		return u.available(now)
+	}
+
+	switch p.cfg.Balance {
+	case "consistent_hash":
+		if key := p.hashKey(r); key != "" {
+			h := hash32(key)
+			start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+			for i := range p.ring {
+				if pt := p.ring[(start+i)%len(p.ring)]; ok(pt.up) {
+					return pt.up
+				}
+			}
+			return nil
+		}
+	case "least_conn":
+		var best *Upstream
+		offset := p.next.Add(1) // rotate ties
+		for i := range p.upstreams {
+			u := p.upstreams[(offset+uint64(i))%uint64(len(p.upstreams))]
+			if ok(u) && (best == nil || u.active.Load() < best.active.Load()) {
+				best = u
+			}
+		}
+		return best
+	}
+
+	// round_robin, and consistent_hash requests without a key
+	for range p.upstreams {
+		u := p.upstreams[(p.next.Add(1)-1)%uint64(len(p.upstreams))]
+		if ok(u) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (p *Pool) hashKey(r *http.Request) string {
+	kind, name, _ := strings.Cut(p.cfg.HashKey, ":")
+	switch kind {
+	case "header":
+		return r.Header.Get(name)
+	case "cookie":
+		if c, err := r.Cookie(name); err == nil {
+			return c.Value
+		}
+		return ""
+	default:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// idempotent reports whether req may safely be sent twice.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+	default:
+		if req.Header.Get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// RoundTrip sends req to an upstream, retrying idempotent requests on
+// other upstreams. When no untried upstream is left, the last upstream's
+// response or error is returned as is.
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := idempotent(req)
+	u := p.pick(req, nil)
+	if u == nil {
+		return nil, errNoUpstream
+	}
+	var tried []*Upstream
+	for attempt := 0; ; attempt++ {
+		tried = append(tried, u)
+
+		out := req.Clone(req.Context())
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			out.Body = body
+		}
+		out.URL.Scheme, out.URL.Host = u.URL.Scheme, u.URL.Host
+		out.URL.Path = joinPath(u.URL.Path, req.URL.Path)
+		out.URL.RawPath = ""
+		out.Host = "" // the upstream's own host name; the original is in X-Forwarded-Host
+
+		u.requests.Add(1)
+		u.active.Add(1)
+		resp, err := p.transport.RoundTrip(out)
+		failed := err != nil
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				failed = true
+			}
+		}
+		if failed && req.Context().Err() == nil {
+			reason := ""
+			if err != nil {
+				reason = err.Error()
+			} else {
+				reason = resp.Status
+			}
+			p.passiveFailure(u, reason)
+		} else if !failed {
+			p.passiveSuccess(u)
+		}
+
+		if failed && retryable && attempt < p.cfg.Retries && req.Context().Err() == nil {
+			if next := p.pick(req, tried); next != nil {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				u.active.Add(-1)
+				p.logger.Printf("proxy: %s %s via %s failed, retrying on %s", req.Method, req.URL.Path, u.URL.Host, next.URL.Host)
+				u = next
+				continue
+			}
+		}
+		if err != nil {
+			u.active.Add(-1)
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			// ReverseProxy needs the raw body (an io.ReadWriteCloser) for upgrades.
+			u.active.Add(-1)
+			return resp, nil
+		}
+		resp.Body = &inflightBody{ReadCloser: resp.Body, done: func() { u.active.Add(-1) }}
+		return resp, nil
+	}
+}
+
+// inflightBody marks the request finished when the response body is closed,
+// so least_conn sees streaming responses as in flight.
+type inflightBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *inflightBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+func joinPath(base, p string) string {
+	if base == "" || base == "/" {
+		return p
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+func (p *Pool) passiveFailure(u *Upstream, reason string) {
+	u.failures.Add(1)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastError = reason
+	u.passiveFails++
+	if p.cfg.MaxFails > 0 && u.passiveFails >= p.cfg.MaxFails {
+		u.passiveFails = 0
+		u.ejectedUntil = time.Now().Add(p.cfg.EjectFor)
+		p.logger.Printf("proxy: pool %s: ejecting %s for %s after %d failures (%s)", p.Name, u.URL.Host, p.cfg.EjectFor, p.cfg.MaxFails, reason)
+	}
+}
+
+func (p *Pool) passiveSuccess(u *Upstream) {
+	u.mu.Lock()
+	u.passiveFails = 0
+	u.mu.Unlock()
+}
+
+// runHealthChecks polls every upstream until done is closed.
+func (p *Pool) runHealthChecks(done <-chan struct{}) {
+	hc := p.cfg.HealthCheck
+	if hc.Path == "" {
+		<-done
+		return
+	}
+	client := &http.Client{Transport: p.transport, Timeout: hc.Timeout}
+	tick := time.NewTicker(hc.Interval)
+	defer tick.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, u := range p.upstreams {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.check(client, u)
+			}()
+		}
+		wg.Wait()
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (p *Pool) check(client *http.Client, u *Upstream) {
+	hc := p.cfg.HealthCheck
+	target := *u.URL
+	target.Path = joinPath(u.URL.Path, hc.Path)
+	resp, err := client.Get(target.String())
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			err = fmt.Errorf("health check: %s", resp.Status)
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastCheck = time.Now()
+	if err != nil {
+		u.lastError = err.Error()
+		u.checkPasses = 0
+		u.checkFails++
+		if u.checkFails >= hc.Unhealthy && u.healthy.Swap(false) {
+			p.logger.Printf("proxy: pool %s: %s is DOWN: %v", p.Name, u.URL.Host, err)
+		}
+		return
+	}
+	u.checkFails = 0
+	u.checkPasses++
+	if u.checkPasses >= hc.Healthy && !u.healthy.Swap(true) {
+		p.logger.Printf("proxy: pool %s: %s is UP", p.Name, u.URL.Host)
+	}
+}
+
+// Gateway owns the pools and routes of a ProxyConfig.
+type Gateway struct {
+	pools  []*Pool
+	byName map[string]*Pool
+	routes []RouteConfig
+}
+
+// NewGateway builds pools and routes from pc.
+func NewGateway(pc *ProxyConfig) *Gateway {
+	gw := &Gateway{byName: make(map[string]*Pool), routes: pc.Routes}
+	names := make([]string, 0, len(pc.Pools))
+	for name := range pc.Pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := NewPool(name, pc.Pools[name])
+		gw.pools = append(gw.pools, p)
+		gw.byName[name] = p
+	}
+	return gw
+}
+
+// Register adds one reverse proxy per route to mux.
+func (gw *Gateway) Register(mux *RouteTable) {
+	for _, rt := range gw.routes {
+		pool := gw.byName[rt.Pool]
+		mux.Handle(rt.Prefix, &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetXForwarded()
+				p := pr.In.URL.Path
+				if rt.StripPrefix != "" {
+					p = strings.TrimPrefix(p, rt.StripPrefix)
+					if !strings.HasPrefix(p, "/") {
+						p = "/" + p
+					}
+				}
+				pr.Out.URL.Path = strings.TrimSuffix(rt.AddPrefix, "/") + p
+				pr.Out.URL.RawPath = ""
+			},
+			Transport: pool,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				switch {
+				case errors.Is(err, errNoUpstream):
+					http.Error(w, "no healthy upstream", http.StatusServiceUnavailable)
+				case r.Context().Err() != nil:
+					// client went away; nobody to answer
+				default:
+					pool.logger.Printf("proxy: pool %s: %s %s: %v", pool.Name, r.Method, r.URL.Path, err)
+					http.Error(w, "bad gateway", http.StatusBadGateway)
+				}
+			},
+		})
+	}
+}
+
+// RunHealthChecks is a Lifecycle component running every pool's checks.
+func (gw *Gateway) RunHealthChecks(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, p := range gw.pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.runHealthChecks(ctx.Done())
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+type upstreamStats struct {
+	URL          string     `json:"url"`
+	Healthy      bool       `json:"healthy"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	Active       int64      `json:"active"`
+	Requests     int64      `json:"requests"`
+	Failures     int64      `json:"failures"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastCheck    *time.Time `json:"last_check,omitempty"`
+}
+
+type poolStats struct {
+	Name      string          `json:"name"`
+	Balance   string          `json:"balance"`
+	Upstreams []upstreamStats `json:"upstreams"`
+}
+
+// Stats returns each upstream's state.
+func (gw *Gateway) Stats() []poolStats {
+	now := time.Now()
+	out := make([]poolStats, 0, len(gw.pools))
+	for _, p := range gw.pools {
+		ps := poolStats{Name: p.Name, Balance: p.cfg.Balance}
+		for _, u := range p.upstreams {
+			u.mu.Lock()
+			us := upstreamStats{
+				URL:       u.URL.String(),
+				Healthy:   u.healthy.Load(),
+				Active:    u.active.Load(),
+				Requests:  u.requests.Load(),
+				Failures:  u.failures.Load(),
+				LastError: u.lastError,
+			}
+			if now.Before(u.ejectedUntil) {
+				t := u.ejectedUntil
+				us.EjectedUntil = &t
+			}
+			if !u.lastCheck.IsZero() {
+				t := u.lastCheck
+				us.LastCheck = &t
+			}
+			u.mu.Unlock()
+			ps.Upstreams = append(ps.Upstreams, us)
+		}
+		out = append(out, ps)
+	}
+	return out
+}
+
+// Check fails when a pool has no healthy upstream left.
+func (gw *Gateway) Check(ctx context.Context) error {
+	var errs []error
+	for _, p := range gw.pools {
+		healthy := 0
+		for _, u := range p.upstreams {
+			if u.healthy.Load() {
+				healthy++
+			}
+		}
+		if healthy == 0 {
+			errs = append(errs, fmt.Errorf("pool %s: no healthy upstream", p.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StatsHandler serves each upstream's state as JSON.
+func (gw *Gateway) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]any{"pools": gw.Stats()})
+	})
+}
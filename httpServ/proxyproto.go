@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+PROXY protocol
+
+A TCP load balancer opens its own connection to us, so the peer address
+the kernel reports is the balancer's. With the PROXY protocol the balancer
+first sends one header describing the original connection, then the
+client's bytes:
+
+	v1 (text):   PROXY TCP4 203.0.113.7 10.0.0.5 51234 443\r\n
+	v2 (binary): \r\n\r\n\x00\r\nQUIT\n  ver/cmd  family  length  addresses  TLVs...
+
+ProxyListener reads that header and returns connections whose RemoteAddr
+is the real client, so r.RemoteAddr, the access log and per-IP limits see
+it. TLVs (v2 only) carry extras such as the original SNI name (authority)
+or a request ID.
+
+Trust: anyone who can connect could claim any address, so headers are only
+accepted from proxy_trusted_cidrs. Connections from elsewhere are passed through
+untouched. A trusted peer MUST send a header; one that does not (or is too
+slow, see proxy_header_timeout) is dropped.
+
+Headers are read in a goroutine per connection, so one slow peer does not
+hold up Accept for everyone else. At most MaxPending handshakes (default
+256) run at once; past that new connections wait in the kernel backlog
+instead of each getting a goroutine.
+*/
+
+// Proxy protocol v2 TLV types.
+const (
+	PP2TypeALPN      = 0x01
+	PP2TypeAuthority = 0x02
+	PP2TypeCRC32C    = 0x03
+	PP2TypeNoop      = 0x04
+	PP2TypeUniqueID  = 0x05
+	PP2TypeSSL       = 0x20
+	PP2TypeNetNS     = 0x30
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyTLV is one type-length-value field of a v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader is a parsed PROXY protocol header.
+type ProxyHeader struct {
+	Version     int
+	Local       bool     // v2 LOCAL command or v1 UNKNOWN: health check, addresses are the real ones
+	Source      net.Addr // original client; nil when Local
+	Destination net.Addr // address the client connected to; nil when Local
+	TLVs        []ProxyTLV
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *ProxyHeader) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Authority returns the host name the client asked for (usually SNI).
+func (h *ProxyHeader) Authority() string {
+	v, _ := h.TLV(PP2TypeAuthority)
+	return string(v)
+}
+
+// UniqueID returns the connection ID assigned by the balancer.
+func (h *ProxyHeader) UniqueID() string {
+	v, _ := h.TLV(PP2TypeUniqueID)
+	return string(v)
+}
+
+// ProxyListener accepts connections and strips PROXY protocol headers sent
+// by trusted peers.
+type ProxyListener struct {
+	net.Listener
+	Trusted       []*net.IPNet
+	HeaderTimeout time.Duration
+	// MaxPending bounds the handshakes in flight, default 256.
+	MaxPending int
+	Logger     *log.Logger
+
+	once      sync.Once
+	closeOnce sync.Once
+	pending   chan struct{} // semaphore, one slot per handshake
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+}
+
+// NewProxyListener wraps ln. trusted lists the CIDRs of the balancers.
+func NewProxyListener(ln net.Listener, trusted []*net.IPNet, headerTimeout time.Duration) *ProxyListener {
+	return &ProxyListener{
+		Listener:      ln,
+		Trusted:       trusted,
+		HeaderTimeout: headerTimeout,
+		MaxPending:    256,
+		Logger:        log.Default(),
+		conns:         make(chan net.Conn),
+		errs:          make(chan error),
+		done:          make(chan struct{}),
+	}
+}
+
+// ParseCIDRs parses CIDRs; a bare IP means just that address.
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, orig := range list {
+		s := orig
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR or IP address", orig)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Accept returns the next connection whose header (if any) has been read.
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	l.once.Do(func() {
+		l.pending = make(chan struct{}, max(l.MaxPending, 1))
+		go l.acceptLoop()
+	})
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener; it is safe to call more than once, and from
+// several goroutines.
+func (l *ProxyListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+func (l *ProxyListener) acceptLoop() {
+	for {
+		select {
+		case l.pending <- struct{}{}:
+		case <-l.done:
+			return
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			<-l.pending
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *ProxyListener) handshake(c net.Conn) {
+	defer func() { <-l.pending }()
+	if !l.trusted(c.RemoteAddr()) {
+		l.deliver(c)
+		return
+	}
+	if l.HeaderTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(l.HeaderTimeout))
+	}
+	br := bufio.NewReader(c)
+	h, err := ReadProxyHeader(br)
+	if err != nil {
+		l.Logger.Printf("proxy protocol: %s: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	l.deliver(&proxyConn{Conn: c, r: br, header: h})
+}
+
+func (l *ProxyListener) deliver(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *ProxyListener) trusted(addr net.Addr) bool {
+	a, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.Trusted {
+		if n.Contains(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reports the addresses from the PROXY header.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader // holds bytes read past the header
+	header *ProxyHeader
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) NetConn() net.Conn { return c.Conn }
+
+type proxyHeaderKey struct{}
+
+// ProxyConnContext is an http.Server.ConnContext hook that makes the PROXY
+// header available to handlers through ProxyHeaderFromContext.
+func ProxyConnContext(ctx context.Context, c net.Conn) context.Context {
+	for c != nil {
+		if pc, ok := c.(*proxyConn); ok {
+			return context.WithValue(ctx, proxyHeaderKey{}, pc.header)
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = u.NetConn()
+	}
+	return ctx
+}
+
+// ProxyHeaderFromContext returns the PROXY header of the request's
+// connection, if it had one.
+func ProxyHeaderFromContext(ctx context.Context) (*ProxyHeader, bool) {
+	h, ok := ctx.Value(proxyHeaderKey{}).(*ProxyHeader)
+	return h, ok
+}
+
+// ReadProxyHeader reads a v1 or v2 header from r.
+func ReadProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	switch first[0] {
+	case 'P':
+		return readProxyV1(r)
+	case '\r':
+		return readProxyV2(r)
+	default:
+		return nil, errors.New("trusted peer sent no PROXY header")
+	}
+}
+
+// readProxyV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n".
+func readProxyV1(r *bufio.Reader) (*ProxyHeader, error) {
+	const maxLen = 107 // from the spec, including CRLF
+	var line []byte
+	for len(line) < maxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header: no CRLF within 107 bytes")
+	}
+	f := strings.Fields(string(line[:len(line)-2]))
+	if len(f) < 2 || f[0] != "PROXY" {
+		return nil, errors.New("v1 header: malformed")
+	}
+	h := &ProxyHeader{Version: 1}
+	if f[1] == "UNKNOWN" {
+		h.Local = true
+		return h, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("v1 header: malformed %q", line)
+	}
+	src, err1 := v1Addr(f[2], f[4], f[1])
+	dst, err2 := v1Addr(f[3], f[5], f[1])
+	if err := errors.Join(err1, err2); err != nil {
+		return nil, fmt.Errorf("v1 header: %w", err)
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func v1Addr(ip, port, family string) (*net.TCPAddr, error) {
+	a := net.ParseIP(ip)
+	if a == nil || (family == "TCP4") != (a.To4() != nil) {
+		return nil, fmt.Errorf("bad %s address %q", family, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port %q", port)
+	}
+	return &net.TCPAddr{IP: a, Port: int(p)}, nil
+}
+
+// readProxyV2 parses the binary header.
+func readProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("v2 header: %w", err)
+	}
+	if !bytes.Equal(fixed[:12], proxyV2Signature) {
+		return nil, errors.New("v2 header: bad signature")
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("v2 header: unsupported version %d", fixed[12]>>4)
+	}
+	cmd, family := fixed[12]&0x0f, fixed[13]
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("v2 header: %w", err)
+	}
+
+	h := &ProxyHeader{Version: 2}
+	var addrLen int
+	switch family >> 4 {
+	case 0x1: // IPv4
+		addrLen = 12
+	case 0x2: // IPv6
+		addrLen = 36
+	case 0x3: // unix
+		addrLen = 216
+	case 0x0: // unspecified
+	default:
+		return nil, fmt.Errorf("v2 header: unknown address family %#x", family)
+	}
+	if len(body) < addrLen {
+		return nil, errors.New("v2 header: address block truncated")
+	}
+	switch cmd {
+	case 0x0:
+		h.Local = true
+	case 0x1:
+		h.Source, h.Destination = v2Addrs(family, body[:addrLen])
+		if h.Source == nil {
+			h.Local = true // UNSPEC or unix: keep the socket's addresses
+		}
+	default:
+		return nil, fmt.Errorf("v2 header: unknown command %#x", cmd)
+	}
+
+	crcAt := -1 // offset of the CRC32C value within body
+	tlvs := body[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, errors.New("v2 header: truncated TLV")
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, errors.New("v2 header: truncated TLV")
+		}
+		if tlvs[0] == PP2TypeCRC32C && crcAt < 0 {
+			crcAt = len(body) - len(tlvs) + 3
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+
+	if sum, ok := h.TLV(PP2TypeCRC32C); ok {
+		if len(sum) != 4 {
+			return nil, errors.New("v2 header: bad CRC32C TLV")
+		}
+		// The checksum covers the whole header with its own value zeroed.
+		want := binary.BigEndian.Uint32(sum)
+		zeroed := append(append([]byte{}, fixed...), body...)
+		copy(zeroed[len(fixed)+crcAt:], []byte{0, 0, 0, 0})
+		if crc32.Checksum(zeroed, crc32.MakeTable(crc32.Castagnoli)) != want {
+			return nil, errors.New("v2 header: CRC32C mismatch")
+		}
+	}
+	return h, nil
+}
+
+func v2Addrs(family byte, b []byte) (src, dst net.Addr) {
+	switch family {
+	case 0x11: // TCP over IPv4
+		return &net.TCPAddr{IP: net.IP(b[0:4]), Port: int(binary.BigEndian.Uint16(b[8:10]))},
+			&net.TCPAddr{IP: net.IP(b[4:8]), Port: int(binary.BigEndian.Uint16(b[10:12]))}
+	case 0x21: // TCP over IPv6
+		return &net.TCPAddr{IP: net.IP(b[0:16]), Port: int(binary.BigEndian.Uint16(b[32:34]))},
+			&net.TCPAddr{IP: net.IP(b[16:32]), Port: int(binary.BigEndian.Uint16(b[34:36]))}
+	}
+	return nil, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxyV2 builds a v2 header; crc adds a CRC32C TLV with the right sum.
+func proxyV2(cmd, family byte, addrs []byte, crc bool, tlvs ...ProxyTLV) []byte {
+	var body []byte
+	body = append(body, addrs...)
+	for _, t := range tlvs {
+		body = append(body, t.Type, byte(len(t.Value)>>8), byte(len(t.Value)))
+		body = append(body, t.Value...)
+	}
+	if crc {
+		body = append(body, PP2TypeCRC32C, 0, 4, 0, 0, 0, 0)
+	}
+	h := append([]byte{}, proxyV2Signature...)
+	h = append(h, 0x20|cmd, family, byte(len(body)>>8), byte(len(body)))
+	h = append(h, body...)
+	if crc {
+		sum := crc32.Checksum(h, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(h[len(h)-4:], sum)
+	}
+	return h
+}
+
+var (
+	// 203.0.113.7:51234 → 10.0.0.5:443
+	v4Addrs = []byte{203, 0, 113, 7, 10, 0, 0, 5, 0xc8, 0x22, 0x01, 0xbb}
+	// [2001:db8::1]:51234 → [2001:db8::2]:443
+	v6Addrs = append(append(append(
+		net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...),
+		0xc8, 0x22), 0x01, 0xbb)
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	badCRC := proxyV2(0x1, 0x11, v4Addrs, true)
+	badCRC[len(badCRC)-1] ^= 0xff
+
+	tests := []struct {
+		name      string
+		in        []byte
+		version   int
+		local     bool
+		src, dst  string
+		authority string
+		err       string
+	}{
+		{name: "v1 tcp4", in: []byte("PROXY TCP4 203.0.113.7 10.0.0.5 51234 443\r\n"),
+			version: 1, src: "203.0.113.7:51234", dst: "10.0.0.5:443"},
+		{name: "v1 tcp6", in: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 51234 443\r\n"),
+			version: 1, src: "[2001:db8::1]:51234", dst: "[2001:db8::2]:443"},
+		{name: "v1 unknown", in: []byte("PROXY UNKNOWN\r\n"), version: 1, local: true},
+		{name: "v1 truncated", in: []byte("PROXY TCP4 203.0.113.7"), err: "v1 header: EOF"},
+		{name: "v1 oversized", in: []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), err: "no CRLF within 107 bytes"},
+		{name: "v1 family mismatch", in: []byte("PROXY TCP4 2001:db8::1 10.0.0.5 51234 443\r\n"), err: "bad TCP4 address"},
+		{name: "v1 bad port", in: []byte("PROXY TCP4 203.0.113.7 10.0.0.5 70000 443\r\n"), err: "bad port"},
+		{name: "v1 missing field", in: []byte("PROXY TCP4 203.0.113.7 10.0.0.5 51234\r\n"), err: "malformed"},
+		{name: "no header", in: []byte("GET / HTTP/1.1\r\n"), err: "no PROXY header"},
+		{name: "empty", in: nil, err: "reading header"},
+
+		{name: "v2 tcp4", in: proxyV2(0x1, 0x11, v4Addrs, false),
+			version: 2, src: "203.0.113.7:51234", dst: "10.0.0.5:443"},
+		{name: "v2 tcp6", in: proxyV2(0x1, 0x21, v6Addrs, false),
+			version: 2, src: "[2001:db8::1]:51234", dst: "[2001:db8::2]:443"},
+		{name: "v2 local", in: proxyV2(0x0, 0x00, nil, false), version: 2, local: true},
+		{name: "v2 unspec family", in: proxyV2(0x1, 0x00, nil, false), version: 2, local: true},
+		{name: "v2 tlvs and crc", in: proxyV2(0x1, 0x11, v4Addrs, true,
+			ProxyTLV{Type: PP2TypeAuthority, Value: []byte("example.com")}, ProxyTLV{Type: PP2TypeNoop}),
+			version: 2, src: "203.0.113.7:51234", dst: "10.0.0.5:443", authority: "example.com"},
+		{name: "v2 bad crc", in: badCRC, err: "CRC32C mismatch"},
+		{name: "v2 crc of the wrong size", in: proxyV2(0x1, 0x11, v4Addrs, false,
+			ProxyTLV{Type: PP2TypeCRC32C, Value: []byte{1, 2}}), err: "bad CRC32C TLV"},
+		{name: "v2 truncated fixed part", in: proxyV2(0x1, 0x11, v4Addrs, false)[:10], err: "v2 header: unexpected EOF"},
+		{name: "v2 length past the data", in: proxyV2(0x1, 0x11, v4Addrs, false)[:20], err: "v2 header: unexpected EOF"},
+		{name: "v2 address block truncated", in: proxyV2(0x1, 0x21, v4Addrs, false), err: "address block truncated"},
+		{name: "v2 tlv past the header", in: proxyV2(0x1, 0x11, append(v4Addrs[:12:12], PP2TypeNoop, 0, 10, 'a', 'b', 'c'), false),
+			err: "truncated TLV"},
+		{name: "v2 tlv without its length", in: proxyV2(0x1, 0x11, append(v4Addrs[:12:12], PP2TypeNoop, 0), false),
+			err: "truncated TLV"},
+		{name: "v2 bad signature", in: append([]byte("\r\n\r\n\x00\r\nQUIX\n"), make([]byte, 8)...), err: "bad signature"},
+		{name: "v2 bad version", in: func() []byte {
+			b := proxyV2(0x1, 0x11, v4Addrs, false)
+			b[12] = 0x11
+			return b
+		}(), err: "unsupported version 1"},
+		{name: "v2 unknown command", in: proxyV2(0x2, 0x11, v4Addrs, false), err: "unknown command"},
+		{name: "v2 unknown family", in: proxyV2(0x1, 0x41, v4Addrs, false), err: "unknown address family"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(tt.in), strings.NewReader("payload")))
+			if tt.err != "" {
+				r = bufio.NewReader(bytes.NewReader(tt.in))
+			}
+			h, err := ReadProxyHeader(r)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Version != tt.version || h.Local != tt.local {
+				t.Errorf("version %d, local %v; want %d, %v", h.Version, h.Local, tt.version, tt.local)
+			}
+			if got := addrString(h.Source); got != tt.src {
+				t.Errorf("source = %q, want %q", got, tt.src)
+			}
+			if got := addrString(h.Destination); got != tt.dst {
+				t.Errorf("destination = %q, want %q", got, tt.dst)
+			}
+			if got := h.Authority(); got != tt.authority {
+				t.Errorf("authority = %q, want %q", got, tt.authority)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Errorf("bytes after the header = %q, want payload", rest)
+			}
+		})
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+// proxyPair returns a ProxyListener on loopback and a function that dials it.
+func proxyPair(t *testing.T, trusted string, timeout time.Duration) (*ProxyListener, func() net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nets, err := ParseCIDRs([]string{trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := NewProxyListener(ln, nets, timeout)
+	pl.Logger = log.New(io.Discard, "", 0)
+	t.Cleanup(func() { pl.Close() })
+	return pl, func() net.Conn {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		send    string
+		remote  string // "" for the socket's own address
+		read    string
+	}{
+		{"trusted v1", "127.0.0.0/8", "PROXY TCP4 203.0.113.7 10.0.0.5 51234 443\r\nhello",
+			"203.0.113.7:51234", "hello"},
+		{"trusted v2", "127.0.0.1", string(proxyV2(0x1, 0x11, v4Addrs, true)) + "hello",
+			"203.0.113.7:51234", "hello"},
+		{"trusted local", "127.0.0.1", string(proxyV2(0x0, 0x00, nil, false)) + "hello", "", "hello"},
+		{"untrusted passes through unchanged", "10.0.0.0/8", "PROXY TCP4 203.0.113.7 10.0.0.5 51234 443\r\nhello",
+			"", "PROXY TCP4 203.0.113.7 10.0.0.5 51234 443\r\nhello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl, dial := proxyPair(t, tt.trusted, time.Second)
+			client := dial()
+			if _, err := io.WriteString(client, tt.send); err != nil {
+				t.Fatal(err)
+			}
+			c, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			want := tt.remote
+			if want == "" {
+				want = client.LocalAddr().String()
+			}
+			if got := c.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr = %s, want %s", got, want)
+			}
+			buf := make([]byte, len(tt.read))
+			if _, err := io.ReadFull(c, buf); err != nil || string(buf) != tt.read {
+				t.Errorf("read %q, %v; want %q", buf, err, tt.read)
+			}
+		})
+	}
+}
+
+func TestProxyListenerDropsBadHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		send string
+	}{
+		{"no header", "GET / HTTP/1.1\r\n\r\n"},
+		{"header timeout", ""},
+		{"truncated header", "PROXY TCP4 203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl, dial := proxyPair(t, "127.0.0.1", 100*time.Millisecond)
+			client := dial()
+			io.WriteString(client, tt.send)
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				if c, err := pl.Accept(); err == nil {
+					accepted <- c
+				}
+			}()
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if n, err := client.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("client read %d, %v; want EOF from the dropped connection", n, err)
+			}
+			select {
+			case c := <-accepted:
+				c.Close()
+				t.Error("Accept returned a connection without a valid header")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestProxyListenerClose(t *testing.T) {
+	pl, _ := proxyPair(t, "127.0.0.1", time.Second)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := pl.Accept()
+		errc <- err
+	}()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pl.Close()
+		}()
+	}
+	wg.Wait()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("Accept after Close returned a connection")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept still blocked after Close")
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	if want := "10.0.0.0/8 192.0.2.1/32 2001:db8::1/128"; strings.Join(got, " ") != want {
+		t.Errorf("ParseCIDRs = %v, want %s", got, want)
+	}
+	if _, err := ParseCIDRs([]string{"nope"}); err == nil || !strings.Contains(err.Error(), `"nope"`) {
+		t.Errorf("err = %v, want one naming the bad entry", err)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+Zero-downtime restart
+
+A listening socket is just a file descriptor. If the new binary gets the
+SAME descriptor, the kernel keeps queueing connections on it the whole
+time; nobody sees "connection refused".
+
+	old process                               new process
+	───────────                               ───────────
+	SIGUSR2
+	  ↓ dup listening fds
+	  ↓ fork/exec own binary  ───────────→    ExtraFiles = fds 3, 4, ...
+	    HTTPSERV_INHERIT=tcp://:8080,...      rebuild net.Listener from fd
+	    HTTPSERV_READY_FD=<pipe>              start serving
+	  ↓ wait on pipe          ←───────────    write "ready" and close pipe
+	  ↓ srv.Shutdown (drain)
+	exit                                      keeps running
+
+If the child dies or does not report ready in time, the parent kills it and
+keeps serving as if nothing happened.
+
+systemd socket activation uses the same trick: systemd opens the sockets,
+starts us with them as fds 3.. and sets LISTEN_PID / LISTEN_FDS. Those
+sockets are matched to the configured addresses by their bound address.
+*/
+
+const (
+	envInherit  = "HTTPSERV_INHERIT"
+	envReadyFD  = "HTTPSERV_READY_FD"
+	firstFD     = 3 // after stdin, stdout, stderr
+	readyMarker = "ready"
+)
+
+type inheritedSocket struct {
+	key  string // "tcp://[::]:8080", "udp://[::]:8443"
+	file *os.File
+}
+
+type activeSocket struct {
+	key  string
+	file func() (*os.File, error)
+}
+
+// ListenerSet creates listeners, reusing sockets inherited from a parent
+// process or from systemd, and can hand them to a new process.
+type ListenerSet struct {
+	mu        sync.Mutex
+	inherited []inheritedSocket
+	active    []activeSocket
+	unix      []*net.UnixListener
+	readyFile *os.File
+}
+
+// NewListenerSet picks up inherited sockets from the environment.
+func NewListenerSet() (*ListenerSet, error) {
+	ls := &ListenerSet{}
+
+	if keys := os.Getenv(envInherit); keys != "" {
+		for i, key := range strings.Split(keys, ",") {
+			ls.inherited = append(ls.inherited, inheritedSocket{
+				key:  key,
+				file: os.NewFile(uintptr(firstFD+i), key),
+			})
+		}
+		if fd, err := strconv.Atoi(os.Getenv(envReadyFD)); err == nil {
+			ls.readyFile = os.NewFile(uintptr(fd), "ready-pipe")
+		}
+	} else if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			return nil, fmt.Errorf("LISTEN_FDS: %w", err)
+		}
+		for i := 0; i < n; i++ {
+			f := os.NewFile(uintptr(firstFD+i), "systemd-socket")
+			syscall.CloseOnExec(firstFD + i)
+			key, err := socketKey(f)
+			if err != nil {
+				return nil, fmt.Errorf("systemd socket %d: %w", firstFD+i, err)
+			}
+			ls.inherited = append(ls.inherited, inheritedSocket{key: key, file: f})
+		}
+	}
+
+	for _, env := range []string{envInherit, envReadyFD, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env)
+	}
+	return ls, nil
+}
+
+// socketKey describes a systemd-provided socket by its bound address.
+func socketKey(f *os.File) (string, error) {
+	if ln, err := net.FileListener(f); err == nil {
+		defer ln.Close()
+		return ln.Addr().Network() + "://" + ln.Addr().String(), nil
+	}
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return "", err
+	}
+	defer pc.Close()
+	return pc.LocalAddr().Network() + "://" + pc.LocalAddr().String(), nil
+}
+
+// take removes and returns the inherited socket matching network and addr.
+func (ls *ListenerSet) take(network, addr string) *os.File {
+	for i, s := range ls.inherited {
+		n, a, _ := strings.Cut(s.key, "://")
+		if n == network && sameAddr(a, addr) {
+			ls.inherited = append(ls.inherited[:i], ls.inherited[i+1:]...)
+			return s.file
+		}
+	}
+	return nil
+}
+
+// inherits reports whether an inherited socket matches network and addr.
+func (ls *ListenerSet) inherits(network, addr string) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, s := range ls.inherited {
+		n, a, _ := strings.Cut(s.key, "://")
+		if n == network && sameAddr(a, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameAddr compares a bound address with a configured one, treating an
+// empty host, 0.0.0.0 and :: as "all interfaces".
+func sameAddr(bound, want string) bool {
+	bh, bp, err1 := net.SplitHostPort(bound)
+	wh, wp, err2 := net.SplitHostPort(want)
+	if err1 != nil || err2 != nil {
+		return bound == want
+	}
+	if bp != wp {
+		return false
+	}
+	wildcard := func(h string) bool { return h == "" || h == "0.0.0.0" || h == "::" }
+	return bh == wh || (wildcard(bh) && wildcard(wh))
+}
+
+// Listen returns a stream listener for addr, inherited when possible.
+func (ls *ListenerSet) Listen(network, addr string) (net.Listener, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var ln net.Listener
+	if f := ls.take(network, addr); f != nil {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited %s://%s: %w", network, addr, err)
+		}
+		log.Printf("restart: reusing inherited socket %s://%s", network, l.Addr())
+		ln = l
+	} else {
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		ln = l
+	}
+
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if ok {
+		ls.active = append(ls.active, activeSocket{key: network + "://" + addr, file: filer.File})
+	}
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ls.unix = append(ls.unix, ul)
+	}
+	return ln, nil
+}
+
+// ListenPacket returns a packet socket (for HTTP/3), inherited when possible.
+func (ls *ListenerSet) ListenPacket(network, addr string) (net.PacketConn, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var pc net.PacketConn
+	if f := ls.take(network, addr); f != nil {
+		c, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited %s://%s: %w", network, addr, err)
+		}
+		pc = c
+	} else {
+		c, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		pc = c
+	}
+
+	if filer, ok := pc.(interface{ File() (*os.File, error) }); ok {
+		ls.active = append(ls.active, activeSocket{key: network + "://" + addr, file: filer.File})
+	}
+	return pc, nil
+}
+
+// CloseUnused closes inherited sockets no configured address asked for.
+func (ls *ListenerSet) CloseUnused() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, s := range ls.inherited {
+		log.Printf("restart: closing unused inherited socket %s", s.key)
+		s.file.Close()
+	}
+	ls.inherited = nil
+}
+
+// NotifyReady tells the parent process (if any) that this process serves.
+// It is safe to call when there is no parent.
+func (ls *ListenerSet) NotifyReady() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.readyFile == nil {
+		return
+	}
+	ls.readyFile.Write([]byte(readyMarker))
+	ls.readyFile.Close()
+	ls.readyFile = nil
+}
+
+// Restart starts a copy of the running binary with the active sockets and
+// waits until it reports ready. On error the child is killed.
+func (ls *ListenerSet) Restart(timeout time.Duration) error {
+	ls.mu.Lock()
+	var files []*os.File
+	var keys []string
+	for _, s := range ls.active {
+		f, err := s.file()
+		if err != nil {
+			ls.mu.Unlock()
+			closeAll(files)
+			return fmt.Errorf("dup %s: %w", s.key, err)
+		}
+		files = append(files, f)
+		keys = append(keys, s.key)
+	}
+	ls.mu.Unlock()
+	defer closeAll(files)
+
+	readR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		envInherit+"="+strings.Join(keys, ","),
+		envReadyFD+"="+strconv.Itoa(firstFD+len(files)),
+	)
+	err = cmd.Start()
+	readyW.Close() // only the child holds the write end now
+	if err != nil {
+		return fmt.Errorf("start %s: %w", exe, err)
+	}
+
+	// The child writes "ready"; EOF without it means the child died first.
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(readyMarker))
+		_, err := readR.Read(buf)
+		if err != nil || string(buf) != readyMarker {
+			result <- errors.New("child exited before it was ready")
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("child not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	go cmd.Wait() // reap the child should it exit before we do
+
+	// The child serves on our socket files now; closing ours must not
+	// delete them.
+	ls.mu.Lock()
+	for _, ul := range ls.unix {
+		ul.SetUnlinkOnClose(false)
+	}
+	ls.mu.Unlock()
+	log.Printf("restart: new process %d is ready", cmd.Process.Pid)
+	return nil
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// RunRestarter is a Lifecycle component: on SIGUSR2 it restarts the binary
+// and, once the child is ready, asks lc to shut this process down.
+func (ls *ListenerSet) RunRestarter(lc *Lifecycle, timeout time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		usr2 := make(chan os.Signal, 1)
+		signal.Notify(usr2, syscall.SIGUSR2)
+		defer signal.Stop(usr2)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-usr2:
+				log.Printf("restart: SIGUSR2 received, starting new process")
+				if err := ls.Restart(timeout); err != nil {
+					log.Printf("restart: failed, keeping current process: %v", err)
+					continue
+				}
+				lc.Stop("handed over to new process")
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package rewrite
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Engine applies a compiled Config. It is safe for concurrent use.
+type Engine struct {
+	canonicalHost string
+	trailingSlash string
+	rules         []*compiledRule
+	hits          []atomic.Uint64 // per rule, then canonical host, then trailing slash
+}
+
+// New compiles c; all problems in the file are reported together.
+func New(c *Config) (*Engine, error) {
+	rules, err := compile(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{
+		canonicalHost: strings.ToLower(c.CanonicalHost),
+		trailingSlash: c.TrailingSlash,
+		rules:         rules,
+		hits:          make([]atomic.Uint64, len(rules)+2),
+	}, nil
+}
+
+// Result says what happens to one request.
+type Result struct {
+	Action   string            `json:"action"`         // "none", "rewrite" or "redirect"
+	Rule     int               `json:"rule,omitempty"` // 1-based position in the file
+	Name     string            `json:"name,omitempty"` // rule name, "canonical_host" or "trailing_slash"
+	Status   int               `json:"status,omitempty"`
+	Target   string            `json:"target,omitempty"` // Location, or the rewritten path and query
+	Captures map[string]string `json:"captures,omitempty"`
+
+	hit int // index into Engine.hits, -1 for none
+}
+
+// Explain reports what the engine would do with r, without doing it.
+func (e *Engine) Explain(r *http.Request) Result {
+	host := hostname(r.Host)
+	if e.canonicalHost != "" && host != "" && host != e.canonicalHost && !localHost(host) {
+		target := scheme(r) + "://" + e.canonicalHost
+		if _, port, err := net.SplitHostPort(r.Host); err == nil {
+			target += ":" + port
+		}
+		return Result{Action: "redirect", Name: "canonical_host", Status: redirectStatus(r),
+			Target: target + requestURI(r.URL.EscapedPath(), r.URL.RawQuery), hit: len(e.rules)}
+	}
+
+	p := r.URL.Path
+	if p != "/" && p != "" {
+		var fixed string
+		switch e.trailingSlash {
+		case "strip":
+			if strings.HasSuffix(p, "/") {
+				fixed = localPath("/" + strings.Trim(p, "/"))
+			}
+		case "add":
+			last := p[strings.LastIndex(p, "/")+1:]
+			if last != "" && !strings.Contains(last, ".") {
+				fixed = localPath(p + "/")
+			}
+		}
+		if fixed != "" {
+			u := url.URL{Path: fixed}
+			return Result{Action: "redirect", Name: "trailing_slash", Status: redirectStatus(r),
+				Target: requestURI(u.EscapedPath(), r.URL.RawQuery), hit: len(e.rules) + 1}
+		}
+	}
+
+	for _, cr := range e.rules {
+		if cr.Host != "" && !strings.EqualFold(cr.Host, host) {
+			continue
+		}
+		if cr.methods != nil && !cr.methods[r.Method] {
+			continue
+		}
+		m := cr.re.FindStringSubmatchIndex(p)
+		if m == nil {
+			continue
+		}
+		res := Result{Rule: cr.index + 1, Name: cr.Name, hit: cr.index,
+			Target:   withQuery(localPath(expand(cr, p, m)), r.URL.RawQuery),
+			Captures: captures(cr, p, m)}
+		if cr.Rewrite != "" {
+			res.Action = "rewrite"
+		} else {
+			res.Action, res.Status = "redirect", cr.Status
+		}
+		return res
+	}
+	return Result{Action: "none", hit: -1}
+}
+
+// Middleware redirects, or passes a request with the rewritten URL to next.
+// The request seen by middleware outside this one keeps its original URL.
+func (e *Engine) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := e.Explain(r)
+		if res.hit >= 0 {
+			e.hits[res.hit].Add(1)
+		}
+		switch res.Action {
+		case "redirect":
+			http.Redirect(w, r, res.Target, res.Status)
+		case "rewrite":
+			u, err := url.Parse(res.Target)
+			if err != nil {
+				http.Error(w, "bad rewrite target", http.StatusInternalServerError)
+				return
+			}
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path, r2.URL.RawPath, r2.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+			next.ServeHTTP(w, r2)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RuleInfo describes one rule and how often it has fired.
+type RuleInfo struct {
+	Rule   int    `json:"rule,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Match  string `json:"match"`
+	Action string `json:"action"`
+	Status int    `json:"status,omitempty"`
+	Target string `json:"target,omitempty"`
+	Hits   uint64 `json:"hits"`
+}
+
+// Rules lists the rules in order, followed by the canonical host and
+// trailing slash policies when set, with how often each has fired.
+func (e *Engine) Rules() []RuleInfo {
+	out := make([]RuleInfo, 0, len(e.rules)+2)
+	for _, cr := range e.rules {
+		ri := RuleInfo{Rule: cr.index + 1, Name: cr.Name, Match: cr.Match, Target: cr.Rewrite,
+			Action: "rewrite", Hits: e.hits[cr.index].Load()}
+		if cr.Pattern != "" {
+			ri.Match = cr.Pattern
+		}
+		if cr.Redirect != "" {
+			ri.Action, ri.Status, ri.Target = "redirect", cr.Status, cr.Redirect
+		}
+		out = append(out, ri)
+	}
+	if e.canonicalHost != "" {
+		out = append(out, RuleInfo{Name: "canonical_host", Match: "host != " + e.canonicalHost,
+			Action: "redirect", Target: e.canonicalHost, Hits: e.hits[len(e.rules)].Load()})
+	}
+	if e.trailingSlash == "strip" || e.trailingSlash == "add" {
+		out = append(out, RuleInfo{Name: "trailing_slash", Match: e.trailingSlash,
+			Action: "redirect", Hits: e.hits[len(e.rules)+1].Load()})
+	}
+	return out
+}
+
+// ExplainHandler is the dry run:
+//
+//	GET ?url=https://old.example.com/items/7&method=GET → the Result for that request
+//	GET                                               → every rule with its hit count
+func (e *Engine) ExplainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		raw := r.URL.Query().Get("url")
+		if raw == "" {
+			enc.Encode(map[string]any{"rules": e.Rules()})
+			return
+		}
+		method := strings.ToUpper(r.URL.Query().Get("method"))
+		if method == "" {
+			method = http.MethodGet
+		}
+		req, err := http.NewRequest(method, raw, nil)
+		if err != nil || !strings.HasPrefix(req.URL.Path, "/") {
+			w.WriteHeader(http.StatusBadRequest)
+			enc.Encode(map[string]string{"error": fmt.Sprintf("url %q: want an absolute URL or a path", raw)})
+			return
+		}
+		enc.Encode(map[string]any{"url": raw, "method": method, "result": e.Explain(req)})
+	})
+}
+
+// expand fills in the target with the captures of the decoded path m
+// matched, each escaped for a path first: a %3F, %23 or space in the
+// request must not become a query, a fragment or a broken target. Slashes
+// stay slashes, so {rest...} keeps its segments.
+func expand(cr *compiledRule, path string, m []int) string {
+	var src strings.Builder
+	escaped := make([]int, len(m))
+	for i := 0; i < len(m); i += 2 {
+		if m[i] < 0 {
+			escaped[i], escaped[i+1] = -1, -1
+			continue
+		}
+		escaped[i] = src.Len()
+		src.WriteString((&url.URL{Path: path[m[i]:m[i+1]]}).EscapedPath())
+		escaped[i+1] = src.Len()
+	}
+	return string(cr.re.ExpandString(nil, cr.target, src.String(), escaped))
+}
+
+func captures(cr *compiledRule, path string, m []int) map[string]string {
+	out := make(map[string]string)
+	for i, name := range cr.re.SubexpNames() {
+		if i == 0 || m[2*i] < 0 {
+			continue
+		}
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		out[name] = path[m[2*i]:m[2*i+1]]
+	}
+	return out
+}
+
+// withQuery appends the request's query to target, after any query the
+// target has of its own and before a fragment.
+func withQuery(target, rawQuery string) string {
+	if rawQuery == "" {
+		return target
+	}
+	target, frag, hasFrag := strings.Cut(target, "#")
+	if strings.Contains(target, "?") {
+		target += "&" + rawQuery
+	} else {
+		target += "?" + rawQuery
+	}
+	if hasFrag {
+		target += "#" + frag
+	}
+	return target
+}
+
+// localPath collapses the leading slashes of a path, and the backslashes
+// browsers read as slashes, so GET //evil.com/x cannot come back as the
+// protocol-relative Location //evil.com/x/. Targets with a scheme are
+// left alone.
+func localPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+	return "/" + strings.TrimLeft(p, `/\`)
+}
+
+func requestURI(path, rawQuery string) string {
+	if rawQuery == "" {
+		return path
+	}
+	return path + "?" + rawQuery
+}
+
+// redirectStatus keeps the method and body for anything but GET and HEAD.
+func redirectStatus(r *http.Request) int {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return http.StatusMovedPermanently
+	}
+	return http.StatusPermanentRedirect
+}
+
+func scheme(r *http.Request) string {
+	switch {
+	case r.URL.Scheme != "":
+		return r.URL.Scheme
+	case r.TLS != nil:
+		return "https"
+	case r.Header.Get("X-Forwarded-Proto") == "https":
+		return "https"
+	}
+	return "http"
+}
+
+func hostname(hostport string) string {
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		hostport = h
+	}
+	return strings.ToLower(strings.Trim(hostport, "[]"))
+}
+
+// localHost reports whether host is an IP literal or localhost: health
+// checks and local development are not redirected to the canonical host.
+func localHost(host string) bool {
+	return host == "localhost" || net.ParseIP(host) != nil
+}
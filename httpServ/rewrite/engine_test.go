@@ -0,0 +1,195 @@
+package rewrite
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newEngine(t *testing.T, c *Config) *Engine {
+	t.Helper()
+	e, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// readRequest parses a request line the way the server does, so a path
+// like //evil.com/x stays a path instead of becoming a host.
+func readRequest(t *testing.T, method, uri string) *http.Request {
+	t.Helper()
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(
+		method + " " + uri + " HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestExplain(t *testing.T) {
+	e := newEngine(t, &Config{
+		CanonicalHost: "www.example.com",
+		Rules: []Rule{
+			{Name: "old blog", Match: `^/blog/(\d{4})/(.+)\.html$`, Redirect: "/posts/$1/$2", Status: 301},
+			{Pattern: "/docs/{rest...}", Rewrite: "/static/docs/{rest}"},
+			{Pattern: "/items/{id}", Methods: []string{"GET"}, Redirect: "https://api.example.com/v2/items/{id}?src=legacy"},
+		},
+	})
+	tests := []struct {
+		name   string
+		method string
+		uri    string
+		host   string
+		want   Result
+	}{
+		{"canonical host", "GET", "/a?b=1", "old.example.com:8080",
+			Result{Action: "redirect", Name: "canonical_host", Status: 301, Target: "http://www.example.com:8080/a?b=1"}},
+		{"canonical host keeps the method", "POST", "/a", "old.example.com",
+			Result{Action: "redirect", Name: "canonical_host", Status: 308, Target: "http://www.example.com/a"}},
+		{"localhost is left alone", "GET", "/a", "localhost:8080", Result{Action: "none"}},
+		{"regexp redirect", "GET", "/blog/2019/hello.html?ref=x", "",
+			Result{Action: "redirect", Rule: 1, Name: "old blog", Status: 301, Target: "/posts/2019/hello?ref=x",
+				Captures: map[string]string{"1": "2019", "2": "hello"}}},
+		{"pattern rewrite", "GET", "/docs/a/b.html", "",
+			Result{Action: "rewrite", Rule: 2, Target: "/static/docs/a/b.html", Captures: map[string]string{"rest": "a/b.html"}}},
+		{"target query comes first", "GET", "/items/7?x=1", "",
+			Result{Action: "redirect", Rule: 3, Status: 302, Target: "https://api.example.com/v2/items/7?src=legacy&x=1",
+				Captures: map[string]string{"id": "7"}}},
+		{"method filter", "POST", "/items/7", "", Result{Action: "none"}},
+		{"no rule", "GET", "/other", "", Result{Action: "none"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := readRequest(t, tt.method, tt.uri)
+			if tt.host != "" {
+				r.Host = tt.host
+			} else {
+				r.Host = "www.example.com"
+			}
+			got := e.Explain(r)
+			got.hit = 0
+			if got.Action != tt.want.Action || got.Rule != tt.want.Rule || got.Name != tt.want.Name ||
+				got.Status != tt.want.Status || got.Target != tt.want.Target || len(got.Captures) != len(tt.want.Captures) {
+				t.Fatalf("Explain = %+v\nwant      %+v", got, tt.want)
+			}
+			for k, v := range tt.want.Captures {
+				if got.Captures[k] != v {
+					t.Errorf("capture %s = %q, want %q", k, got.Captures[k], v)
+				}
+			}
+		})
+	}
+}
+
+// Redirects built from the request path must stay on this host: a path
+// with doubled slashes or backslashes must not come back as a
+// protocol-relative Location.
+func TestRedirectStaysLocal(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		uri      string
+		location string
+	}{
+		{"add slash", Config{TrailingSlash: "add"}, "//evil.com/x", "/evil.com/x/"},
+		{"add slash with backslash", Config{TrailingSlash: "add"}, `/\evil.com/x`, "/evil.com/x/"},
+		{"add slash keeps the query", Config{TrailingSlash: "add"}, "///evil.com/x?a=1", "/evil.com/x/?a=1"},
+		{"add slash to a plain path", Config{TrailingSlash: "add"}, "/a/b", "/a/b/"},
+		{"strip slash", Config{TrailingSlash: "strip"}, "//evil.com/", "/evil.com"},
+		{"strip slash from a plain path", Config{TrailingSlash: "strip"}, "/a/b/", "/a/b"},
+		{"capture at the start of the target", Config{Rules: []Rule{{Match: "^/go/(.*)$", Redirect: "/$1"}}},
+			"/go//evil.com/x", "/evil.com/x"},
+		{"backslash capture", Config{Rules: []Rule{{Match: "^/go/(.*)$", Redirect: "/$1"}}},
+			`/go/\evil.com`, "/%5Cevil.com"}, // escaped, so no browser reads it as a slash
+		{"encoded slash capture", Config{Rules: []Rule{{Match: "^/go/(.*)$", Redirect: "/$1"}}},
+			"/go/%2Fevil.com", "/evil.com"},
+		{"encoded backslash capture", Config{Rules: []Rule{{Pattern: "/go/{rest...}", Redirect: "/{rest}"}}},
+			"/go/%5C%5Cevil.com", "/%5C%5Cevil.com"},
+		{"pattern rest capture", Config{Rules: []Rule{{Pattern: "/go/{rest...}", Redirect: "/{rest}"}}},
+			"/go//evil.com", "/evil.com"},
+		{"absolute target is kept", Config{Rules: []Rule{{Match: "^/go/(.*)$", Redirect: "https://example.com/$1"}}},
+			"/go//x", "https://example.com//x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEngine(t, &tt.cfg)
+			rr := httptest.NewRecorder()
+			e.Middleware(http.NotFoundHandler()).ServeHTTP(rr, readRequest(t, "GET", tt.uri))
+			if rr.Code/100 != 3 {
+				t.Fatalf("status = %d, want a redirect", rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRewrite(t *testing.T) {
+	e := newEngine(t, &Config{Rules: []Rule{{Pattern: "/docs/{rest...}", Rewrite: "/static/{rest}?v=2"}}})
+	var seen string
+	h := e.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.RequestURI()
+	}))
+	r := readRequest(t, "GET", "/docs/a.html?x=1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if seen != "/static/a.html?v=2&x=1" {
+		t.Errorf("next saw %q, want /static/a.html?v=2&x=1", seen)
+	}
+	if r.URL.Path != "/docs/a.html" {
+		t.Errorf("caller's URL changed to %q", r.URL.Path)
+	}
+	if hits := e.Rules()[0].Hits; hits != 1 {
+		t.Errorf("hits = %d, want 1", hits)
+	}
+}
+
+// Captures come from the decoded path; an escaped ?, # or / in them must
+// stay part of the path in the target, not start a query or fragment.
+func TestEscapedCaptures(t *testing.T) {
+	e := newEngine(t, &Config{Rules: []Rule{
+		{Pattern: "/docs/{rest...}", Rewrite: "/static/docs/{rest}"},
+		{Pattern: "/old/{id}", Redirect: "/new/{id}?src=old"},
+		{Match: "^/blog/(.+)$", Redirect: "https://blog.example.com/$1"},
+	}})
+	tests := []struct {
+		name  string
+		uri   string
+		path  string // what next sees, decoded
+		query string
+		loc   string // Location of a redirect
+	}{
+		{name: "rewrite %3F", uri: "/docs/a%3Fadmin=1", path: "/static/docs/a?admin=1"},
+		{name: "rewrite %3F keeps the real query", uri: "/docs/a%3Fadmin=1?x=2", path: "/static/docs/a?admin=1", query: "x=2"},
+		{name: "rewrite %23", uri: "/docs/a%23frag/b", path: "/static/docs/a#frag/b"},
+		{name: "rewrite %2F", uri: "/docs/a%2Fb", path: "/static/docs/a/b"},
+		{name: "rewrite space and %25", uri: "/docs/a%20b%25c", path: "/static/docs/a b%c"},
+		{name: "redirect %3F", uri: "/old/7%3Fadmin=1", loc: "/new/7%3Fadmin=1?src=old"},
+		{name: "redirect %23", uri: "/old/7%23x?y=1", loc: "/new/7%23x?src=old&y=1"},
+		{name: "redirect to another host %3F", uri: "/blog/post%3Fadmin=1", loc: "https://blog.example.com/post%3Fadmin=1"},
+		{name: "redirect to another host %2F", uri: "/blog/a%2Fb", loc: "https://blog.example.com/a/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen *http.Request
+			rr := httptest.NewRecorder()
+			e.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r })).
+				ServeHTTP(rr, readRequest(t, "GET", tt.uri))
+			if tt.loc != "" {
+				if got := rr.Header().Get("Location"); rr.Code/100 != 3 || got != tt.loc {
+					t.Errorf("%d Location = %q, want %q", rr.Code, got, tt.loc)
+				}
+				return
+			}
+			if seen == nil {
+				t.Fatalf("not rewritten: %d %s", rr.Code, rr.Header().Get("Location"))
+			}
+			if seen.URL.Path != tt.path || seen.URL.RawQuery != tt.query {
+				t.Errorf("next saw path %q, query %q; want %q, %q", seen.URL.Path, seen.URL.RawQuery, tt.path, tt.query)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package rewrite rewrites and redirects request URLs from an ordered rules
+// file, so legacy URLs need no one-off handlers.
+//
+//	canonical_host: www.example.com   # other host names → 301 to this one
+//	trailing_slash: strip             # strip | add | ignore (default)
+//	rules:
+//	  - name: old blog
+//	    match: ^/blog/(\d{4})/(.+)\.html$       # regexp on the path
+//	    redirect: /posts/$1/$2                  # $1, ${name}
+//	    status: 301                             # 301, 302 (default), 307, 308
+//	  - pattern: /docs/{rest...}                # ServeMux-style wildcards
+//	    rewrite: /static/docs/{rest}            # served internally, URL unchanged
+//	  - pattern: /items/{id}
+//	    methods: [GET, HEAD]
+//	    host: old.example.com
+//	    redirect: https://api.example.com/v2/items/{id}?src=legacy
+//
+// A request goes through, in order:
+//
+//	canonical host?   Host is not canonical_host   → 301 (IPs and localhost are left alone)
+//	trailing slash?   /a/ with strip, /a with add    → 301
+//	                  (both use 308 for methods other than GET and HEAD)
+//	rules             first rule whose host, methods and path match wins
+//	                    redirect → Location: target, status
+//	                    rewrite  → next handler sees the target path
+//
+// The query string is kept: a target with its own query adds those
+// parameters first. Rewrites are not run through the rules again.
+//
+// "match" is a regexp against the path; "pattern" is a path with {name}
+// segments and a trailing {name...}, anchored at both ends. Both match the
+// decoded path; captures are escaped again when they go into the target,
+// so /docs/a%3Fb stays one path and does not grow a query. A target that
+// expands to a path never starts with more than one slash, whatever the
+// captures hold, so it cannot turn into a redirect to another host.
+//
+// Engine.Explain (and ExplainHandler) is the dry run: which rule a URL would
+// hit and where it would end up, without sending a request.
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the rules file.
+type Config struct {
+	CanonicalHost string `yaml:"canonical_host"`
+	TrailingSlash string `yaml:"trailing_slash"` // strip, add, ignore
+	Rules         []Rule `yaml:"rules"`
+}
+
+// Rule is one match and what to do with it. Exactly one of Match and
+// Pattern, and one of Rewrite and Redirect, must be set.
+type Rule struct {
+	Name     string   `yaml:"name"`
+	Match    string   `yaml:"match"`   // regexp on the path
+	Pattern  string   `yaml:"pattern"` // "/items/{id}", "/docs/{rest...}"
+	Host     string   `yaml:"host"`    // only requests for this host
+	Methods  []string `yaml:"methods"` // only these methods
+	Rewrite  string   `yaml:"rewrite"` // internal target path
+	Redirect string   `yaml:"redirect"`
+	Status   int      `yaml:"status"` // redirect status, default 302
+}
+
+// LoadFile reads a rules file (YAML or JSON).
+func LoadFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("rewrite rules: %w", err)
+	}
+	defer f.Close()
+	var c Config
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("rewrite rules %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// compiledRule is a Rule with its path regexp and expansion template.
+type compiledRule struct {
+	Rule
+	index   int
+	re      *regexp.Regexp
+	target  string // Rewrite or Redirect, {name} turned into ${name}
+	methods map[string]bool
+}
+
+func (r *Rule) label(i int) string {
+	if r.Name != "" {
+		return fmt.Sprintf("rule %d (%s)", i+1, r.Name)
+	}
+	return fmt.Sprintf("rule %d", i+1)
+}
+
+func compile(c *Config) ([]*compiledRule, error) {
+	var errs []error
+	switch c.TrailingSlash {
+	case "", "ignore", "strip", "add":
+	default:
+		errs = append(errs, fmt.Errorf("trailing_slash must be strip, add or ignore, got %q", c.TrailingSlash))
+	}
+	if strings.ContainsAny(c.CanonicalHost, "/:") {
+		errs = append(errs, fmt.Errorf("canonical_host %q: want a host name without scheme or port", c.CanonicalHost))
+	}
+	rules := make([]*compiledRule, 0, len(c.Rules))
+	for i, r := range c.Rules {
+		cr, err := compileRule(i, r)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.label(i), err))
+			continue
+		}
+		rules = append(rules, cr)
+	}
+	return rules, errors.Join(errs...)
+}
+
+func compileRule(i int, r Rule) (*compiledRule, error) {
+	cr := &compiledRule{Rule: r, index: i}
+	var err error
+	switch {
+	case (r.Match == "") == (r.Pattern == ""):
+		return nil, errors.New("set exactly one of match and pattern")
+	case r.Match != "":
+		cr.re, err = regexp.Compile(r.Match)
+	default:
+		cr.re, err = patternRegexp(r.Pattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case (r.Rewrite == "") == (r.Redirect == ""):
+		return nil, errors.New("set exactly one of rewrite and redirect")
+	case r.Rewrite != "":
+		if !strings.HasPrefix(r.Rewrite, "/") {
+			return nil, fmt.Errorf("rewrite %q must be a path starting with /", r.Rewrite)
+		}
+		if r.Status != 0 {
+			return nil, errors.New("status only applies to redirects")
+		}
+		cr.target = r.Rewrite
+	default:
+		if strings.HasPrefix(r.Redirect, "//") {
+			return nil, fmt.Errorf("redirect %q: use a full URL, with scheme, for another host", r.Redirect)
+		}
+		cr.target = r.Redirect
+		switch r.Status {
+		case 0:
+			cr.Status = http.StatusFound
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("status %d: want 301, 302, 307 or 308", r.Status)
+		}
+	}
+	if r.Pattern != "" {
+		cr.target = braceVar.ReplaceAllString(cr.target, "$${$1}")
+	}
+	// Every reference in the target must name a group of the match.
+	for _, m := range refVar.FindAllStringSubmatch(cr.target, -1) {
+		name := m[1] + m[2]
+		if !hasGroup(cr.re, name) {
+			return nil, fmt.Errorf("target %q refers to $%s, which the match does not capture", cr.target, name)
+		}
+	}
+
+	if len(r.Methods) > 0 {
+		cr.methods = make(map[string]bool, len(r.Methods))
+		for _, m := range r.Methods {
+			cr.methods[strings.ToUpper(m)] = true
+		}
+	}
+	return cr, nil
+}
+
+var (
+	braceVar = regexp.MustCompile(`\{(\w+)\}`)
+	refVar   = regexp.MustCompile(`\$(?:(\w+)|\{(\w+)\})`)
+	segVar   = regexp.MustCompile(`^\{(\w+)(\.\.\.)?\}$`)
+)
+
+// patternRegexp turns "/items/{id}/{rest...}" into an anchored regexp with
+// named groups: {name} is one segment, {name...} the remainder of the path.
+func patternRegexp(p string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("pattern %q must start with /", p)
+	}
+	segs := strings.Split(p[1:], "/")
+	seen := make(map[string]bool)
+	var b strings.Builder
+	b.WriteString("^")
+	for i, s := range segs {
+		b.WriteString("/")
+		m := segVar.FindStringSubmatch(s)
+		if m != nil {
+			if seen[m[1]] {
+				return nil, fmt.Errorf("pattern %q: duplicate name {%s}", p, m[1])
+			}
+			seen[m[1]] = true
+		}
+		switch {
+		case m == nil:
+			if strings.ContainsAny(s, "{}") {
+				return nil, fmt.Errorf("pattern %q: bad segment %q (want {name} or {name...})", p, s)
+			}
+			b.WriteString(regexp.QuoteMeta(s))
+		case m[2] != "":
+			if i != len(segs)-1 {
+				return nil, fmt.Errorf("pattern %q: {%s...} must be the last segment", p, m[1])
+			}
+			fmt.Fprintf(&b, "(?P<%s>.*)", m[1])
+		default:
+			fmt.Fprintf(&b, "(?P<%s>[^/]+)", m[1])
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func hasGroup(re *regexp.Regexp, name string) bool {
+	if n, err := strconv.Atoi(name); err == nil {
+		return n <= re.NumSubexp()
+	}
+	return re.SubexpIndex(name) >= 0
+}
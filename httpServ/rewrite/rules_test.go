@@ -0,0 +1,136 @@
+package rewrite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPatternRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    map[string]string // nil: no match
+	}{
+		{"/items/{id}", "/items/7", map[string]string{"id": "7"}},
+		{"/items/{id}", "/items/7/", nil},
+		{"/items/{id}", "/items/", nil},
+		{"/items/{id}", "/items/7/edit", nil},
+		{"/items/{id}", "/x/items/7", nil},
+		{"/items/{id}/edit", "/items/a.b/edit", map[string]string{"id": "a.b"}},
+		{"/docs/{rest...}", "/docs/a/b/c.html", map[string]string{"rest": "a/b/c.html"}},
+		{"/docs/{rest...}", "/docs/", map[string]string{"rest": ""}},
+		{"/docs/{rest...}", "/docs", nil},
+		{"/{a}/{b}/{rest...}", "/x/y/z/w", map[string]string{"a": "x", "b": "y", "rest": "z/w"}},
+		{"/a.b/{id}", "/axb/1", nil},
+		{"/", "/", map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			re, err := patternRegexp(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := re.FindStringSubmatch(tt.path)
+			if m == nil {
+				if tt.want != nil {
+					t.Errorf("no match, want %v", tt.want)
+				}
+				return
+			}
+			if tt.want == nil {
+				t.Fatalf("matched %q, want no match", m[0])
+			}
+			got := map[string]string{}
+			for i, name := range re.SubexpNames() {
+				if i > 0 {
+					got[name] = m[i]
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("captures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatternRegexpErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     string
+	}{
+		{"items/{id}", "must start with /"},
+		{"/items/{rest...}/edit", "must be the last segment"},
+		{"/items/{id", "bad segment"},
+		{"/items/x{id}", "bad segment"},
+		{"/items/{}", "bad segment"},
+		{"/{id}/{id}", "duplicate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, err := patternRegexp(tt.pattern)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompileRule(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   Rule
+		target string // expansion template; "" when the rule is rejected
+		status int
+		err    string
+	}{
+		{"pattern rewrite", Rule{Pattern: "/docs/{rest...}", Rewrite: "/static/docs/{rest}"},
+			"/static/docs/${rest}", 0, ""},
+		{"pattern redirect defaults to 302", Rule{Pattern: "/items/{id}", Redirect: "https://api.example.com/v2/items/{id}?src=legacy"},
+			"https://api.example.com/v2/items/${id}?src=legacy", 302, ""},
+		{"regexp keeps $n", Rule{Match: `^/blog/(\d{4})/(.+)\.html$`, Redirect: "/posts/$1/$2", Status: 301},
+			"/posts/$1/$2", 301, ""},
+		{"regexp named group", Rule{Match: `^/u/(?P<name>\w+)$`, Rewrite: "/users/${name}"},
+			"/users/${name}", 0, ""},
+
+		{"neither match nor pattern", Rule{Rewrite: "/x"}, "", 0, "exactly one of match and pattern"},
+		{"both match and pattern", Rule{Match: "^/a$", Pattern: "/a", Rewrite: "/x"}, "", 0, "exactly one of match and pattern"},
+		{"bad regexp", Rule{Match: "^/(a$", Rewrite: "/x"}, "", 0, "missing closing )"},
+		{"bad pattern", Rule{Pattern: "/{rest...}/x", Rewrite: "/x"}, "", 0, "must be the last segment"},
+		{"neither rewrite nor redirect", Rule{Pattern: "/a"}, "", 0, "exactly one of rewrite and redirect"},
+		{"both rewrite and redirect", Rule{Pattern: "/a", Rewrite: "/b", Redirect: "/c"}, "", 0, "exactly one of rewrite and redirect"},
+		{"rewrite to a URL", Rule{Pattern: "/a", Rewrite: "https://example.com/"}, "", 0, "must be a path starting with /"},
+		{"status on a rewrite", Rule{Pattern: "/a", Rewrite: "/b", Status: 301}, "", 0, "status only applies to redirects"},
+		{"bad status", Rule{Pattern: "/a", Redirect: "/b", Status: 200}, "", 0, "want 301, 302, 307 or 308"},
+		{"protocol-relative redirect", Rule{Pattern: "/a", Redirect: "//cdn.example.com/a"}, "", 0, "use a full URL"},
+		{"unknown pattern variable", Rule{Pattern: "/items/{id}", Redirect: "/v2/{item}"}, "", 0, "does not capture"},
+		{"group number out of range", Rule{Match: "^/a/(.*)$", Redirect: "/b/$2"}, "", 0, "does not capture"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr, err := compileRule(0, tt.rule)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cr.target != tt.target || cr.Status != tt.status {
+				t.Errorf("target %q, status %d; want %q, %d", cr.target, cr.Status, tt.target, tt.status)
+			}
+		})
+	}
+}
+
+func TestCompileRuleMethods(t *testing.T) {
+	cr, err := compileRule(0, Rule{Pattern: "/a", Rewrite: "/b", Methods: []string{"get", "HEAD"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cr.methods, map[string]bool{"GET": true, "HEAD": true}) {
+		t.Errorf("methods = %v", cr.methods)
+	}
+}
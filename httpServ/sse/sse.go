@@ -0,0 +1,241 @@
+// Package sse streams Server-Sent Events to browsers.
+//
+// A Broker fans published events out to every connected client:
+//
+//	broker := sse.NewBroker()
+//	mux.Handle("GET /events", broker)
+//	srv.RegisterOnShutdown(broker.Close)
+//
+//	broker.Publish(sse.Event{Event: "item.created", Data: `{"id": 7}`})
+//
+// and in the browser:
+//
+//	const es = new EventSource("/events");
+//	es.addEventListener("item.created", e => console.log(JSON.parse(e.data)));
+//
+// Each client has its own buffered channel. A client that falls so far
+// behind that its buffer is full is disconnected instead of slowing down
+// everyone else; EventSource reconnects on its own and sends the
+// Last-Event-ID header, and the broker replays what was missed from a
+// bounded buffer of recent events.
+//
+// A comment line is sent every Heartbeat so proxies do not close idle
+// streams and dead clients are noticed. Streams end when the client goes
+// away or Close is called (register it with srv.RegisterOnShutdown so
+// Shutdown does not wait for streams that never finish).
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is one message. Broker.Publish assigns the ID.
+type Event struct {
+	ID    string
+	Event string        // event type; empty means "message"
+	Data  string        // may contain newlines
+	Retry time.Duration // reconnect delay hint for the client, 0 to omit
+}
+
+// write sends ev in the text/event-stream format.
+func (ev Event) write(w http.ResponseWriter) error {
+	var b strings.Builder
+	if ev.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", ev.ID)
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", ev.Event)
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(ev.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteByte('\n')
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// Broker distributes events to subscribed clients.
+type Broker struct {
+	BufferSize int           // events queued per client before it is evicted, default 64
+	ReplaySize int           // recent events kept for Last-Event-ID, default 256
+	Heartbeat  time.Duration // comment interval, default 15s
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	replay  []Event // oldest first, at most ReplaySize
+	lastID  uint64
+	evicted uint64
+	done    chan struct{}
+	closed  bool
+}
+
+type client struct {
+	ch chan Event
+}
+
+// NewBroker returns a Broker with default limits.
+func NewBroker() *Broker {
+	return &Broker{
+		BufferSize: 64,
+		ReplaySize: 256,
+		Heartbeat:  15 * time.Second,
+		clients:    make(map[*client]struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Publish assigns ev the next ID, keeps it for replay and queues it for
+// every client. It never blocks.
+func (b *Broker) Publish(ev Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ev
+	}
+	b.lastID++
+	ev.ID = strconv.FormatUint(b.lastID, 10)
+	b.replay = append(b.replay, ev)
+	if over := len(b.replay) - b.ReplaySize; over > 0 {
+		b.replay = append(b.replay[:0], b.replay[over:]...)
+	}
+	for c := range b.clients {
+		select {
+		case c.ch <- ev:
+		default:
+			// Too slow: drop it; it resumes via Last-Event-ID.
+			delete(b.clients, c)
+			close(c.ch)
+			b.evicted++
+		}
+	}
+	return ev
+}
+
+// Clients returns the number of connected clients.
+func (b *Broker) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// Evicted returns how many clients were dropped for being too slow.
+func (b *Broker) Evicted() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.evicted
+}
+
+// Close ends every stream and refuses new ones.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.done)
+	for c := range b.clients {
+		delete(b.clients, c)
+		close(c.ch)
+	}
+}
+
+// subscribe registers a client and returns the events after lastID it
+// missed; both happen under one lock so nothing is lost or sent twice.
+func (b *Broker) subscribe(lastID string) (*client, []Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil, false
+	}
+	c := &client{ch: make(chan Event, b.BufferSize)}
+	b.clients[c] = struct{}{}
+
+	if lastID == "" {
+		return c, nil, true
+	}
+	var backlog []Event
+	if after, err := strconv.ParseUint(lastID, 10, 64); err == nil {
+		for _, ev := range b.replay {
+			if id, _ := strconv.ParseUint(ev.ID, 10, 64); id > after {
+				backlog = append(backlog, ev)
+			}
+		}
+	} else {
+		backlog = append(backlog, b.replay...) // unknown ID: send all we have
+	}
+	return c, backlog, true
+}
+
+func (b *Broker) unsubscribe(c *client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c.ch)
+	}
+}
+
+// ServeHTTP streams events until the client disconnects or the broker
+// closes.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// A stream outlives the server's WriteTimeout by design.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	c, backlog, ok := b.subscribe(r.Header.Get("Last-Event-ID"))
+	if !ok {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer b.unsubscribe(c)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // nginx: do not buffer the stream
+	w.WriteHeader(http.StatusOK)
+	for _, ev := range backlog {
+		if ev.write(w) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(b.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-c.ch:
+			if !ok {
+				return // evicted or broker closed
+			}
+			if ev.write(w) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stream is one EventSource-like client.
+type stream struct {
+	resp *http.Response
+	r    *bufio.Reader
+}
+
+func open(t *testing.T, ctx context.Context, url, lastID string) *stream {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return &stream{resp: resp, r: bufio.NewReader(resp.Body)}
+}
+
+// next returns the lines of the next block (event or comment), without the
+// blank line that ends it.
+func (s *stream) next(t *testing.T) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v (got %q)", err, lines)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// nextEvent skips heartbeats and returns the next event's lines joined by
+// "|".
+func (s *stream) nextEvent(t *testing.T) string {
+	t.Helper()
+	for {
+		if lines := s.next(t); !strings.HasPrefix(lines[0], ":") {
+			return strings.Join(lines, "|")
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newServer(t *testing.T, b *Broker) *httptest.Server {
+	srv := httptest.NewServer(b)
+	t.Cleanup(func() {
+		b.Close()
+		srv.Close()
+	})
+	return srv
+}
+
+func TestFanOut(t *testing.T) {
+	b := NewBroker()
+	srv := newServer(t, b)
+	var clients []*stream
+	for range 3 {
+		clients = append(clients, open(t, context.Background(), srv.URL, ""))
+	}
+	waitFor(t, "3 clients", func() bool { return b.Clients() == 3 })
+
+	b.Publish(Event{Event: "item.created", Data: `{"id": 7}`})
+	b.Publish(Event{Data: "two\nlines", Retry: 2 * time.Second})
+	for i, c := range clients {
+		if got, want := c.nextEvent(t), `id: 1|event: item.created|data: {"id": 7}`; got != want {
+			t.Errorf("client %d: %q, want %q", i, got, want)
+		}
+		if got, want := c.nextEvent(t), "id: 2|retry: 2000|data: two|data: lines"; got != want {
+			t.Errorf("client %d: %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		lastID string
+		want   []string // ids replayed before the live event 6
+	}{
+		{"within the buffer", "3", []string{"4", "5"}},
+		{"up to date", "5", nil},
+		{"past the buffer", "1", []string{"3", "4", "5"}},
+		{"unknown id", "abc", []string{"3", "4", "5"}},
+		{"no id", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker()
+			b.ReplaySize = 3
+			srv := newServer(t, b)
+			for range 5 {
+				b.Publish(Event{Data: "x"})
+			}
+			s := open(t, context.Background(), srv.URL, tt.lastID)
+			waitFor(t, "the client", func() bool { return b.Clients() == 1 })
+			b.Publish(Event{Data: "x"})
+			for _, id := range append(tt.want, "6") {
+				if got := s.nextEvent(t); got != "id: "+id+"|data: x" {
+					t.Fatalf("got %q, want event %s", got, id)
+				}
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	b := NewBroker()
+	b.Heartbeat = 20 * time.Millisecond
+	srv := newServer(t, b)
+	s := open(t, context.Background(), srv.URL, "")
+	if got := s.next(t); len(got) != 1 || got[0] != ": ping" {
+		t.Errorf("first block = %q, want a heartbeat", got)
+	}
+}
+
+func TestClientGoneUnsubscribes(t *testing.T) {
+	b := NewBroker()
+	srv := newServer(t, b)
+	ctx, cancel := context.WithCancel(context.Background())
+	open(t, ctx, srv.URL, "")
+	waitFor(t, "the client", func() bool { return b.Clients() == 1 })
+	cancel()
+	waitFor(t, "the client to go", func() bool { return b.Clients() == 0 })
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker()
+	srv := newServer(t, b)
+	s := open(t, context.Background(), srv.URL, "")
+	waitFor(t, "the client", func() bool { return b.Clients() == 1 })
+
+	b.Close()
+	b.Close() // idempotent
+	if _, err := io.ReadAll(s.r); err != nil {
+		t.Errorf("stream did not end cleanly: %v", err)
+	}
+	if b.Publish(Event{Data: "late"}).ID != "" {
+		t.Error("Publish after Close assigned an ID")
+	}
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status after Close = %d, want 503", resp.StatusCode)
+	}
+}
+
+// stuckWriter blocks every write until release is closed, like a client
+// that stopped reading once the socket buffers are full.
+type stuckWriter struct {
+	header  http.Header
+	release chan struct{}
+}
+
+func (w *stuckWriter) Header() http.Header { return w.header }
+func (w *stuckWriter) WriteHeader(int)     {}
+func (w *stuckWriter) Flush()              {}
+func (w *stuckWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestSlowClientEvicted(t *testing.T) {
+	b := NewBroker()
+	b.BufferSize = 2
+	w := &stuckWriter{header: http.Header{}, release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}()
+	waitFor(t, "the client", func() bool { return b.Clients() == 1 })
+
+	// One event is stuck in Write, two fill the buffer, the next evicts.
+	for i := 0; b.Evicted() == 0; i++ {
+		if i == 10 {
+			t.Fatal("client was never evicted")
+		}
+		b.Publish(Event{Data: "x"})
+		time.Sleep(5 * time.Millisecond)
+	}
+	if b.Clients() != 0 {
+		t.Errorf("Clients = %d after eviction", b.Clients())
+	}
+	close(w.release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("evicted stream did not end")
+	}
+	// Publishing to the others carries on.
+	b.Publish(Event{Data: "y"})
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Static files and single-page apps
+
+	GET /assets/app.3f2a9c1d.js
+	  ↓ clean path, refuse dotfiles           → 404
+	  ↓ client accepts br / gzip and app.3f2a9c1d.js.br / .gz exists?
+	  ↓     → serve that, Content-Encoding: br, same Content-Type
+	  ↓ http.ServeContent                     → ETag / If-None-Match, Range, HEAD
+	  ↓ Cache-Control:
+	        hashed name (content changes → name changes)  → 1 year, immutable
+	        anything else (index.html!)                   → no-cache (revalidate via ETag)
+
+	GET /settings/profile        (no such file, no extension)
+	  ↓ SPA mode → index.html, the client-side router takes over
+
+	GET /assets/missing.js       (has an extension) → real 404, never index.html
+
+Directories serve their index.html; listing them is off unless asked for.
+Like http.FileServer, /docs redirects to /docs/ so relative links in the
+index resolve inside the directory.
+The same handler works for a directory on disk (os.DirFS) and for files
+compiled into the binary (embed.FS).
+*/
+
+// webFS holds the built-in front end, served when static_dir is not set.
+//
+//go:embed web
+var webFS embed.FS
+
+// StaticOptions configures a StaticHandler.
+type StaticOptions struct {
+	Index   string         // default "index.html"
+	SPA     bool           // unknown non-asset paths serve the root index
+	Listing bool           // list directories without an index
+	Hashed  *regexp.Regexp // names that get immutable caching; default DefaultHashedPattern
+}
+
+// DefaultHashedPattern matches names like app.3f2a9c1d.js or chunk-9e8d7c6b5a.css.
+var DefaultHashedPattern = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[A-Za-z0-9]+$`)
+
+// StaticHandler serves files from an fs.FS.
+type StaticHandler struct {
+	fsys fs.FS
+	opts StaticOptions
+
+	mu    sync.Mutex
+	etags map[string]etagEntry // by file name, at most maxETags
+}
+
+// maxETags bounds the ETag cache; when it is full the cache starts over.
+const maxETags = 4096
+
+type etagEntry struct {
+	size  int64
+	mtime int64
+	tag   string
+}
+
+// NewStaticHandler serves fsys. Use os.DirFS(dir) for a directory or
+// fs.Sub(embedded, "web") for an embed.FS.
+func NewStaticHandler(fsys fs.FS, opts StaticOptions) *StaticHandler {
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	if opts.Hashed == nil {
+		opts.Hashed = DefaultHashedPattern
+	}
+	return &StaticHandler{fsys: fsys, opts: opts, etags: make(map[string]etagEntry)}
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if hidden(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	fi, err := fs.Stat(h.fsys, name)
+	switch {
+	case err == nil && fi.IsDir():
+		if name != "." && !strings.HasSuffix(r.URL.Path, "/") {
+			localRedirect(w, r, path.Base(r.URL.Path)+"/")
+			return
+		}
+		index := path.Join(name, h.opts.Index)
+		if _, err := fs.Stat(h.fsys, index); err == nil {
+			h.serveFile(w, r, index)
+			return
+		}
+		if h.opts.Listing {
+			h.list(w, r, name)
+			return
+		}
+		http.NotFound(w, r)
+	case err == nil:
+		h.serveFile(w, r, name)
+	case errors.Is(err, fs.ErrNotExist) && h.opts.SPA && path.Ext(name) == "":
+		h.serveFile(w, r, h.opts.Index)
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+// hidden reports whether any path segment is a dotfile (.git, .env, ...).
+func hidden(name string) bool {
+	for _, seg := range strings.Split(name, "/") {
+		if strings.HasPrefix(seg, ".") && seg != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// encodings lists precompressed variants in order of preference.
+var encodings = []struct{ token, ext string }{{"br", ".br"}, {"gzip", ".gz"}}
+
+func (h *StaticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	hdr := w.Header()
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	served, encoding := name, ""
+	for _, enc := range encodings {
+		if !acceptsEncoding(r.Header.Get("Accept-Encoding"), enc.token) {
+			continue
+		}
+		if fi, err := fs.Stat(h.fsys, name+enc.ext); err == nil && !fi.IsDir() {
+			served, encoding = name+enc.ext, enc.token
+			break
+		}
+	}
+
+	f, err := h.fsys.Open(served)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	etag, err := h.etag(served, fi, content)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	hdr.Set("Content-Type", ctype)
+	hdr.Set("ETag", etag)
+	hdr.Add("Vary", "Accept-Encoding")
+	if encoding != "" {
+		hdr.Set("Content-Encoding", encoding)
+	}
+	if h.opts.Hashed.MatchString(path.Base(name)) {
+		hdr.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		hdr.Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// localRedirect redirects relative to the request path, so it stays right
+// behind http.StripPrefix.
+func localRedirect(w http.ResponseWriter, r *http.Request, target string) {
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// etag returns a strong ETag from the content hash, cached per file and
+// recomputed when its size or modification time changes. embed.FS has no
+// modification times, but its files never change.
+func (h *StaticHandler) etag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	size, mtime := fi.Size(), fi.ModTime().UnixNano()
+	h.mu.Lock()
+	e, ok := h.etags[name]
+	h.mu.Unlock()
+	if ok && e.size == size && e.mtime == mtime {
+		return e.tag, nil
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	tag := `"` + hex.EncodeToString(sum.Sum(nil)[:12]) + `"`
+	h.mu.Lock()
+	if _, ok := h.etags[name]; !ok && len(h.etags) >= maxETags {
+		clear(h.etags)
+	}
+	h.etags[name] = etagEntry{size: size, mtime: mtime, tag: tag}
+	h.mu.Unlock()
+	return tag, nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows token
+// (q=0 means "not acceptable").
+func acceptsEncoding(header, token string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), token) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (h *StaticHandler) list(w http.ResponseWriter, r *http.Request, dir string) {
+	entries, err := fs.ReadDir(h.fsys, dir)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "<!doctype html>\n<title>%s</title>\n<pre>\n", html.EscapeString(r.URL.Path))
+	for _, e := range entries {
+		n := e.Name()
+		if strings.HasPrefix(n, ".") {
+			continue
+		}
+		if e.IsDir() {
+			n += "/"
+		}
+		href := (&url.URL{Path: n}).String()
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(href), html.EscapeString(n))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+// staticFS picks the files to serve: dir on disk when set, else the
+// embedded web/ directory.
+func staticFS(dir string) (fs.FS, error) {
+	if dir != "" {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(webFS, "web")
+}
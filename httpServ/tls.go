@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*
+TLS with hot reload
+
+Instead of tls.Config.Certificates (read once at startup) the server uses
+tls.Config.GetCertificate, which is asked for a certificate on EVERY
+handshake:
+
+	Client ──(ClientHello, SNI = "api.example.com")──> GetCertificate
+	                                                     ↓
+	                                        current cert set (atomic pointer)
+	                                                     ↓
+	                                 exact name → wildcard → first cert
+
+Reloading = building a new cert set and swapping the pointer. Connections
+that are already established keep their session; new handshakes get the new
+certificate. Nothing is dropped.
+
+Reload happens when a cert/key file's modification time changes (polled) or
+on SIGHUP. A bad file is logged and the old certificates stay in use.
+
+Dev mode (-tls-dev) writes a local CA and a localhost leaf certificate into
+tls_dev_dir. Trust ca.pem in your browser/OS once and the leaf is accepted;
+later runs keep that CA and only re-issue the leaf when it nears expiry.
+*/
+
+// CertPair is one certificate chain and its private key on disk.
+type CertPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+type certSet struct {
+	byName map[string]*tls.Certificate // lower-case DNS name or "*.suffix"
+	first  *tls.Certificate
+	mtimes map[string]time.Time
+}
+
+// CertReloader serves certificates through GetCertificate and reloads them
+// when the files change or SIGHUP arrives.
+type CertReloader struct {
+	pairs    []CertPair
+	interval time.Duration
+	logger   *log.Logger
+	current  atomic.Pointer[certSet]
+}
+
+// NewCertReloader loads every pair; the first one is the default for
+// clients that send no (or an unknown) SNI name.
+func NewCertReloader(pairs []CertPair, interval time.Duration) (*CertReloader, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("tls: no certificates configured")
+	}
+	cr := &CertReloader{pairs: pairs, interval: interval, logger: log.Default()}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload reads all certificate files and swaps them in atomically.
+func (cr *CertReloader) Reload() error {
+	set := &certSet{byName: make(map[string]*tls.Certificate), mtimes: make(map[string]time.Time)}
+	for _, p := range cr.pairs {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: load %s: %w", p.CertFile, err)
+		}
+		if set.first == nil {
+			set.first = &cert
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, n := range names {
+			n = strings.ToLower(n)
+			if _, dup := set.byName[n]; !dup {
+				set.byName[n] = &cert
+			}
+		}
+		for _, ip := range cert.Leaf.IPAddresses {
+			set.byName[ip.String()] = &cert
+		}
+		for _, f := range []string{p.CertFile, p.KeyFile} {
+			if fi, err := os.Stat(f); err == nil {
+				set.mtimes[f] = fi.ModTime()
+			}
+		}
+	}
+	cr.current.Store(set)
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate with SNI selection.
+func (cr *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := cr.current.Load()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return set.first, nil
+	}
+	if c, ok := set.byName[name]; ok {
+		return c, nil
+	}
+	if _, rest, ok := strings.Cut(name, "."); ok {
+		if c, ok := set.byName["*."+rest]; ok {
+			return c, nil
+		}
+	}
+	return set.first, nil
+}
+
+func (cr *CertReloader) changed() bool {
+	set := cr.current.Load()
+	for f, old := range set.mtimes {
+		fi, err := os.Stat(f)
+		if err != nil || !fi.ModTime().Equal(old) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run polls the files and listens for SIGHUP until ctx is cancelled. It is
+// meant to be registered with Lifecycle.AddComponent.
+func (cr *CertReloader) Run(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	tick := time.NewTicker(cr.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			cr.reloadAndLog("SIGHUP")
+		case <-tick.C:
+			if cr.changed() {
+				cr.reloadAndLog("file change")
+			}
+		}
+	}
+}
+
+func (cr *CertReloader) reloadAndLog(reason string) {
+	if err := cr.Reload(); err != nil {
+		cr.logger.Printf("tls: reload after %s failed, keeping old certificates: %v", reason, err)
+		return
+	}
+	cr.logger.Printf("tls: certificates reloaded after %s", reason)
+}
+
+// NewTLSConfig returns a server tls.Config using cr for certificates.
+// minVersion is "1.2" or "1.3"; cipherSuites are Go names such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" and only affect TLS 1.2 (TLS 1.3
+// suites are not configurable).
+func NewTLSConfig(cr *CertReloader, minVersion string, cipherSuites []string) (*tls.Config, error) {
+	cfg := &tls.Config{GetCertificate: cr.GetCertificate}
+	switch minVersion {
+	case "", "1.2":
+		cfg.MinVersion = tls.VersionTLS12
+	case "1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("tls: unsupported minimum version %q (want 1.2 or 1.3)", minVersion)
+	}
+	if len(cipherSuites) > 0 {
+		known := make(map[string]uint16)
+		for _, cs := range tls.CipherSuites() {
+			known[cs.Name] = cs.ID
+		}
+		for _, name := range cipherSuites {
+			id, ok := known[name]
+			if !ok {
+				return nil, fmt.Errorf("tls: unknown or insecure cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	return cfg, nil
+}
+
+// setupTLS configures srv for HTTPS from cfg and registers the certificate
+// reloader with lc.
+func setupTLS(cfg *ServerConfig, srv *http.Server, lc *Lifecycle) error {
+	var pairs []CertPair
+	for i := range cfg.TLSCertFiles {
+		pairs = append(pairs, CertPair{CertFile: cfg.TLSCertFiles[i], KeyFile: cfg.TLSKeyFiles[i]})
+	}
+	if cfg.TLSDev {
+		pair, err := EnsureDevCerts(cfg.TLSDevDir)
+		if err != nil {
+			return fmt.Errorf("tls: dev certificates: %w", err)
+		}
+		log.Printf("tls: development certificates in %s (trust ca.pem to avoid warnings)", cfg.TLSDevDir)
+		pairs = append(pairs, pair)
+	}
+	cr, err := NewCertReloader(pairs, cfg.TLSReloadInterval)
+	if err != nil {
+		return err
+	}
+	tlsCfg, err := NewTLSConfig(cr, cfg.TLSMinVersion, cfg.TLSCipherSuites)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsCfg
+	lc.AddComponent("cert-reloader", cr.Run)
+	return nil
+}
+
+// devLeafRenewBefore is how long before expiry EnsureDevCerts re-issues the
+// localhost certificate; tls.LoadX509KeyPair happily loads expired ones.
+const devLeafRenewBefore = 30 * 24 * time.Hour
+
+// EnsureDevCerts keeps dir/ca.pem and a localhost certificate signed by it,
+// and returns the leaf pair. An existing CA is reused so the one already
+// trusted keeps working; the leaf is re-issued when it is missing, close to
+// expiry or not signed by that CA.
+func EnsureDevCerts(dir string) (CertPair, error) {
+	pair := CertPair{
+		CertFile: filepath.Join(dir, "localhost.pem"),
+		KeyFile:  filepath.Join(dir, "localhost-key.pem"),
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return pair, err
+	}
+	caCert, caKey, err := ensureDevCA(dir)
+	if err != nil {
+		return pair, err
+	}
+	now := time.Now()
+	if leaf, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile); err == nil &&
+		now.Add(devLeafRenewBefore).Before(leaf.Leaf.NotAfter) &&
+		leaf.Leaf.CheckSignatureFrom(caCert) == nil {
+		return pair, nil
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return pair, err
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		return pair, err
+	}
+	if err := writePEM(pair.CertFile, &pem.Block{Type: "CERTIFICATE", Bytes: leafDER}, 0o644); err != nil {
+		return pair, err
+	}
+	return pair, writePEM(pair.KeyFile, ecKeyBlock(leafKey), 0o600)
+}
+
+// ensureDevCA loads dir/ca.pem and dir/ca-key.pem, or creates them when they
+// are missing, unreadable or expired.
+func ensureDevCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	if ca, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if key, ok := ca.PrivateKey.(*ecdsa.PrivateKey); ok && ca.Leaf.IsCA && time.Now().Before(ca.Leaf.NotAfter) {
+			return ca.Leaf, key, nil
+		}
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "httpServ development CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: caDER}, 0o644); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(keyFile, ecKeyBlock(caKey), 0o600); err != nil {
+		return nil, nil, err
+	}
+	log.Printf("tls: created development CA %s; trust it once to accept the localhost certificate", certFile)
+	return caCert, caKey, nil
+}
+
+func writePEM(path string, block *pem.Block, mode os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(block), mode)
+}
+
+func ecKeyBlock(k *ecdsa.PrivateKey) *pem.Block {
+	der, _ := x509.MarshalECPrivateKey(k)
+	return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+}
+
+func randomSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return n
+}
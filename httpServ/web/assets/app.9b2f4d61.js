@@ -0,0 +1,3 @@
+// Tiny client-side router: every path is rendered here, the server falls
+// back to index.html for paths it does not know.
+document.getElementById("app").textContent = "You are at " + location.pathname;
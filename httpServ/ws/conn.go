@@ -0,0 +1,404 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types and control opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// Close codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+// CloseError is returned by ReadMessage when the peer closed the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Reason)
+}
+
+// ErrCloseSent is returned when writing after a close frame was sent.
+var ErrCloseSent = errors.New("websocket: close frame already sent")
+
+// closeWait is how long to wait for the peer's close frame after sending ours.
+const closeWait = 5 * time.Second
+
+// compressThreshold: smaller messages are sent uncompressed; deflate
+// overhead would eat the savings.
+const compressThreshold = 128
+
+// Conn is a server-side WebSocket connection. One goroutine may read and
+// another write at the same time.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	compress    bool
+
+	readLimit   int64
+	pongHandler func(appData string)
+
+	writeMu   sync.Mutex
+	bw        *bufio.Writer
+	fw        *flate.Writer
+	closeSent bool
+}
+
+// DefaultReadLimit is the message size cap used when none is given.
+const DefaultReadLimit = 1 << 20
+
+func newConn(c net.Conn, br *bufio.Reader, subprotocol string, compress bool, readLimit int64) *Conn {
+	if readLimit <= 0 {
+		readLimit = DefaultReadLimit
+	}
+	return &Conn{
+		conn:        c,
+		br:          br,
+		bw:          bufio.NewWriterSize(c, 4096),
+		subprotocol: subprotocol,
+		compress:    compress,
+		readLimit:   readLimit,
+	}
+}
+
+// Subprotocol returns the negotiated Sec-WebSocket-Protocol, if any.
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+// Compressed reports whether permessage-deflate was negotiated.
+func (c *Conn) Compressed() bool { return c.compress }
+
+// RemoteAddr returns the peer address.
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetReadLimit caps the size of a message (after decompression); n <= 0
+// means DefaultReadLimit. There is no unlimited setting: frame lengths come
+// from the peer and are allocated up front.
+func (c *Conn) SetReadLimit(n int64) {
+	if n <= 0 {
+		n = DefaultReadLimit
+	}
+	c.readLimit = n
+}
+
+// SetPongHandler is called for every pong; use it to extend the read deadline.
+func (c *Conn) SetPongHandler(fn func(appData string)) { c.pongHandler = fn }
+
+// SetReadDeadline sets the deadline for the next ReadMessage.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the deadline for subsequent writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// Close closes the underlying connection without a close handshake.
+func (c *Conn) Close() error { return c.conn.Close() }
+
+// protocolError closes the connection with code and returns the error.
+func (c *Conn) protocolError(code int, msg string) error {
+	c.WriteClose(code, msg)
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: msg}
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: hdr[0]&0x80 != 0, rsv1: hdr[0]&0x40 != 0, opcode: hdr[0] & 0x0f}
+	if hdr[0]&0x30 != 0 || (f.rsv1 && !c.compress) {
+		return f, c.protocolError(CloseProtocolError, "unexpected RSV bits")
+	}
+	if hdr[1]&0x80 == 0 {
+		return f, c.protocolError(CloseProtocolError, "client frames must be masked")
+	}
+
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 {
+			return f, c.protocolError(CloseProtocolError, "invalid frame length")
+		}
+	}
+	if f.opcode >= CloseMessage {
+		if !f.fin || n > 125 {
+			return f, c.protocolError(CloseProtocolError, "invalid control frame")
+		}
+		if f.rsv1 {
+			return f, c.protocolError(CloseProtocolError, "compressed control frame")
+		}
+	}
+	if n > uint64(c.readLimit) {
+		return f, c.protocolError(CloseMessageTooBig, "message too big")
+	}
+
+	var key [4]byte
+	if _, err := io.ReadFull(c.br, key[:]); err != nil {
+		return f, err
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= key[i%4]
+	}
+	return f, nil
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs reported to the pong handler along the way. When the peer
+// closes, the close is echoed and a *CloseError returned.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	var msgType byte
+	var compressed bool
+	var buf []byte
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case PingMessage:
+			if err := c.WriteControl(PongMessage, f.payload); err != nil && err != ErrCloseSent {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.pongHandler != nil {
+				c.pongHandler(string(f.payload))
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(f.payload)
+		case TextMessage, BinaryMessage:
+			if msgType != 0 {
+				return 0, nil, c.protocolError(CloseProtocolError, "new message before the last one finished")
+			}
+			msgType, compressed, buf = f.opcode, f.rsv1, f.payload
+		case continuationFrame:
+			if msgType == 0 {
+				return 0, nil, c.protocolError(CloseProtocolError, "continuation without a message")
+			}
+			if f.rsv1 {
+				return 0, nil, c.protocolError(CloseProtocolError, "RSV1 on a continuation frame")
+			}
+			buf = append(buf, f.payload...)
+		default:
+			return 0, nil, c.protocolError(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+		}
+
+		if int64(len(buf)) > c.readLimit {
+			return 0, nil, c.protocolError(CloseMessageTooBig, "message too big")
+		}
+		if !f.fin {
+			continue
+		}
+		if compressed {
+			if buf, err = c.inflate(buf); err != nil {
+				return 0, nil, err
+			}
+		}
+		if msgType == TextMessage && !utf8.Valid(buf) {
+			return 0, nil, c.protocolError(CloseInvalidPayload, "text message is not valid UTF-8")
+		}
+		return int(msgType), buf, nil
+	}
+}
+
+// inflate decompresses one permessage-deflate message.
+func (c *Conn) inflate(p []byte) ([]byte, error) {
+	// The sender strips the final empty stored block; put it back and add
+	// a final block so the reader ends cleanly.
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(p), strings.NewReader("\x00\x00\xff\xff\x01\x00\x00\xff\xff")))
+	defer r.Close()
+	limit := c.readLimit
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, c.protocolError(CloseInvalidPayload, "bad compressed data")
+	}
+	if int64(len(out)) > limit {
+		return nil, c.protocolError(CloseMessageTooBig, "message too big")
+	}
+	return out, nil
+}
+
+func (c *Conn) handleClose(p []byte) error {
+	code, reason := CloseNoStatus, ""
+	switch {
+	case len(p) == 1:
+		return c.protocolError(CloseProtocolError, "invalid close payload")
+	case len(p) >= 2:
+		code = int(binary.BigEndian.Uint16(p))
+		reason = string(p[2:])
+		if !validCloseCode(code) || !utf8.ValidString(reason) {
+			return c.protocolError(CloseProtocolError, "invalid close code")
+		}
+	}
+	echo := code
+	if echo == CloseNoStatus {
+		echo = CloseNormal
+	}
+	c.WriteClose(echo, "")
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1014:
+		return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+	}
+	return false
+}
+
+// WriteMessage sends data as one text or binary message.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: WriteMessage with opcode %d", messageType)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	rsv1 := false
+	if c.compress && len(data) >= compressThreshold {
+		compressed, err := c.deflate(data)
+		if err != nil {
+			return err
+		}
+		data, rsv1 = compressed, true
+	}
+	return c.writeFrame(byte(messageType), rsv1, data)
+}
+
+func (c *Conn) deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if c.fw == nil {
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		c.fw = fw
+	} else {
+		c.fw.Reset(&buf)
+	}
+	if _, err := c.fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := c.fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}), nil
+}
+
+// WriteControl sends a ping, pong or close frame (payload up to 125 bytes).
+func (c *Conn) WriteControl(opcode int, payload []byte) error {
+	if opcode < CloseMessage || len(payload) > 125 {
+		return fmt.Errorf("websocket: invalid control frame")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+	return c.writeFrame(byte(opcode), false, payload)
+}
+
+// WriteClose starts the closing handshake. The peer has closeWait to
+// answer before reads fail.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	p := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	copy(p[2:], reason)
+	err := c.WriteControl(CloseMessage, p)
+	c.conn.SetReadDeadline(time.Now().Add(closeWait))
+	return err
+}
+
+// writeFrame writes one unmasked frame; writeMu must be held.
+func (c *Conn) writeFrame(opcode byte, rsv1 bool, payload []byte) error {
+	b0 := 0x80 | opcode
+	if rsv1 {
+		b0 |= 0x40
+	}
+	hdr := []byte{b0, 0}
+	switch n := len(payload); {
+	case n <= 125:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if _, err := c.bw.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
@@ -0,0 +1,274 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// clientFrame encodes a masked frame as a browser would send it.
+func clientFrame(fin, rsv1 bool, opcode byte, payload []byte) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	out := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		out = append(out, 0x80|byte(n))
+	case n <= 0xffff:
+		out = binary.BigEndian.AppendUint16(append(out, 0x80|126), uint16(n))
+	default:
+		out = binary.BigEndian.AppendUint64(append(out, 0x80|127), uint64(n))
+	}
+	key := []byte{0x12, 0x34, 0x56, 0x78}
+	out = append(out, key...)
+	for i, b := range payload {
+		out = append(out, b^key[i%4])
+	}
+	return out
+}
+
+func text(fin bool, s string) []byte { return clientFrame(fin, false, TextMessage, []byte(s)) }
+func cont(fin bool, s string) []byte { return clientFrame(fin, false, continuationFrame, []byte(s)) }
+func ping(s string) []byte           { return clientFrame(true, false, PingMessage, []byte(s)) }
+
+func closeFrame(code int, reason string) []byte {
+	p := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return clientFrame(true, false, CloseMessage, append(p, reason...))
+}
+
+func deflateBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	fw.Write([]byte(s))
+	if err := fw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+}
+
+// serverFrames decodes the unmasked frames the server wrote as
+// "pong <payload>" or "close <code>".
+func serverFrames(t *testing.T, raw []byte) []string {
+	t.Helper()
+	var out []string
+	for len(raw) >= 2 {
+		op, n := raw[0]&0x0f, int(raw[1]&0x7f)
+		if n > 125 || len(raw) < 2+n {
+			t.Fatalf("unexpected server frame % x", raw)
+		}
+		p := raw[2 : 2+n]
+		switch op {
+		case CloseMessage:
+			out = append(out, fmt.Sprintf("close %d", binary.BigEndian.Uint16(p)))
+		case PongMessage:
+			out = append(out, "pong "+string(p))
+		default:
+			out = append(out, fmt.Sprintf("opcode %d", op))
+		}
+		raw = raw[2+n:]
+	}
+	return out
+}
+
+type readResult struct {
+	msgs  []string // "text hello" or "binary ..."
+	code  int      // close code of the final error
+	pongs []string
+	out   []string // frames written by the server
+}
+
+// readAll feeds the client frames to a server Conn and reads messages until
+// ReadMessage fails.
+func readAll(t *testing.T, compress bool, limit int64, frames ...[]byte) readResult {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	c := newConn(server, bufio.NewReader(server), "", compress, limit)
+	var res readResult
+	c.SetPongHandler(func(s string) { res.pongs = append(res.pongs, s) })
+
+	go func() {
+		for _, f := range frames {
+			if _, err := client.Write(f); err != nil {
+				return
+			}
+		}
+	}()
+	written := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(client)
+		written <- b
+	}()
+
+	for {
+		typ, data, err := c.ReadMessage()
+		if err != nil {
+			var ce *CloseError
+			if !errors.As(err, &ce) {
+				t.Fatalf("ReadMessage: %v, want a *CloseError", err)
+			}
+			res.code = ce.Code
+			break
+		}
+		kind := map[int]string{TextMessage: "text", BinaryMessage: "binary"}[typ]
+		res.msgs = append(res.msgs, kind+" "+string(data))
+	}
+	server.Close()
+	res.out = serverFrames(t, <-written)
+	return res
+}
+
+func TestReadMessage(t *testing.T) {
+	bye := closeFrame(CloseNormal, "")
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name   string
+		frames [][]byte
+		limit  int64
+		want   readResult
+	}{
+		{"single text", [][]byte{text(true, "hello"), bye},
+			0, readResult{msgs: []string{"text hello"}, code: 1000, out: []string{"close 1000"}}},
+		{"binary with 16-bit length", [][]byte{clientFrame(true, false, BinaryMessage, []byte(long)), bye},
+			0, readResult{msgs: []string{"binary " + long}, code: 1000, out: []string{"close 1000"}}},
+		{"fragmented text", [][]byte{text(false, "hel"), cont(false, "l"), cont(true, "o"), bye},
+			0, readResult{msgs: []string{"text hello"}, code: 1000, out: []string{"close 1000"}}},
+		{"utf-8 split across fragments", [][]byte{text(false, "caf\xc3"), cont(true, "\xa9"), bye},
+			0, readResult{msgs: []string{"text café"}, code: 1000, out: []string{"close 1000"}}},
+
+		// Control frames may be interleaved with the fragments of a message.
+		{"ping inside fragmented message", [][]byte{text(false, "hel"), ping("p1"), cont(true, "lo"), bye},
+			0, readResult{msgs: []string{"text hello"}, code: 1000, out: []string{"pong p1", "close 1000"}}},
+		{"pong inside fragmented message", [][]byte{text(false, "hel"), clientFrame(true, false, PongMessage, []byte("p2")), cont(true, "lo"), bye},
+			0, readResult{msgs: []string{"text hello"}, pongs: []string{"p2"}, code: 1000, out: []string{"close 1000"}}},
+		{"close inside fragmented message", [][]byte{text(false, "hel"), closeFrame(CloseGoingAway, "bye")},
+			0, readResult{code: 1001, out: []string{"close 1001"}}},
+
+		{"new message before the last one finished", [][]byte{text(false, "a"), text(true, "b")},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"continuation without a message", [][]byte{cont(true, "a")},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"fragmented ping", [][]byte{clientFrame(false, false, PingMessage, nil)},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"ping longer than 125 bytes", [][]byte{ping(long)},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"unmasked frame", [][]byte{{0x81, 0x01, 'a'}},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"RSV2 set", [][]byte{{0xa1, 0x80, 0, 0, 0, 0}},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"RSV1 without compression", [][]byte{clientFrame(true, true, TextMessage, []byte("a"))},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"unknown opcode", [][]byte{clientFrame(true, false, 3, nil)},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"64-bit length with the top bit set", [][]byte{{0x82, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"invalid utf-8", [][]byte{text(true, "\xff")},
+			0, readResult{code: 1007, out: []string{"close 1007"}}},
+		{"frame over the read limit", [][]byte{text(true, "hello")},
+			4, readResult{code: 1009, out: []string{"close 1009"}}},
+		{"fragments over the read limit", [][]byte{text(false, "hel"), cont(true, "lo")},
+			4, readResult{code: 1009, out: []string{"close 1009"}}},
+		// A negative limit falls back to the default instead of trusting
+		// the peer's 2^62-byte length.
+		{"huge frame with a negative limit", [][]byte{{0x82, 0xff, 0x40, 0, 0, 0, 0, 0, 0, 0}},
+			-1, readResult{code: 1009, out: []string{"close 1009"}}},
+		{"frame over the default limit", [][]byte{{0x82, 0xff, 0, 0, 0, 0, 0, 0x10, 0, 1}},
+			0, readResult{code: 1009, out: []string{"close 1009"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAll(t, false, tt.limit, tt.frames...)
+			if !slices.Equal(got.msgs, tt.want.msgs) || got.code != tt.want.code ||
+				!slices.Equal(got.pongs, tt.want.pongs) || !slices.Equal(got.out, tt.want.out) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMessageCloseCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		code    int    // code ReadMessage reports
+		echo    string // close frame sent back
+	}{
+		{"no status", nil, CloseNoStatus, "close 1000"},
+		{"one byte", []byte{0x03}, CloseProtocolError, "close 1002"},
+		{"1000", closePayload(1000, ""), 1000, "close 1000"},
+		{"1001 with reason", closePayload(1001, "restarting"), 1001, "close 1001"},
+		{"1003", closePayload(1003, ""), 1003, "close 1003"},
+		{"1004 is reserved", closePayload(1004, ""), CloseProtocolError, "close 1002"},
+		{"1005 must not be sent", closePayload(1005, ""), CloseProtocolError, "close 1002"},
+		{"1006 must not be sent", closePayload(1006, ""), CloseProtocolError, "close 1002"},
+		{"1014", closePayload(1014, ""), 1014, "close 1014"},
+		{"1015 must not be sent", closePayload(1015, ""), CloseProtocolError, "close 1002"},
+		{"999", closePayload(999, ""), CloseProtocolError, "close 1002"},
+		{"2999", closePayload(2999, ""), CloseProtocolError, "close 1002"},
+		{"3000 registered", closePayload(3000, ""), 3000, "close 3000"},
+		{"4999 private", closePayload(4999, ""), 4999, "close 4999"},
+		{"5000", closePayload(5000, ""), CloseProtocolError, "close 1002"},
+		{"invalid utf-8 reason", closePayload(1000, "\xff"), CloseProtocolError, "close 1002"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAll(t, false, 0, clientFrame(true, false, CloseMessage, tt.payload))
+			if got.code != tt.code || !slices.Equal(got.out, []string{tt.echo}) {
+				t.Errorf("code %d, sent %q; want code %d, sent %q", got.code, got.out, tt.code, tt.echo)
+			}
+		})
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func TestReadMessageCompressed(t *testing.T) {
+	bye := closeFrame(CloseNormal, "")
+	msg := strings.Repeat("compress me ", 20)
+	z := deflateBytes(t, msg)
+	tests := []struct {
+		name   string
+		frames [][]byte
+		limit  int64
+		want   readResult
+	}{
+		{"compressed text", [][]byte{clientFrame(true, true, TextMessage, z), bye},
+			0, readResult{msgs: []string{"text " + msg}, code: 1000, out: []string{"close 1000"}}},
+		{"compressed and fragmented", [][]byte{clientFrame(false, true, TextMessage, z[:5]), ping("p"), cont(true, string(z[5:])), bye},
+			0, readResult{msgs: []string{"text " + msg}, code: 1000, out: []string{"pong p", "close 1000"}}},
+		{"uncompressed on a compressing connection", [][]byte{text(true, "plain"), bye},
+			0, readResult{msgs: []string{"text plain"}, code: 1000, out: []string{"close 1000"}}},
+		{"RSV1 on a continuation", [][]byte{clientFrame(false, true, TextMessage, z[:5]), clientFrame(true, true, continuationFrame, z[5:])},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"compressed control frame", [][]byte{clientFrame(true, true, PingMessage, nil)},
+			0, readResult{code: 1002, out: []string{"close 1002"}}},
+		{"bad compressed data", [][]byte{clientFrame(true, true, BinaryMessage, []byte{0xff, 0xff, 0xff})},
+			0, readResult{code: 1007, out: []string{"close 1007"}}},
+		{"inflated size over the read limit", [][]byte{clientFrame(true, true, TextMessage, z)},
+			int64(len(msg) - 1), readResult{code: 1009, out: []string{"close 1009"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readAll(t, true, tt.limit, tt.frames...)
+			if !slices.Equal(got.msgs, tt.want.msgs) || got.code != tt.want.code || !slices.Equal(got.out, tt.want.out) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
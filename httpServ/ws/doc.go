@@ -0,0 +1,29 @@
+// Package ws implements WebSocket (RFC 6455) on top of net/http.
+//
+// The handshake is an ordinary HTTP request that the handler takes over:
+//
+//	GET /ws HTTP/1.1
+//	Connection: Upgrade
+//	Upgrade: websocket
+//	Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==
+//	Sec-WebSocket-Version: 13
+//	                                  ↓ Upgrade(): check, hijack the TCP connection
+//	HTTP/1.1 101 Switching Protocols
+//	Sec-WebSocket-Accept: base64(sha1(key + fixed GUID))
+//
+// From then on both sides exchange frames:
+//
+//	FIN RSV1 opcode | MASK len | [ext len] | [mask key] | payload
+//	opcode: 0 continuation, 1 text, 2 binary, 8 close, 9 ping, 10 pong
+//
+// Clients must mask every frame, the server never masks. Control frames
+// (close, ping, pong) may arrive between the fragments of a message and are
+// answered by ReadMessage itself. permessage-deflate (RSV1) is negotiated
+// when UpgradeOptions.Compression is set; every message is compressed on
+// its own (no context takeover), trading some ratio for flat memory use.
+//
+// A Hub manages many connections: rooms, broadcast, a bounded write queue
+// per connection (a client that cannot keep up is disconnected instead of
+// making the server buffer without limit), pings to detect dead peers, and
+// Shutdown, which sends every client a 1001 "going away" close frame.
+package ws
@@ -0,0 +1,324 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Client.Send when the client is too slow; the
+// client is being disconnected.
+var ErrQueueFull = errors.New("websocket: send queue full")
+
+// Hub tracks connections, groups them into rooms and broadcasts to them.
+type Hub struct {
+	Upgrade      UpgradeOptions
+	SendQueue    int           // messages queued per client, default 64
+	PingInterval time.Duration // default 30s
+	PongWait     time.Duration // a client silent this long is dropped, default 60s
+	WriteWait    time.Duration // per write, default 10s
+	Logger       *log.Logger
+
+	// OnConnect runs after the handshake, before messages are read; join
+	// rooms here. Returning an error closes the connection with 1008.
+	OnConnect func(c *Client, r *http.Request) error
+	// OnMessage runs for every message, on the client's read goroutine.
+	OnMessage func(c *Client, messageType int, data []byte)
+	// OnDisconnect runs once the client is gone.
+	OnDisconnect func(c *Client)
+
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+	rooms   map[string]map[*Client]struct{}
+	closing bool
+	empty   chan struct{} // closed when the last client leaves during Shutdown
+}
+
+// NewHub returns a Hub with default limits.
+func NewHub() *Hub {
+	return &Hub{
+		SendQueue:    64,
+		PingInterval: 30 * time.Second,
+		PongWait:     60 * time.Second,
+		WriteWait:    10 * time.Second,
+		Logger:       log.Default(),
+		clients:      make(map[*Client]struct{}),
+		rooms:        make(map[string]map[*Client]struct{}),
+	}
+}
+
+// Client is one connection managed by a Hub.
+type Client struct {
+	Conn *Conn
+	hub  *Hub
+
+	send      chan outbound
+	rooms     map[string]struct{} // guarded by hub.mu
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type outbound struct {
+	messageType int
+	data        []byte
+}
+
+// ServeHTTP upgrades the request and serves the connection until it ends.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	closing := h.closing
+	h.mu.Unlock()
+	if closing {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := Upgrade(w, r, &h.Upgrade)
+	if err != nil {
+		return
+	}
+	c := &Client{
+		Conn:  conn,
+		hub:   h,
+		send:  make(chan outbound, h.SendQueue),
+		rooms: make(map[string]struct{}),
+		done:  make(chan struct{}),
+	}
+	if !h.register(c) {
+		conn.WriteClose(CloseGoingAway, "server shutting down")
+		conn.Close()
+		return
+	}
+	go c.writeLoop()
+
+	if h.OnConnect != nil {
+		if err := h.OnConnect(c, r); err != nil {
+			c.CloseWith(ClosePolicyViolation, err.Error())
+		}
+	}
+	c.readLoop()
+}
+
+func (h *Hub) register(c *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	return true
+}
+
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	for room := range c.rooms {
+		h.leaveLocked(c, room)
+	}
+	if h.closing && len(h.clients) == 0 && h.empty != nil {
+		close(h.empty)
+		h.empty = nil
+	}
+	h.mu.Unlock()
+	if h.OnDisconnect != nil {
+		h.OnDisconnect(c)
+	}
+}
+
+// Join adds c to room.
+func (c *Client) Join(room string) {
+	h := c.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]struct{})
+	}
+	h.rooms[room][c] = struct{}{}
+	c.rooms[room] = struct{}{}
+}
+
+// Leave removes c from room.
+func (c *Client) Leave(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.hub.leaveLocked(c, room)
+}
+
+func (h *Hub) leaveLocked(c *Client, room string) {
+	delete(c.rooms, room)
+	if members := h.rooms[room]; members != nil {
+		delete(members, c)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// Rooms returns the rooms c is in.
+func (c *Client) Rooms() []string {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for r := range c.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+// Send queues a message without blocking. A full queue disconnects the
+// client with 1008 and returns ErrQueueFull.
+func (c *Client) Send(messageType int, data []byte) error {
+	select {
+	case <-c.done:
+		return ErrCloseSent
+	default:
+	}
+	select {
+	case c.send <- outbound{messageType: messageType, data: data}:
+		return nil
+	default:
+		c.hub.Logger.Printf("ws: %s: send queue full, disconnecting", c.Conn.RemoteAddr())
+		c.CloseWith(ClosePolicyViolation, "too slow")
+		return ErrQueueFull
+	}
+}
+
+// CloseWith sends a close frame with code and reason, then ends the
+// connection once the peer answers (or after a timeout).
+func (c *Client) CloseWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		// Written directly: the queue may be full, which is often why we close.
+		go func() {
+			c.Conn.SetWriteDeadline(time.Now().Add(c.hub.WriteWait))
+			if c.Conn.WriteClose(code, reason) != nil {
+				c.Conn.Close()
+			}
+		}()
+	})
+}
+
+// Broadcast sends a message to every member of room (every client when room
+// is ""). It returns how many clients it was queued for.
+func (h *Hub) Broadcast(room string, messageType int, data []byte) int {
+	h.mu.Lock()
+	var targets []*Client
+	if room == "" {
+		for c := range h.clients {
+			targets = append(targets, c)
+		}
+	} else {
+		for c := range h.rooms[room] {
+			targets = append(targets, c)
+		}
+	}
+	h.mu.Unlock()
+
+	n := 0
+	for _, c := range targets {
+		if c.Send(messageType, data) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of connected clients.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Shutdown refuses new connections, sends every client 1001 "going away"
+// and waits for them to close, forcing them when ctx expires. It has the
+// signature of a Lifecycle shutdown hook.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	var all []*Client
+	for c := range h.clients {
+		all = append(all, c)
+	}
+	empty := make(chan struct{})
+	if len(all) == 0 {
+		close(empty)
+	} else {
+		h.empty = empty
+	}
+	h.mu.Unlock()
+
+	for _, c := range all {
+		c.CloseWith(CloseGoingAway, "server shutting down")
+	}
+	select {
+	case <-empty:
+		return nil
+	case <-ctx.Done():
+		for _, c := range all {
+			c.Conn.Close()
+		}
+		return ctx.Err()
+	}
+}
+
+func (c *Client) readLoop() {
+	h := c.hub
+	defer func() {
+		c.closeOnce.Do(func() { close(c.done) })
+		c.Conn.Close()
+		h.unregister(c)
+	}()
+
+	c.Conn.SetReadDeadline(time.Now().Add(h.PongWait))
+	c.Conn.SetPongHandler(func(string) {
+		select {
+		case <-c.done: // closing: keep the close-wait deadline
+		default:
+			c.Conn.SetReadDeadline(time.Now().Add(h.PongWait))
+		}
+	})
+	for {
+		typ, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if h.OnMessage != nil {
+			h.OnMessage(c, typ, data)
+		}
+	}
+}
+
+func (c *Client) writeLoop() {
+	h := c.hub
+	ping := time.NewTicker(h.PingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case m := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(h.WriteWait))
+			if err := c.Conn.WriteMessage(m.messageType, m.data); err != nil {
+				if err != ErrCloseSent {
+					c.Conn.Close()
+				}
+				return
+			}
+		case <-ping.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(h.WriteWait))
+			if err := c.Conn.WriteControl(PingMessage, nil); err != nil {
+				if err != ErrCloseSent {
+					c.Conn.Close()
+				}
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+/*
+HDR histogram
+
+Latencies span from microseconds to seconds, and p99.9 matters as much as
+the median. Fixed-width buckets either waste memory or lose the tail; a
+sorted slice of every sample grows without limit. An HDR (High Dynamic
+Range) histogram keeps a constant RELATIVE precision instead:
+
+	value (µs)        bucket width
+	0 ..     2047      1     exact
+	2048 ..  4095      2
+	4096 ..  8191      4
+	...              doubles with every power of two
+
+Each power of two is split into 1024 sub-buckets, so any recorded value is
+off by at most 1/1024 (~0.1%, three significant digits) whatever its size,
+and one hour fits in about 23k counters.
+*/
+
+const (
+	subBits  = 11
+	subCount = 1 << subBits // linear range, and sub-buckets per doubling × 2
+	subHalf  = subCount / 2
+)
+
+// Histogram counts int64 values (here: microseconds) with ~0.1% precision.
+type Histogram struct {
+	counts   []uint64
+	total    uint64
+	min, max int64
+	sum      float64
+	sumSq    float64
+}
+
+// NewHistogram tracks values from 0 up to highest; larger ones are clamped.
+func NewHistogram(highest int64) *Histogram {
+	return &Histogram{counts: make([]uint64, bucketIndex(highest)+1), min: math.MaxInt64, max: math.MinInt64}
+}
+
+func bucketIndex(v int64) int {
+	if v < subCount {
+		return int(v)
+	}
+	exp := bits.Len64(uint64(v)) - subBits
+	return exp*subHalf + int(v>>exp)
+}
+
+// bucketHighest is the largest value that lands in bucket i, which is what
+// percentiles report (the true value is never above it).
+func bucketHighest(i int) int64 {
+	if i < subCount {
+		return int64(i)
+	}
+	exp := i/subHalf - 1
+	sub := int64(i - exp*subHalf)
+	return (sub+1)<<exp - 1
+}
+
+// Record adds one value.
+func (h *Histogram) Record(v int64) {
+	v = max(v, 0)
+	i := min(bucketIndex(v), len(h.counts)-1)
+	h.counts[i]++
+	h.total++
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+	f := float64(v)
+	h.sum += f
+	h.sumSq += f * f
+}
+
+// Merge adds all of o's values to h; both must have the same range.
+func (h *Histogram) Merge(o *Histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.total += o.total
+	h.min = min(h.min, o.min)
+	h.max = max(h.max, o.max)
+	h.sum += o.sum
+	h.sumSq += o.sumSq
+}
+
+// Count is the number of recorded values.
+func (h *Histogram) Count() uint64 { return h.total }
+
+// Min and Max are exact.
+func (h *Histogram) Min() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.max
+}
+
+// Mean and StdDev are exact.
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+func (h *Histogram) StdDev() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	m := h.Mean()
+	return math.Sqrt(max(h.sumSq/float64(h.total)-m*m, 0))
+}
+
+// Percentile returns the value below or at which p percent of the values
+// fall, e.g. Percentile(99.9).
+func (h *Histogram) Percentile(p float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p * float64(h.total) / 100)) // not p/100 first: 0.999·1000 rounds up to 1000
+	rank = max(rank, 1)
+	var seen uint64
+	for i, c := range h.counts {
+		if seen += c; seen >= rank {
+			return min(bucketHighest(i), h.max)
+		}
+	}
+	return h.max
+}
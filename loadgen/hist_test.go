@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBucketIndex(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want int
+	}{
+		{0, 0},
+		{1, 1},
+		{2047, 2047},
+		{2048, 2048}, // first doubling: width 2
+		{2049, 2048},
+		{2050, 2049},
+		{4095, 3071},
+		{4096, 3072}, // width 4
+		{4099, 3072},
+		{4100, 3073},
+	}
+	for _, tt := range tests {
+		if got := bucketIndex(tt.v); got != tt.want {
+			t.Errorf("bucketIndex(%d) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestBucketHighest(t *testing.T) {
+	// Buckets are contiguous: each one ends right before the next begins,
+	// and every value lands in a bucket whose highest value is within
+	// 1/1024 above it.
+	last := bucketIndex(int64(math.MaxInt64 >> 1))
+	for i := 0; i < last; i++ {
+		hi := bucketHighest(i)
+		if got := bucketIndex(hi); got != i {
+			t.Fatalf("bucketIndex(bucketHighest(%d) = %d) = %d", i, hi, got)
+		}
+		if got := bucketIndex(hi + 1); got != i+1 {
+			t.Fatalf("bucketIndex(%d) = %d, want the next bucket %d", hi+1, got, i+1)
+		}
+	}
+	for _, v := range []int64{0, 1, 2047, 2048, 3000, 1 << 20, 123456789, 1<<40 + 12345} {
+		hi := bucketHighest(bucketIndex(v))
+		if hi < v || float64(hi-v) > float64(v)/1024 {
+			t.Errorf("value %d reported as %d", v, hi)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	h := NewHistogram(int64(math.MaxInt32))
+	if h.Percentile(99) != 0 || h.Min() != 0 || h.Max() != 0 || h.Mean() != 0 || h.StdDev() != 0 {
+		t.Fatal("empty histogram does not report zeros")
+	}
+	for v := int64(1); v <= 1000; v++ {
+		h.Record(v)
+	}
+	tests := []struct {
+		p    float64
+		want int64
+	}{{0, 1}, {0.1, 1}, {50, 500}, {90, 900}, {99, 990}, {99.9, 999}, {100, 1000}}
+	for _, tt := range tests {
+		if got := h.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%g) = %d, want %d", tt.p, got, tt.want)
+		}
+	}
+	if h.Count() != 1000 || h.Min() != 1 || h.Max() != 1000 || h.Mean() != 500.5 {
+		t.Errorf("count %d, min %d, max %d, mean %g", h.Count(), h.Min(), h.Max(), h.Mean())
+	}
+	if sd := h.StdDev(); math.Abs(sd-288.675) > 0.001 {
+		t.Errorf("StdDev = %g", sd)
+	}
+
+	// Above the linear range a percentile is the bucket's top, capped at
+	// the exact maximum.
+	big := NewHistogram(int64(math.MaxInt32))
+	big.Record(1_000_000)
+	big.Record(1_000_003)
+	if got := big.Percentile(50); got < 1_000_000 || got > 1_000_000+1_000_000/1024 {
+		t.Errorf("p50 = %d, want 1000000 within 0.1%%", got)
+	}
+	if got := big.Percentile(100); got != 1_000_003 {
+		t.Errorf("p100 = %d, want the exact max", got)
+	}
+}
+
+func TestRecordClamps(t *testing.T) {
+	h := NewHistogram(1000)
+	h.Record(-5)
+	h.Record(5000)
+	if h.Min() != 0 || h.Max() != 5000 {
+		t.Errorf("min %d, max %d; want 0 and the exact 5000", h.Min(), h.Max())
+	}
+	if got := h.Percentile(100); got != 1000 {
+		t.Errorf("p100 = %d, want the top of the range", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := NewHistogram(10000), NewHistogram(10000)
+	for v := int64(1); v <= 100; v++ {
+		a.Record(v)
+		b.Record(v + 100)
+	}
+	a.Merge(b)
+	if a.Count() != 200 || a.Min() != 1 || a.Max() != 200 || a.Percentile(50) != 100 || a.Mean() != 100.5 {
+		t.Errorf("merged: count %d, min %d, max %d, p50 %d, mean %g", a.Count(), a.Min(), a.Max(), a.Percentile(50), a.Mean())
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+loadgen: drive an HTTP server and measure it
+
+	go run ./loadgen -url http://localhost:8080/items -c 50 -d 30s
+	go run ./loadgen -url http://localhost:8080 -rate 2000 -d 1m -requests requests.jsonl
+	go run ./loadgen -url http://localhost:8080/items -d 30s -json > run.json
+	go run ./loadgen -url http://localhost:8080/items -d 30s -baseline run.json   # exit 1 on regression
+
+	flags/templates → Runner ──→ closed: -c workers, back to back
+	                          └─→ open:   -rate requests per second
+	                  each result → status / error kind / latency (HDR histogram)
+	                  Report → text, or JSON with -json
+	                        → -baseline: compare, exit status 1 when worse
+
+Ctrl+C ends the run early and still prints the report. See run.go for the
+two load models, templates.go for request files, hist.go for the
+histogram.
+*/
+
+// headerFlags collects repeated -H "Name: value" flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string { return fmt.Sprint(map[string]string(h)) }
+
+func (h headerFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("want \"Name: value\", got %q", s)
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	return nil
+}
+
+// options are the command-line flags.
+type options struct {
+	target, method, body, requests string
+	headers                        headerFlags
+	rate                           float64
+	concurrency, maxInflight       int
+	duration, timeout              time.Duration
+	total                          int64
+	insecure, json                 bool
+	baseline                       string
+	tolerance                      float64
+}
+
+func main() {
+	o := options{headers: make(headerFlags)}
+	flag.StringVar(&o.target, "url", "", "target URL; base for template paths")
+	flag.StringVar(&o.method, "method", "GET", "request method without -requests")
+	flag.StringVar(&o.body, "body", "", "request body without -requests")
+	flag.Var(o.headers, "H", "request header \"Name: value\", repeatable")
+	flag.StringVar(&o.requests, "requests", "", "request templates, JSON array or JSON lines")
+	flag.Float64Var(&o.rate, "rate", 0, "open model: requests per second")
+	flag.IntVar(&o.concurrency, "c", 10, "closed model: concurrent workers")
+	flag.IntVar(&o.maxInflight, "max-inflight", 1000, "open model: requests in flight before new ones are dropped")
+	flag.DurationVar(&o.duration, "d", 10*time.Second, "run time")
+	flag.Int64Var(&o.total, "n", 0, "stop after this many requests (0 = run for -d)")
+	flag.DurationVar(&o.timeout, "timeout", 10*time.Second, "per request")
+	flag.BoolVar(&o.insecure, "k", false, "skip TLS certificate verification")
+	flag.BoolVar(&o.json, "json", false, "print the report as JSON")
+	flag.StringVar(&o.baseline, "baseline", "", "compare with a -json report; exit 1 on regression")
+	flag.Float64Var(&o.tolerance, "tolerance", 0.10, "allowed regression against -baseline")
+	flag.Parse()
+
+	if err := run(o); err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		os.Exit(2)
+	}
+}
+
+func run(o options) error {
+	var base *url.URL
+	if o.target != "" {
+		u, err := url.Parse(o.target)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("-url %q is not an absolute URL", o.target)
+		}
+		base = u
+	}
+
+	var templates []*Template
+	if o.requests != "" {
+		ts, err := LoadTemplates(o.requests)
+		if err != nil {
+			return err
+		}
+		templates = ts
+	} else {
+		if base == nil {
+			return fmt.Errorf("give -url, or -requests with full URLs")
+		}
+		t := &Template{Method: o.method, URL: o.target, Headers: map[string]string{}}
+		if o.body != "" {
+			b, _ := json.Marshal(o.body) // a string body is sent verbatim
+			t.Body = b
+		}
+		templates = []*Template{t}
+	}
+	if len(templates) == 0 {
+		return fmt.Errorf("%s has no requests", o.requests)
+	}
+	for _, t := range templates {
+		for k, v := range o.headers {
+			if !hasHeader(t.Headers, k) {
+				if t.Headers == nil {
+					t.Headers = make(map[string]string)
+				}
+				t.Headers[k] = v
+			}
+		}
+		if err := t.prepare(base); err != nil {
+			return err
+		}
+	}
+	if o.rate < 0 || o.concurrency < 1 || o.maxInflight < 1 {
+		return fmt.Errorf("-rate must be >= 0, -c and -max-inflight >= 1")
+	}
+	if o.duration <= 0 && o.total <= 0 {
+		return fmt.Errorf("give -d or -n")
+	}
+
+	conns := o.concurrency
+	if o.rate > 0 {
+		conns = o.maxInflight
+	}
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        conns,
+		MaxIdleConnsPerHost: conns, // net/http's default of 2 would measure dialing, not the server
+		IdleConnTimeout:     90 * time.Second,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: o.insecure},
+	}
+	r := &Runner{
+		Client:      &http.Client{Transport: tr, Timeout: o.timeout, CheckRedirect: noRedirects},
+		Templates:   newRotation(templates),
+		Rate:        o.rate,
+		Concurrency: o.concurrency,
+		MaxInflight: o.maxInflight,
+		Duration:    o.duration,
+		Requests:    o.total,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	label := o.target
+	if o.requests != "" {
+		label = fmt.Sprintf("%s (%d templates from %s)", o.target, len(templates), o.requests)
+	}
+	fmt.Fprintf(os.Stderr, "loadgen: %s for %s...\n", label, o.duration)
+	rep := NewReport(label, r, r.Run(ctx))
+
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			return err
+		}
+	} else {
+		rep.WriteText(os.Stdout)
+	}
+
+	if o.baseline != "" {
+		b, err := LoadReport(o.baseline)
+		if err != nil {
+			return err
+		}
+		if regressions := rep.Compare(b, o.tolerance); len(regressions) > 0 {
+			for _, s := range regressions {
+				fmt.Fprintln(os.Stderr, "regression:", s)
+			}
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "no regression against", o.baseline)
+	}
+	return nil
+}
+
+// noRedirects reports 3xx responses as they are instead of following them.
+func noRedirects(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
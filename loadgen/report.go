@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is the outcome of a run; -json prints it, -baseline reads one.
+type Report struct {
+	Target      string            `json:"target"`
+	Model       string            `json:"model"` // "open" or "closed"
+	Rate        float64           `json:"rate,omitempty"`
+	Concurrency int               `json:"concurrency,omitempty"`
+	Duration    float64           `json:"duration_s"`
+	Requests    uint64            `json:"requests"`
+	Throughput  float64           `json:"throughput_rps"`
+	Bytes       int64             `json:"bytes"`
+	Status      map[string]uint64 `json:"status"`
+	Errors      map[string]uint64 `json:"errors"`
+	ErrorRate   float64           `json:"error_rate"` // transport errors and 5xx over requests
+	Dropped     uint64            `json:"dropped,omitempty"`
+	SetupError  string            `json:"setup_error,omitempty"` // a request could not be built
+	Latency     Latency           `json:"latency_ms"`
+}
+
+// Latency is in milliseconds.
+type Latency struct {
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p99.9"`
+	Max    float64 `json:"max"`
+}
+
+func ms(us float64) float64 { return float64(int64(us)) / 1000 }
+
+// NewReport summarises c.
+func NewReport(target string, r *Runner, c *Collected) *Report {
+	h := c.Latency
+	rep := &Report{
+		Target:     target,
+		Model:      "closed",
+		Duration:   c.Elapsed.Seconds(),
+		Requests:   h.Count(),
+		Throughput: float64(h.Count()) / c.Elapsed.Seconds(),
+		Bytes:      c.Bytes,
+		Status:     make(map[string]uint64),
+		Errors:     c.Errors,
+		Dropped:    c.Dropped,
+		Latency: Latency{
+			Min: ms(float64(h.Min())), Mean: ms(h.Mean()), StdDev: ms(h.StdDev()),
+			P50: ms(float64(h.Percentile(50))), P90: ms(float64(h.Percentile(90))),
+			P95: ms(float64(h.Percentile(95))), P99: ms(float64(h.Percentile(99))),
+			P999: ms(float64(h.Percentile(99.9))), Max: ms(float64(h.Max())),
+		},
+	}
+	if c.Setup != nil {
+		rep.SetupError = c.Setup.Error()
+	}
+	if r.Rate > 0 {
+		rep.Model, rep.Rate = "open", r.Rate
+	} else {
+		rep.Concurrency = r.Concurrency
+	}
+	var failed uint64
+	for code, n := range c.Status {
+		rep.Status[strconv.Itoa(code)] = n
+		if code >= 500 {
+			failed += n
+		}
+	}
+	for _, n := range c.Errors {
+		failed += n
+	}
+	if rep.Requests > 0 {
+		rep.ErrorRate = float64(failed) / float64(rep.Requests)
+	}
+	return rep
+}
+
+// WriteText prints the report for people.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Target\t%s\n", r.Target)
+	if r.Model == "open" {
+		fmt.Fprintf(tw, "Model\topen, %g req/s\n", r.Rate)
+	} else {
+		fmt.Fprintf(tw, "Model\tclosed, %d workers\n", r.Concurrency)
+	}
+	fmt.Fprintf(tw, "Duration\t%s\n", time.Duration(r.Duration*float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintf(tw, "Requests\t%d (%.1f/s), %s read\n", r.Requests, r.Throughput, byteSize(r.Bytes))
+	fmt.Fprintf(tw, "Status\t%s\n", counts(r.Status))
+	if len(r.Errors) > 0 {
+		fmt.Fprintf(tw, "Errors\t%s\n", counts(r.Errors))
+	}
+	fmt.Fprintf(tw, "Error rate\t%.2f%%\n", r.ErrorRate*100)
+	if r.Dropped > 0 {
+		fmt.Fprintf(tw, "Dropped\t%d (max in-flight reached)\n", r.Dropped)
+	}
+	if r.SetupError != "" {
+		fmt.Fprintf(tw, "Setup error\t%s\n", r.SetupError)
+	}
+	l := r.Latency
+	fmt.Fprintf(tw, "Latency\tmin %.2fms  mean %.2fms  stddev %.2fms  max %.2fms\n", l.Min, l.Mean, l.StdDev, l.Max)
+	fmt.Fprintf(tw, "\tp50 %.2fms  p90 %.2fms  p95 %.2fms  p99 %.2fms  p99.9 %.2fms\n", l.P50, l.P90, l.P95, l.P99, l.P999)
+	return tw.Flush()
+}
+
+// counts formats a breakdown, biggest first: "200: 9876  503: 12".
+func counts(m map[string]uint64) string {
+	if len(m) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", k, m[k])
+	}
+	return strings.Join(parts, "  ")
+}
+
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// LoadReport reads a report written with -json.
+func LoadReport(name string) (*Report, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &r, nil
+}
+
+// Compare lists how r regressed against base: throughput or p99 worse by
+// more than tolerance (0.10 = 10%), an error rate more than one
+// percentage point higher, or requests that could not be built at all.
+func (r *Report) Compare(base *Report, tolerance float64) []string {
+	var out []string
+	if r.SetupError != "" {
+		out = append(out, "setup error: "+r.SetupError)
+	}
+	if base.Throughput > 0 && r.Throughput < base.Throughput*(1-tolerance) {
+		out = append(out, fmt.Sprintf("throughput %.1f/s is below baseline %.1f/s", r.Throughput, base.Throughput))
+	}
+	if base.Latency.P99 > 0 && r.Latency.P99 > base.Latency.P99*(1+tolerance) {
+		out = append(out, fmt.Sprintf("p99 %.2fms is above baseline %.2fms", r.Latency.P99, base.Latency.P99))
+	}
+	if r.ErrorRate > base.ErrorRate+0.01 {
+		out = append(out, fmt.Sprintf("error rate %.2f%% is above baseline %.2f%%", r.ErrorRate*100, base.ErrorRate*100))
+	}
+	return out
+}
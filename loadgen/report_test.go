@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	base := &Report{Throughput: 1000, ErrorRate: 0.02, Latency: Latency{P99: 50}}
+	tests := []struct {
+		name string
+		run  Report
+		want []string // prefixes of the regressions, in order
+	}{
+		{"same", Report{Throughput: 1000, ErrorRate: 0.02, Latency: Latency{P99: 50}}, nil},
+		{"better", Report{Throughput: 2000, Latency: Latency{P99: 10}}, nil},
+		{"within tolerance", Report{Throughput: 901, ErrorRate: 0.029, Latency: Latency{P99: 54.9}}, nil},
+		{"throughput down", Report{Throughput: 899, ErrorRate: 0.02, Latency: Latency{P99: 50}}, []string{"throughput"}},
+		{"p99 up", Report{Throughput: 1000, ErrorRate: 0.02, Latency: Latency{P99: 55.1}}, []string{"p99"}},
+		{"error rate up", Report{Throughput: 1000, ErrorRate: 0.031, Latency: Latency{P99: 50}}, []string{"error rate"}},
+		{"everything", Report{Throughput: 10, ErrorRate: 1, Latency: Latency{P99: 5000}}, []string{"throughput", "p99", "error rate"}},
+		{"setup error", Report{Throughput: 1000, ErrorRate: 0.02, Latency: Latency{P99: 50}, SetupError: "bad header"},
+			[]string{"setup error: bad header"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.run.Compare(base, 0.10)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Compare = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.want[i]) {
+					t.Errorf("Compare = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// A baseline without throughput or p99 (an empty run) gates on nothing
+	// but the error rate.
+	if got := (&Report{Throughput: 1, Latency: Latency{P99: 999}}).Compare(&Report{}, 0.10); got != nil {
+		t.Errorf("Compare with an empty baseline = %q", got)
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	c := newCollected()
+	c.Elapsed = 2 * time.Second
+	for _, r := range []result{
+		{status: 200, latency: time.Millisecond, bytes: 10},
+		{status: 200, latency: 3 * time.Millisecond, bytes: 10},
+		{status: 503, latency: 2 * time.Millisecond},
+		{errKind: "timeout", latency: 4 * time.Millisecond},
+		{errKind: "cancelled", latency: time.Hour},
+		{setup: errors.New("net/http: invalid method")},
+		{setup: errors.New("second setup failure")},
+	} {
+		c.add(r)
+	}
+	rep := NewReport("http://svc/", &Runner{Concurrency: 4}, c)
+	if rep.Requests != 4 || rep.Throughput != 2 || rep.Bytes != 20 || rep.ErrorRate != 0.5 {
+		t.Errorf("requests %d, throughput %g, bytes %d, error rate %g", rep.Requests, rep.Throughput, rep.Bytes, rep.ErrorRate)
+	}
+	if !reflect.DeepEqual(rep.Status, map[string]uint64{"200": 2, "503": 1}) ||
+		!reflect.DeepEqual(rep.Errors, map[string]uint64{"timeout": 1}) {
+		t.Errorf("status %v, errors %v", rep.Status, rep.Errors)
+	}
+	if rep.SetupError != "net/http: invalid method" {
+		t.Errorf("SetupError = %q, want the first one", rep.SetupError)
+	}
+	if rep.Latency.Min != 1 || rep.Latency.Max != 4 || rep.Model != "closed" || rep.Concurrency != 4 {
+		t.Errorf("report = %+v", rep)
+	}
+}
+
+func TestLoadReport(t *testing.T) {
+	want := &Report{Target: "http://svc/", Model: "open", Rate: 100, Throughput: 99.5,
+		Status: map[string]uint64{"200": 995}, Errors: map[string]uint64{}, Latency: Latency{P99: 12.5}}
+	data, _ := json.Marshal(want)
+	name := filepath.Join(t.TempDir(), "run.json")
+	os.WriteFile(name, data, 0o644)
+
+	got, err := LoadReport(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadReport = %+v, want %+v", got, want)
+	}
+	os.WriteFile(name, []byte("{"), 0o644)
+	if _, err := LoadReport(name); err == nil || !strings.HasPrefix(err.Error(), name) {
+		t.Errorf("err = %v, want one naming the file", err)
+	}
+}
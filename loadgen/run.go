@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*
+Open vs closed model
+
+	closed (-c N):  N workers, each sends its next request when the previous
+	                one is answered. A slow server slows the load down, so
+	                the measured latency hides queueing ("coordinated
+	                omission"). Good for "how much can it take".
+
+	open (-rate R): a request is due every 1/R seconds whether or not the
+	                earlier ones are done, like independent users. Latency is
+	                measured from when the request was DUE, so time spent
+	                queueing behind a stalled server is counted. Good for
+	                "what do users see at R req/s".
+
+In the open model at most -max-inflight requests run at once; requests due
+beyond that are counted as dropped instead of piling up goroutines.
+*/
+
+// result is one finished request.
+type result struct {
+	status  int    // 0 when err is set
+	errKind string // see classify
+	latency time.Duration
+	bytes   int64
+	setup   error // the request could not be built; nothing was sent
+}
+
+// Runner drives the load and collects results.
+type Runner struct {
+	Client      *http.Client
+	Templates   *rotation
+	Rate        float64 // > 0: open model
+	Concurrency int     // closed model workers
+	MaxInflight int
+	Duration    time.Duration
+	Requests    int64 // stop after this many (0 = until Duration)
+
+	sent    atomic.Int64
+	dropped atomic.Uint64
+}
+
+// Run blocks until the duration elapses, Requests have been sent or ctx is
+// cancelled, and returns the aggregated measurements.
+func (r *Runner) Run(ctx context.Context) *Collected {
+	if r.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Duration)
+		defer cancel()
+	}
+	results := make(chan result, 1024)
+	col := newCollected()
+	collected := make(chan struct{})
+	go func() {
+		for res := range results {
+			col.add(res)
+		}
+		close(collected)
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	if r.Rate > 0 {
+		r.open(ctx, results, &wg)
+	} else {
+		r.closed(ctx, results, &wg)
+	}
+	wg.Wait()
+	close(results)
+	<-collected
+
+	col.Elapsed = time.Since(start)
+	col.Dropped = r.dropped.Load()
+	return col
+}
+
+// take reserves one request from the -n budget.
+func (r *Runner) take() bool {
+	return r.Requests <= 0 || r.sent.Add(1) <= r.Requests
+}
+
+func (r *Runner) closed(ctx context.Context, results chan<- result, wg *sync.WaitGroup) {
+	for range r.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && r.take() {
+				results <- r.do(ctx, time.Now())
+			}
+		}()
+	}
+}
+
+func (r *Runner) open(ctx context.Context, results chan<- result, wg *sync.WaitGroup) {
+	interval := time.Duration(float64(time.Second) / r.Rate)
+	sem := make(chan struct{}, r.MaxInflight)
+	start := time.Now()
+	for i := 0; r.take(); i++ {
+		due := start.Add(time.Duration(i) * interval)
+		if d := time.Until(due); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			r.dropped.Add(1)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results <- r.do(ctx, due)
+		}()
+	}
+}
+
+// do sends one request; latency counts from due.
+func (r *Runner) do(ctx context.Context, due time.Time) result {
+	req, err := r.Templates.pick().request()
+	if err != nil {
+		return result{setup: err}
+	}
+	resp, err := r.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return result{errKind: classify(err, ctx), latency: time.Since(due)}
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res := result{status: resp.StatusCode, latency: time.Since(due), bytes: n}
+	if err != nil {
+		res.status, res.errKind = 0, "body: "+classify(err, ctx)
+	}
+	return res
+}
+
+// classify buckets transport errors for the report.
+func classify(err error, ctx context.Context) string {
+	var ne net.Error
+	switch {
+	case ctx.Err() != nil:
+		return "cancelled" // the run ended while the request was in flight
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "unexpected EOF"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	return "other"
+}
+
+// Collected holds the raw measurements of a run.
+type Collected struct {
+	Latency *Histogram // microseconds
+	Status  map[int]uint64
+	Errors  map[string]uint64
+	Bytes   int64
+	Elapsed time.Duration
+	Dropped uint64
+	Setup   error // the first request that could not be built
+}
+
+func newCollected() *Collected {
+	return &Collected{
+		Latency: NewHistogram(int64(time.Hour / time.Microsecond)),
+		Status:  make(map[int]uint64),
+		Errors:  make(map[string]uint64),
+	}
+}
+
+func (c *Collected) add(r result) {
+	if r.setup != nil {
+		if c.Setup == nil {
+			c.Setup = r.setup // not a measurement, and its text would be a new error kind each time
+		}
+		return
+	}
+	if r.errKind == "cancelled" {
+		return // cut off by the end of the run, not the server's fault
+	}
+	c.Latency.Record(r.latency.Microseconds())
+	c.Bytes += r.bytes
+	if r.errKind != "" {
+		c.Errors[r.errKind]++
+		return
+	}
+	c.Status[r.status]++
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunClosed(t *testing.T) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1)%4 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	tmpl := &Template{URL: srv.URL}
+	if err := tmpl.prepare(nil); err != nil {
+		t.Fatal(err)
+	}
+	r := &Runner{Client: srv.Client(), Templates: newRotation([]*Template{tmpl}), Concurrency: 4, Requests: 40}
+	c := r.Run(context.Background())
+	if hits.Load() != 40 || c.Latency.Count() != 40 || c.Status[200] != 30 || c.Status[503] != 10 {
+		t.Errorf("hits %d, recorded %d, status %v", hits.Load(), c.Latency.Count(), c.Status)
+	}
+}
+
+func TestRunSetupError(t *testing.T) {
+	// Not prepared, so the bad method only shows when building requests.
+	bad := &Template{Method: "GET ME", URL: "http://svc.test/", Weight: 1}
+	r := &Runner{Client: http.DefaultClient, Templates: newRotation([]*Template{bad}), Concurrency: 2, Requests: 10}
+	c := r.Run(context.Background())
+	if c.Setup == nil || c.Latency.Count() != 0 || len(c.Errors) != 0 {
+		t.Errorf("setup %v, recorded %d, errors %v; want one setup error and no measurements",
+			c.Setup, c.Latency.Count(), c.Errors)
+	}
+}
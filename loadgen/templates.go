@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+Request templates
+
+Without -requests every request is -method -url with the -H headers and
+-body. With -requests FILE the requests come from a file, one JSON object
+per line (a recording such as requests.jsonl), or a JSON array:
+
+	{"method": "GET", "path": "/items?limit=10"}
+	{"method": "POST", "path": "/items", "body": {"name": "pen", "quantity": 3}, "weight": 3}
+	{"method": "POST", "url": "http://other:9000/rpc", "headers": {"Authorization": "Bearer t"}, "body": "raw text"}
+
+- "path" is resolved against -url; "url" is used as is
+- a string body is sent verbatim, any other JSON value is sent as JSON
+  (with Content-Type: application/json unless the template sets one)
+- "weight" repeats a template in the rotation (default 1)
+- unknown fields are ignored, so richer recordings load as they are
+
+Templates are sent in rotation: t1 t2 t2 t2 t3 t1 t2 ...
+*/
+
+// Template is one request shape.
+type Template struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+	Weight  int               `json:"weight"`
+
+	body  []byte
+	label string
+}
+
+// LoadTemplates reads a JSON array or JSON lines file.
+func LoadTemplates(name string) ([]*Template, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var ts []*Template
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &ts); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return ts, nil
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		t := new(Template)
+		if err := json.Unmarshal(text, t); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		ts = append(ts, t)
+	}
+	return ts, sc.Err()
+}
+
+// prepare resolves URLs against base and encodes bodies once, so building
+// a request during the run is cheap.
+func (t *Template) prepare(base *url.URL) error {
+	if t.Method == "" {
+		t.Method = http.MethodGet
+	}
+	t.Method = strings.ToUpper(t.Method)
+	switch {
+	case t.URL != "":
+	case t.Path != "" && base != nil:
+		ref, err := url.Parse(t.Path)
+		if err != nil {
+			return err
+		}
+		t.URL = base.ResolveReference(ref).String()
+	default:
+		return fmt.Errorf("%s template needs \"url\", or \"path\" with -url", t.Method)
+	}
+	if _, err := url.Parse(t.URL); err != nil {
+		return err
+	}
+
+	raw := bytes.TrimSpace(t.Body)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		t.body = []byte(s)
+	default:
+		t.body = raw
+		if !hasHeader(t.Headers, "Content-Type") {
+			if t.Headers == nil {
+				t.Headers = make(map[string]string)
+			}
+			t.Headers["Content-Type"] = "application/json"
+		}
+	}
+	if t.Weight <= 0 {
+		t.Weight = 1
+	}
+	t.label = t.Method + " " + t.URL
+	_, err := t.request() // catch a bad method or header before the run
+	return err
+}
+
+func hasHeader(h map[string]string, name string) bool {
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Template) request() (*http.Request, error) {
+	var body io.Reader = http.NoBody
+	if t.body != nil {
+		body = bytes.NewReader(t.body)
+	}
+	req, err := http.NewRequest(t.Method, t.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// rotation hands out templates in weighted round-robin order; it is safe
+// for concurrent use.
+type rotation struct {
+	slots []*Template
+	next  atomic.Uint64
+}
+
+func newRotation(ts []*Template) *rotation {
+	r := new(rotation)
+	for _, t := range ts {
+		for range t.Weight {
+			r.slots = append(r.slots, t)
+		}
+	}
+	return r
+}
+
+func (r *rotation) pick() *Template {
+	return r.slots[(r.next.Add(1)-1)%uint64(len(r.slots))]
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "requests")
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string // method and path of each template
+		err     string
+	}{
+		{"json lines", `{"method": "GET", "path": "/items"}
+# a comment
+
+{"method": "post", "path": "/items", "body": {"name": "pen"}, "recorded_at": "ignored"}
+`, []string{"GET /items", "post /items"}, ""},
+		{"json array", `  [{"path": "/a"}, {"url": "http://other/b"}]`, []string{" /a", " "}, ""},
+		{"empty", "", nil, ""},
+		{"bad line", "{\"path\": \"/a\"}\n{\"path\":\n", nil, ":2: "},
+		{"bad array", `[{"path": 1}]`, nil, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, err := LoadTemplates(writeFile(t, tt.content))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tmpl := range ts {
+				got = append(got, tmpl.Method+" "+tmpl.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("templates = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	base, _ := url.Parse("http://svc:8080/api/")
+	tests := []struct {
+		name   string
+		tmpl   Template
+		base   *url.URL
+		method string
+		url    string
+		body   string
+		ctype  string
+		err    string
+	}{
+		{name: "path against the base", tmpl: Template{Path: "items?limit=10"}, base: base,
+			method: "GET", url: "http://svc:8080/api/items?limit=10"},
+		{name: "absolute path", tmpl: Template{Method: "delete", Path: "/items/7"}, base: base,
+			method: "DELETE", url: "http://svc:8080/items/7"},
+		{name: "url as is", tmpl: Template{URL: "http://other:9000/rpc", Path: "/ignored"}, base: base,
+			method: "GET", url: "http://other:9000/rpc"},
+		{name: "string body verbatim", tmpl: Template{Method: "POST", URL: "http://svc/", Body: []byte(`"raw text"`)},
+			method: "POST", url: "http://svc/", body: "raw text"},
+		{name: "json body", tmpl: Template{Method: "POST", URL: "http://svc/", Body: []byte(` {"name": "pen"} `)},
+			method: "POST", url: "http://svc/", body: `{"name": "pen"}`, ctype: "application/json"},
+		{name: "own content type kept", tmpl: Template{Method: "POST", URL: "http://svc/", Body: []byte(`[1]`),
+			Headers: map[string]string{"content-type": "application/x-ndjson"}},
+			method: "POST", url: "http://svc/", body: "[1]", ctype: "application/x-ndjson"},
+		{name: "null body", tmpl: Template{URL: "http://svc/", Body: []byte("null")}, method: "GET", url: "http://svc/"},
+		{name: "path without a base", tmpl: Template{Path: "/items"}, err: `needs "url"`},
+		{name: "bad method", tmpl: Template{Method: "GET ME", URL: "http://svc/"}, err: "invalid method"},
+		{name: "bad url", tmpl: Template{URL: "http://svc/%zz"}, err: "invalid URL escape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl := tt.tmpl
+			err := tmpl.prepare(tt.base)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := tmpl.request()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(req.Body)
+			if req.Method != tt.method || req.URL.String() != tt.url || string(body) != tt.body ||
+				req.Header.Get("Content-Type") != tt.ctype {
+				t.Errorf("request = %s %s %q, Content-Type %q", req.Method, req.URL, body, req.Header.Get("Content-Type"))
+			}
+			if tmpl.Weight != 1 {
+				t.Errorf("Weight = %d, want the default 1", tmpl.Weight)
+			}
+		})
+	}
+}
+
+func TestHostHeader(t *testing.T) {
+	tmpl := Template{URL: "http://10.0.0.1/", Headers: map[string]string{"Host": "svc.internal"}}
+	if err := tmpl.prepare(nil); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := tmpl.request()
+	if req.Host != "svc.internal" || req.Header.Get("Host") != "" {
+		t.Errorf("Host = %q, header %q", req.Host, req.Header.Get("Host"))
+	}
+}
+
+func TestRotation(t *testing.T) {
+	a, b := &Template{Weight: 1, label: "a"}, &Template{Weight: 3, label: "b"}
+	r := newRotation([]*Template{a, b})
+	var got []string
+	for range 9 {
+		got = append(got, r.pick().label)
+	}
+	if s := strings.Join(got, ""); s != "abbbabbba" {
+		t.Errorf("rotation = %s", s)
+	}
+}
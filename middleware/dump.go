@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Debug Dump Middleware
+/*
+Instead of sprinkling fmt.Println into handlers (see helloHandler), wrap the
+route with DumpMiddleware and it logs the full request and response.
+
+Two ways to turn it on:
+- Per route:  Chain(handler, DumpMiddleware(DumpConfig{Always: true}))
+- On demand:  send "X-Debug-Dump: <shared secret>" with the request
+
+Everything is redacted before it is logged:
+- JSON fields by name (password, token, ...) at any depth
+- header values by name (Authorization, Cookie, ...)
+- regex matches anywhere in the body (emails, card numbers, bearer tokens)
+
+Query parameters get the same treatment: values of the listed JSON field
+names and pattern matches are redacted.
+
+Bodies are truncated to MaxBytes so a large upload cannot flood the logs.
+Redaction runs first, on a copy that reaches dumpMargin bytes past the cut,
+so a card number or token straddling MaxBytes is still recognised whole.
+*/
+
+const (
+	redacted   = "[REDACTED]"
+	dumpMargin = 1024
+)
+
+// Default redaction patterns for DumpConfig.
+var (
+	EmailPattern      = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+	CardNumberPattern = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+	TokenPattern      = regexp.MustCompile(`(?i)\bbearer\s+[a-z0-9\-._~+/]+=*|\beyJ[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]+`)
+)
+
+// DumpConfig configures DumpMiddleware. The zero value dumps nothing.
+type DumpConfig struct {
+	// Always dumps every request; use it when wrapping a single route.
+	Always bool
+	// Header and Secret enable dumping per request when the header value
+	// equals the secret. Header defaults to "X-Debug-Dump".
+	Header string
+	Secret string
+
+	RedactJSONFields []string         // case-insensitive field names
+	RedactHeaders    []string         // case-insensitive header names
+	RedactPatterns   []*regexp.Regexp // nil uses email, card number and token patterns
+
+	MaxBytes int         // per body, default 4096
+	Logger   *log.Logger // default log.Default()
+}
+
+// DumpMiddleware logs redacted request and response bodies for enabled requests.
+func DumpMiddleware(cfg DumpConfig) func(http.Handler) http.Handler {
+	if cfg.Header == "" {
+		cfg.Header = "X-Debug-Dump"
+	}
+	if cfg.RedactPatterns == nil {
+		cfg.RedactPatterns = []*regexp.Regexp{EmailPattern, CardNumberPattern, TokenPattern}
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 4096
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+	fields := lowerSet(cfg.RedactJSONFields)
+	headers := lowerSet(append([]string{"Authorization", "Cookie", "Set-Cookie", cfg.Header}, cfg.RedactHeaders...))
+	fieldRe := jsonFieldPattern(cfg.RedactJSONFields)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !cfg.enabled(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Keep at most MaxBytes+dumpMargin bytes for the log and hand the
+			// full body on to the handler.
+			var reqBody []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				reqBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(cfg.MaxBytes+dumpMargin)))
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+			}
+
+			dw := &dumpResponseWriter{ResponseWriter: w, status: http.StatusOK, max: cfg.MaxBytes + dumpMargin}
+			next.ServeHTTP(dw, r)
+
+			cfg.Logger.Printf("dump request: %s %s\n%s\n%s",
+				r.Method, cfg.redactURI(r.URL, fields),
+				formatHeaders(r.Header, headers),
+				cfg.redactBody(reqBody, fields, fieldRe))
+			cfg.Logger.Printf("dump response: %d %s\n%s\n%s",
+				dw.status, r.URL.Path,
+				formatHeaders(dw.Header(), headers),
+				cfg.redactBody(dw.body.Bytes(), fields, fieldRe))
+		})
+	}
+}
+
+func (cfg *DumpConfig) enabled(r *http.Request) bool {
+	if cfg.Always {
+		return true
+	}
+	if cfg.Secret == "" {
+		return false
+	}
+	got := r.Header.Get(cfg.Header)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(cfg.Secret)) == 1
+}
+
+// redactBody redacts JSON fields and pattern matches, then truncates.
+// Bodies that do not parse as JSON (including truncated ones) fall back to
+// a textual match on `"field": value`.
+func (cfg *DumpConfig) redactBody(body []byte, fields map[string]bool, fieldRe *regexp.Regexp) string {
+	if len(body) == 0 {
+		return "<empty>"
+	}
+	s := string(body)
+	if fieldRe != nil {
+		var v any
+		if json.Unmarshal(body, &v) == nil {
+			if b, err := json.Marshal(redactJSON(v, fields)); err == nil {
+				s, fieldRe = string(b), nil
+			}
+		}
+	}
+	out := cfg.redactText(s, fieldRe, cfg.MaxBytes)
+	if len(s) > cfg.MaxBytes {
+		out += "...[truncated]"
+	}
+	return out
+}
+
+// redactText replaces pattern matches and `"field": value` values in s and
+// cuts the result at byte offset limit of s. Matches are found in all of s
+// first, so one that starts before the cut is redacted whole.
+func (cfg *DumpConfig) redactText(s string, fieldRe *regexp.Regexp, limit int) string {
+	var spans [][2]int
+	if fieldRe != nil {
+		for _, m := range fieldRe.FindAllStringSubmatchIndex(s, -1) {
+			spans = append(spans, [2]int{m[4], m[5]})
+		}
+	}
+	for _, re := range cfg.RedactPatterns {
+		for _, m := range re.FindAllStringIndex(s, -1) {
+			spans = append(spans, [2]int{m[0], m[1]})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	var b strings.Builder
+	pos := 0
+	for _, sp := range spans {
+		if sp[0] >= limit {
+			break
+		}
+		if sp[0] < pos { // overlaps a span already redacted
+			pos = max(pos, sp[1])
+			continue
+		}
+		b.WriteString(s[pos:sp[0]])
+		b.WriteString(redacted)
+		pos = sp[1]
+	}
+	if pos < limit {
+		b.WriteString(s[pos:min(limit, len(s))])
+	}
+	return b.String()
+}
+
+// redactURI is the request URI with sensitive query values redacted.
+func (cfg *DumpConfig) redactURI(u *url.URL, fields map[string]bool) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, p := range params {
+		k, v, _ := strings.Cut(p, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		if fields[strings.ToLower(name)] {
+			params[i] = k + "=" + redacted
+			continue
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			value = v
+		}
+		if out := cfg.redactText(value, nil, len(value)); out != value {
+			params[i] = k + "=" + out
+		}
+	}
+	return u.EscapedPath() + "?" + strings.Join(params, "&")
+}
+
+// jsonFieldPattern matches `"name": value` for any of the field names.
+func jsonFieldPattern(fields []string) *regexp.Regexp {
+	if len(fields) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = regexp.QuoteMeta(f)
+	}
+	return regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+}
+
+func redactJSON(v any, fields map[string]bool) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if fields[strings.ToLower(k)] {
+				t[k] = redacted
+			} else {
+				t[k] = redactJSON(val, fields)
+			}
+		}
+	case []any:
+		for i, val := range t {
+			t[i] = redactJSON(val, fields)
+		}
+	}
+	return v
+}
+
+func formatHeaders(h http.Header, redact map[string]bool) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if redact[strings.ToLower(k)] {
+			v = redacted
+		}
+		b.WriteString(k + ": " + v + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func lowerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[strings.ToLower(n)] = true
+	}
+	return set
+}
+
+// dumpResponseWriter forwards the response and keeps a bounded copy of the body.
+type dumpResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	max    int
+}
+
+func (w *dumpResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *dumpResponseWriter) Write(b []byte) (int, error) {
+	if room := w.max - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(room, len(b))])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *dumpResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *dumpResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"middleware/middlewaretest"
+)
+
+// dumpEcho answers with the request body it received.
+var dumpEcho = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Set-Cookie", "session=abc")
+	w.Write(body)
+})
+
+func dumpLog(t *testing.T, cfg DumpConfig, req *middlewaretest.RequestBuilder) (string, *middlewaretest.Response) {
+	t.Helper()
+	var buf bytes.Buffer
+	cfg.Logger = log.New(&buf, "", 0)
+	resp := req.Do(t, Chain(dumpEcho, DumpMiddleware(cfg)))
+	return buf.String(), resp
+}
+
+func TestDumpMiddlewareRedacts(t *testing.T) {
+	card := "4111 1111 1111 1111"
+	tests := []struct {
+		name    string
+		cfg     DumpConfig
+		req     *middlewaretest.RequestBuilder
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "json fields at any depth",
+			cfg:     DumpConfig{Always: true, RedactJSONFields: []string{"password"}},
+			req:     middlewaretest.NewRequest("POST", "/login").Body(`{"user":"bob","auth":{"Password":"hunter2"}}`),
+			want:    []string{`"Password":"[REDACTED]"`, `"user":"bob"`},
+			notWant: []string{"hunter2"},
+		},
+		{
+			name:    "headers",
+			cfg:     DumpConfig{Always: true, RedactHeaders: []string{"X-Api-Key"}},
+			req:     middlewaretest.NewRequest("GET", "/").Header("X-Api-Key", "k1").Bearer("t1"),
+			want:    []string{"X-Api-Key: [REDACTED]", "Authorization: [REDACTED]", "Set-Cookie: [REDACTED]"},
+			notWant: []string{"k1", "t1", "session=abc"},
+		},
+		{
+			name:    "patterns in text",
+			cfg:     DumpConfig{Always: true},
+			req:     middlewaretest.NewRequest("POST", "/").Body("mail bob@example.com card " + card),
+			want:    []string{"mail [REDACTED] card [REDACTED]"},
+			notWant: []string{"bob@example.com", "4111"},
+		},
+		{
+			name:    "card split by the cut",
+			cfg:     DumpConfig{Always: true, MaxBytes: 10},
+			req:     middlewaretest.NewRequest("POST", "/").Body("card " + card + strings.Repeat(" ", 20)),
+			want:    []string{"card [REDACTED]...[truncated]"},
+			notWant: []string{"4111", "card 4"},
+		},
+		{
+			name:    "field split by the cut in truncated json",
+			cfg:     DumpConfig{Always: true, MaxBytes: 20, RedactJSONFields: []string{"token"}},
+			req:     middlewaretest.NewRequest("POST", "/").Body(`{"id":1,"token":"abcdefghijklmnop","pad":"` + strings.Repeat("x", 2000) + `"}`),
+			want:    []string{`{"id":1,"token":[REDACTED]...[truncated]`},
+			notWant: []string{"abcdef"},
+		},
+		{
+			name:    "query parameters",
+			cfg:     DumpConfig{Always: true, RedactJSONFields: []string{"token"}},
+			req:     middlewaretest.NewRequest("GET", "/reset?token=s3cret&email=bob%40example.com&page=2"),
+			want:    []string{"GET /reset?token=[REDACTED]&email=[REDACTED]&page=2"},
+			notWant: []string{"s3cret", "bob"},
+		},
+		{
+			name: "truncation",
+			cfg:  DumpConfig{Always: true, MaxBytes: 8},
+			req:  middlewaretest.NewRequest("POST", "/").Body("0123456789abcdef"),
+			want: []string{"01234567...[truncated]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _ := dumpLog(t, tt.cfg, tt.req)
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("dump does not contain %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(out, s) {
+					t.Errorf("dump contains %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestDumpMiddlewareEnabling(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    DumpConfig
+		header string
+		dumped bool
+	}{
+		{"zero value dumps nothing", DumpConfig{}, "", false},
+		{"no secret ignores the header", DumpConfig{}, "anything", false},
+		{"wrong secret", DumpConfig{Secret: "s"}, "x", false},
+		{"right secret", DumpConfig{Secret: "s"}, "s", true},
+		{"always", DumpConfig{Always: true}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := middlewaretest.NewRequest("POST", "/").Body("hello")
+			if tt.header != "" {
+				req.Header("X-Debug-Dump", tt.header)
+			}
+			out, resp := dumpLog(t, tt.cfg, req)
+			resp.AssertStatus(t, http.StatusOK).AssertBody(t, "hello")
+			if got := out != ""; got != tt.dumped {
+				t.Errorf("dumped = %v, want %v:\n%s", got, tt.dumped, out)
+			}
+		})
+	}
+}
+
+func TestDumpMiddlewarePassesFullBody(t *testing.T) {
+	body := strings.Repeat("a", 10000)
+	out, resp := dumpLog(t, DumpConfig{Always: true, MaxBytes: 16},
+		middlewaretest.NewRequest("POST", "/").Body(body))
+	resp.AssertBody(t, body)
+	if strings.Contains(out, strings.Repeat("a", 17)) {
+		t.Errorf("dump holds more than MaxBytes of the body:\n%.200s", out)
+	}
+}
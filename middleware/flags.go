@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Feature Flags & Maintenance Middleware
+/*
+atomic/main.go keeps featureEnabled / shuttingDown in int32s. Here the same
+idea is lifted to a whole snapshot of flags:
+
+- readers (every request) do ONE atomic.Pointer.Load → no locks on the hot path
+- writers (admin endpoint) copy the snapshot, change the copy, Store it back
+
+Readers never see a half-updated snapshot: they see the old one or the new one.
+
+flags := NewFlagSet()
+mux.Handle("/admin/flags", FlagsAdminHandler(flags, adminToken))
+mux.Handle("/api/", Chain(apiHandler, FlagsMiddleware(flags, "api")))
+mux.Handle("/beta", Chain(betaHandler, RequireFlag("beta", UserIDFromHeader("X-User-ID")), FlagsMiddleware(flags, "")))
+*/
+
+// Flag is a single feature flag. When Percent is set the flag is only on for
+// that share of users (0-100), picked by a stable hash of the user ID; nil
+// means no rollout restriction.
+type Flag struct {
+	Enabled bool `json:"enabled"`
+	Percent *int `json:"percent,omitempty"`
+}
+
+// Rollout returns p for Flag.Percent.
+func Rollout(p int) *int { return &p }
+
+func (f Flag) validate(name string) error {
+	if f.Percent != nil && (*f.Percent < 0 || *f.Percent > 100) {
+		return fmt.Errorf("flag %q: percent must be between 0 and 100, got %d", name, *f.Percent)
+	}
+	return nil
+}
+
+// FlagSnapshot is an immutable view of all flags. Never modify one that has
+// been returned by FlagSet.Snapshot; use FlagSet.Update instead.
+type FlagSnapshot struct {
+	Maintenance       bool            `json:"maintenance"`
+	MaintenanceGroups map[string]bool `json:"maintenance_groups"`
+	RetryAfter        int             `json:"retry_after"` // seconds
+	Flags             map[string]Flag `json:"flags"`
+}
+
+// InMaintenance reports whether the whole service or the group is down.
+func (s *FlagSnapshot) InMaintenance(group string) bool {
+	return s.Maintenance || (group != "" && s.MaintenanceGroups[group])
+}
+
+// Enabled reports whether the flag is on for userID.
+func (s *FlagSnapshot) Enabled(name, userID string) bool {
+	f, ok := s.Flags[name]
+	if !ok || !f.Enabled {
+		return false
+	}
+	switch {
+	case f.Percent == nil || *f.Percent >= 100:
+		return true
+	case *f.Percent <= 0 || userID == "":
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + userID))
+	return int(h.Sum32()%100) < *f.Percent
+}
+
+func (s *FlagSnapshot) clone() *FlagSnapshot {
+	c := *s
+	c.MaintenanceGroups = maps.Clone(s.MaintenanceGroups)
+	c.Flags = maps.Clone(s.Flags)
+	if c.MaintenanceGroups == nil {
+		c.MaintenanceGroups = map[string]bool{}
+	}
+	if c.Flags == nil {
+		c.Flags = map[string]Flag{}
+	}
+	return &c
+}
+
+// FlagSet holds the current snapshot.
+type FlagSet struct {
+	snap atomic.Pointer[FlagSnapshot]
+	mu   sync.Mutex // serialises writers only
+
+	// MaintenancePage is served with the 503; defaults to a short text body.
+	MaintenancePage []byte
+	// MaintenanceContentType defaults to "text/html; charset=utf-8".
+	MaintenanceContentType string
+}
+
+// NewFlagSet returns a FlagSet with everything off.
+func NewFlagSet() *FlagSet {
+	fs := &FlagSet{}
+	fs.snap.Store((&FlagSnapshot{RetryAfter: 60}).clone())
+	return fs
+}
+
+// Snapshot returns the current flags without locking.
+func (fs *FlagSet) Snapshot() *FlagSnapshot {
+	return fs.snap.Load()
+}
+
+// Update applies fn to a copy of the current snapshot and publishes it.
+func (fs *FlagSet) Update(fn func(s *FlagSnapshot)) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	next := fs.snap.Load().clone()
+	fn(next)
+	fs.snap.Store(next)
+}
+
+// SetFlag adds or replaces a flag.
+func (fs *FlagSet) SetFlag(name string, f Flag) {
+	fs.Update(func(s *FlagSnapshot) { s.Flags[name] = f })
+}
+
+// SetMaintenance toggles maintenance for a group, or the whole service when
+// group is empty.
+func (fs *FlagSet) SetMaintenance(group string, on bool) {
+	fs.Update(func(s *FlagSnapshot) {
+		switch {
+		case group == "":
+			s.Maintenance = on
+		case on:
+			s.MaintenanceGroups[group] = true
+		default:
+			delete(s.MaintenanceGroups, group)
+		}
+	})
+}
+
+type flagsContextKey struct{}
+
+// FlagsFromContext returns the snapshot FlagsMiddleware loaded for this request,
+// so every check within one request sees the same flags.
+func FlagsFromContext(ctx context.Context) (*FlagSnapshot, bool) {
+	s, ok := ctx.Value(flagsContextKey{}).(*FlagSnapshot)
+	return s, ok
+}
+
+// FlagsMiddleware loads the flag snapshot once per request, answers 503 with
+// Retry-After while the service or the route group is in maintenance, and
+// stores the snapshot in the request context.
+func FlagsMiddleware(fs *FlagSet, group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			snap := fs.Snapshot()
+			if snap.InMaintenance(group) {
+				fs.writeMaintenance(w, snap)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), flagsContextKey{}, snap)))
+		})
+	}
+}
+
+func (fs *FlagSet) writeMaintenance(w http.ResponseWriter, snap *FlagSnapshot) {
+	if snap.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(snap.RetryAfter))
+	}
+	if fs.MaintenancePage == nil {
+		http.Error(w, "service under maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	ct := fs.MaintenanceContentType
+	if ct == "" {
+		ct = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(fs.MaintenancePage)
+}
+
+// UserIDFromHeader reads the rollout key from a request header.
+func UserIDFromHeader(name string) func(*http.Request) string {
+	return func(r *http.Request) string { return r.Header.Get(name) }
+}
+
+// RequireFlag answers 404 unless the flag is on for the user. It must run
+// inside FlagsMiddleware.
+func RequireFlag(name string, userID func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			snap, ok := FlagsFromContext(r.Context())
+			if !ok || !snap.Enabled(name, userID(r)) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// flagsPatch is the PATCH body of the admin endpoint. Omitted fields are left
+// alone, a null flag deletes it, a false group ends its maintenance.
+type flagsPatch struct {
+	Maintenance       *bool            `json:"maintenance"`
+	MaintenanceGroups map[string]bool  `json:"maintenance_groups"`
+	RetryAfter        *int             `json:"retry_after"`
+	Flags             map[string]*Flag `json:"flags"`
+}
+
+// FlagsAdminHandler exposes the flags at runtime behind a bearer token:
+//
+//	GET   → current snapshot
+//	PUT   → replace the snapshot
+//	PATCH → merge a flagsPatch
+//
+// Mount it outside FlagsMiddleware so it keeps working during maintenance.
+func FlagsAdminHandler(fs *FlagSet, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var snap FlagSnapshot
+			if err := json.NewDecoder(r.Body).Decode(&snap); err != nil {
+				http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			for name, f := range snap.Flags {
+				if err := f.validate(name); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			fs.Update(func(s *FlagSnapshot) { *s = *snap.clone() })
+		case http.MethodPatch:
+			var p flagsPatch
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			for name, f := range p.Flags {
+				if f == nil {
+					continue
+				}
+				if err := f.validate(name); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			fs.Update(func(s *FlagSnapshot) {
+				if p.Maintenance != nil {
+					s.Maintenance = *p.Maintenance
+				}
+				if p.RetryAfter != nil {
+					s.RetryAfter = *p.RetryAfter
+				}
+				for g, on := range p.MaintenanceGroups {
+					if on {
+						s.MaintenanceGroups[g] = true
+					} else {
+						delete(s.MaintenanceGroups, g)
+					}
+				}
+				for name, f := range p.Flags {
+					if f == nil {
+						delete(s.Flags, name)
+					} else {
+						s.Flags[name] = *f
+					}
+				}
+			})
+		default:
+			w.Header().Set("Allow", "GET, PUT, PATCH")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fs.Snapshot())
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"middleware/middlewaretest"
+)
+
+func TestFlagSnapshotEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		flag Flag
+		want int // users out of 1000 with the flag on
+	}{
+		{"disabled", Flag{Enabled: false}, 0},
+		{"disabled with rollout", Flag{Enabled: false, Percent: Rollout(100)}, 0},
+		{"no rollout", Flag{Enabled: true}, 1000},
+		{"100%", Flag{Enabled: true, Percent: Rollout(100)}, 1000},
+		{"0% is nobody", Flag{Enabled: true, Percent: Rollout(0)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := NewFlagSet()
+			fs.SetFlag("beta", tt.flag)
+			snap := fs.Snapshot()
+			on := 0
+			for i := range 1000 {
+				if snap.Enabled("beta", fmt.Sprint("user-", i)) {
+					on++
+				}
+			}
+			if on != tt.want {
+				t.Errorf("on for %d of 1000 users, want %d", on, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagSnapshotRolloutIsStable(t *testing.T) {
+	fs := NewFlagSet()
+	fs.SetFlag("beta", Flag{Enabled: true, Percent: Rollout(30)})
+	snap := fs.Snapshot()
+	on := 0
+	for i := range 1000 {
+		user := fmt.Sprint("user-", i)
+		got := snap.Enabled("beta", user)
+		if got != snap.Enabled("beta", user) {
+			t.Fatalf("Enabled(%s) changed between calls", user)
+		}
+		if got {
+			on++
+		}
+	}
+	if on < 200 || on > 400 {
+		t.Errorf("30%% rollout on for %d of 1000 users", on)
+	}
+	if snap.Enabled("beta", "") {
+		t.Error("partial rollout on for a request without a user id")
+	}
+}
+
+func TestFlagsMiddleware(t *testing.T) {
+	fs := NewFlagSet()
+	fs.SetFlag("beta", Flag{Enabled: true})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	api := Chain(ok, FlagsMiddleware(fs, "api"))
+	beta := Chain(ok, RequireFlag("beta", UserIDFromHeader("X-User-ID")), FlagsMiddleware(fs, ""))
+
+	middlewaretest.NewRequest("GET", "/api").Do(t, api).AssertStatus(t, http.StatusOK)
+	middlewaretest.NewRequest("GET", "/beta").Do(t, beta).AssertStatus(t, http.StatusOK)
+
+	fs.SetMaintenance("api", true)
+	middlewaretest.NewRequest("GET", "/api").Do(t, api).
+		AssertStatus(t, http.StatusServiceUnavailable).
+		AssertHeader(t, "Retry-After", "60")
+	middlewaretest.NewRequest("GET", "/beta").Do(t, beta).AssertStatus(t, http.StatusOK)
+
+	fs.SetMaintenance("api", false)
+	fs.SetFlag("beta", Flag{Enabled: true, Percent: Rollout(0)})
+	middlewaretest.NewRequest("GET", "/api").Do(t, api).AssertStatus(t, http.StatusOK)
+	middlewaretest.NewRequest("GET", "/beta").Header("X-User-ID", "u1").Do(t, beta).AssertStatus(t, http.StatusNotFound)
+
+	fs.MaintenancePage = []byte("<h1>back soon</h1>")
+	fs.SetMaintenance("", true)
+	middlewaretest.NewRequest("GET", "/beta").Do(t, beta).
+		AssertStatus(t, http.StatusServiceUnavailable).
+		AssertHeader(t, "Content-Type", "text/html; charset=utf-8").
+		AssertBody(t, "<h1>back soon</h1>")
+}
+
+func TestFlagsAdminHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		code   int
+	}{
+		{"no token", "GET", "", "", http.StatusUnauthorized},
+		{"wrong token", "GET", "nope", "", http.StatusUnauthorized},
+		{"get", "GET", "admin", "", http.StatusOK},
+		{"put", "PUT", "admin", `{"flags": {"beta": {"enabled": true, "percent": 50}}}`, http.StatusOK},
+		{"put percent above 100", "PUT", "admin", `{"flags": {"beta": {"enabled": true, "percent": 150}}}`, http.StatusBadRequest},
+		{"patch", "PATCH", "admin", `{"flags": {"beta": {"enabled": true, "percent": 0}}}`, http.StatusOK},
+		{"patch negative percent", "PATCH", "admin", `{"flags": {"beta": {"enabled": true, "percent": -1}}}`, http.StatusBadRequest},
+		{"patch delete", "PATCH", "admin", `{"flags": {"beta": null}}`, http.StatusOK},
+		{"bad json", "PATCH", "admin", `{`, http.StatusBadRequest},
+		{"method", "DELETE", "admin", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := NewFlagSet()
+			fs.SetFlag("beta", Flag{Enabled: true, Percent: Rollout(10)})
+			before := fs.Snapshot()
+			req := middlewaretest.NewRequest(tt.method, "/admin/flags").Body(tt.body)
+			if tt.token != "" {
+				req.Bearer(tt.token)
+			}
+			req.Do(t, FlagsAdminHandler(fs, "admin")).AssertStatus(t, tt.code)
+			if tt.code != http.StatusOK && fs.Snapshot() != before {
+				t.Error("a rejected request changed the flags")
+			}
+		})
+	}
+}
+
+func TestFlagsAdminHandlerPatchKeepsOtherFields(t *testing.T) {
+	fs := NewFlagSet()
+	fs.SetFlag("beta", Flag{Enabled: true})
+	fs.SetFlag("gamma", Flag{Enabled: true})
+	middlewaretest.NewRequest("PATCH", "/admin/flags").Bearer("admin").
+		Body(`{"maintenance_groups": {"api": true}, "flags": {"beta": null}}`).
+		Do(t, FlagsAdminHandler(fs, "admin")).
+		AssertStatus(t, http.StatusOK).
+		AssertJSON(t, `{"maintenance": false, "maintenance_groups": {"api": true}, "retry_after": 60,
+			"flags": {"gamma": {"enabled": true}}}`)
+}
+
+func TestFlagsAdminHandlerDisabledWithoutToken(t *testing.T) {
+	middlewaretest.NewRequest("GET", "/admin/flags").Bearer("").
+		Do(t, FlagsAdminHandler(NewFlagSet(), "")).
+		AssertStatus(t, http.StatusUnauthorized)
+}
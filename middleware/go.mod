@@ -0,0 +1,8 @@
+module middleware
+
+go 1.24.0
+
+require (
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2
+	golang.org/x/text v0.14.0
+)
@@ -0,0 +1,97 @@
+package middlewaretest
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClockSetFiresDueWaitersInOrder(t *testing.T) {
+	c := NewFakeClock(epoch)
+	late := c.After(2 * time.Second)
+	early := c.After(time.Second)
+	never := c.After(time.Hour)
+
+	c.Set(epoch.Add(2 * time.Second))
+
+	for name, ch := range map[string]<-chan time.Time{"early": early, "late": late} {
+		select {
+		case got := <-ch:
+			if !got.Equal(epoch.Add(2 * time.Second)) {
+				t.Errorf("%s fired with %v, want the time Set moved to", name, got)
+			}
+		default:
+			t.Errorf("%s did not fire", name)
+		}
+	}
+	select {
+	case <-never:
+		t.Error("waiter an hour out fired after two seconds")
+	default:
+	}
+	if got := c.Since(epoch); got != 2*time.Second {
+		t.Errorf("Since = %v, want 2s", got)
+	}
+}
+
+func TestFakeClockSetBackwardsFiresNothing(t *testing.T) {
+	c := NewFakeClock(epoch)
+	ch := c.After(time.Second)
+	c.Set(epoch.Add(-time.Minute))
+	select {
+	case <-ch:
+		t.Error("moving the clock backwards fired a waiter")
+	default:
+	}
+	if !c.Now().Equal(epoch.Add(-time.Minute)) {
+		t.Errorf("Now = %v, want %v", c.Now(), epoch.Add(-time.Minute))
+	}
+	c.Advance(time.Minute + time.Second)
+	select {
+	case <-ch:
+	default:
+		t.Error("waiter did not fire once its deadline was reached")
+	}
+}
+
+func TestFakeClockAfterNonPositiveFiresImmediately(t *testing.T) {
+	c := NewFakeClock(epoch)
+	select {
+	case got := <-c.After(0):
+		if !got.Equal(epoch) {
+			t.Errorf("After(0) = %v, want %v", got, epoch)
+		}
+	default:
+		t.Error("After(0) did not fire immediately")
+	}
+	c.BlockUntil(0) // nothing is waiting, must not block
+}
+
+func TestFakeClockBlockUntil(t *testing.T) {
+	c := NewFakeClock(epoch)
+	done := make(chan struct{}, 3)
+	for range 3 {
+		go func() {
+			c.Sleep(time.Second)
+			done <- struct{}{}
+		}()
+	}
+
+	c.BlockUntil(3)
+	select {
+	case <-done:
+		t.Fatal("a sleeper woke up before the clock moved")
+	default:
+	}
+
+	c.Advance(time.Second)
+	for range 3 {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("sleepers did not wake up after Advance")
+		}
+	}
+	c.BlockUntil(0)
+}
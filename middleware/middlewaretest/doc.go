@@ -0,0 +1,27 @@
+// Package middlewaretest removes the httptest boilerplate from middleware
+// tests.
+//
+// A typical test builds a request, runs it through a Chain wrapped by a
+// Recorder, and asserts on the response and on the order the layers ran in:
+//
+//	rec := middlewaretest.NewRecorder()
+//	h := Chain(
+//		rec.Handler("hello", http.HandlerFunc(helloHandler)),
+//		rec.Middleware("auth", AuthMiddleware),
+//		rec.Middleware("logging", LoggingMiddleware),
+//	)
+//
+//	resp := middlewaretest.NewRequest("GET", "/").
+//		Header("Authrization", "token").
+//		Do(t, h)
+//
+//	resp.AssertStatus(t, http.StatusOK).AssertBody(t, "hello")
+//	rec.AssertOrder(t, "logging", "auth", "hello", "/auth", "/logging")
+//
+// Time-dependent middleware (rate limits, timeouts, sessions) should take a
+// clock with Now/Since/After/Sleep methods so tests can pass a FakeClock and
+// move time forward with Advance.
+//
+// Golden files live in testdata/<name>.golden next to the test; run the tests
+// with UPDATE_GOLDEN=1 to rewrite them.
+package middlewaretest
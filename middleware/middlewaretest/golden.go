@@ -0,0 +1,62 @@
+package middlewaretest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Golden compares the response (status line, headers, body) with
+// testdata/<name>.golden. Set UPDATE_GOLDEN=1 to write the file instead.
+// Headers listed in ignore (e.g. "Date") are left out of the snapshot.
+func (resp *Response) Golden(t testing.TB, name string, ignore ...string) *Response {
+	t.Helper()
+	got := resp.snapshot(ignore)
+	path := filepath.Join("testdata", name+".golden")
+
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("golden %s: %v", path, err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("golden %s: %v", path, err)
+		}
+		return resp
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("golden %s: %v (run with UPDATE_GOLDEN=1 to create it)", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response does not match %s:\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+	return resp
+}
+
+func (resp *Response) snapshot(ignore []string) []byte {
+	skip := make(map[string]bool, len(ignore))
+	for _, h := range ignore {
+		skip[strings.ToLower(h)] = true
+	}
+	keys := make([]string, 0, len(resp.Header()))
+	for k := range resp.Header() {
+		if !skip[strings.ToLower(k)] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d\n", resp.Code)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, strings.Join(resp.Header()[k], ", "))
+	}
+	b.WriteString("\n")
+	b.Write(resp.Body.Bytes())
+	return b.Bytes()
+}
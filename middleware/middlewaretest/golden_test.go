@@ -0,0 +1,63 @@
+package middlewaretest
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func greeting(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Date", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Add("X-Tag", "a")
+		w.Header().Add("X-Tag", "b")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(body))
+	})
+}
+
+// fakeTB records failures instead of failing the real test, so mismatches
+// can be asserted on.
+type fakeTB struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeTB) Helper()               {}
+func (f *fakeTB) Errorf(string, ...any) { f.failed = true }
+func (f *fakeTB) Fatalf(string, ...any) { f.failed = true }
+
+func TestGoldenUpdateFlow(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Missing file: the comparison fails and points at UPDATE_GOLDEN.
+	ft := &fakeTB{TB: t}
+	NewRequest("GET", "/").Do(t, greeting("hello")).Golden(ft, "greeting", "Date")
+	if !ft.failed {
+		t.Fatal("Golden passed without a golden file")
+	}
+
+	// UPDATE_GOLDEN writes the snapshot, sorted headers, ignored ones left out.
+	t.Setenv("UPDATE_GOLDEN", "1")
+	NewRequest("GET", "/").Do(t, greeting("hello")).Golden(t, "greeting", "Date")
+	got, err := os.ReadFile(filepath.Join("testdata", "greeting.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "201\nContent-Type: text/plain\nX-Tag: a, b\n\nhello"
+	if string(got) != want {
+		t.Errorf("golden file = %q, want %q", got, want)
+	}
+
+	// Back in compare mode the same response matches and a changed one does not.
+	t.Setenv("UPDATE_GOLDEN", "")
+	NewRequest("GET", "/").Do(t, greeting("hello")).Golden(t, "greeting", "Date")
+
+	ft = &fakeTB{TB: t}
+	NewRequest("GET", "/").Do(t, greeting("goodbye")).Golden(ft, "greeting", "Date")
+	if !ft.failed {
+		t.Error("Golden passed for a different body")
+	}
+}
@@ -0,0 +1,81 @@
+package middlewaretest
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Recorder records the order in which wrapped middlewares and handlers run.
+// A middleware named "auth" records "auth" when the request enters it and
+// "/auth" when the request leaves it; a handler records only its name.
+type Recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (rec *Recorder) record(name string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.calls = append(rec.calls, name)
+}
+
+// Middleware wraps mw so entering and leaving it are recorded.
+func (rec *Recorder) Middleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec.record(name)
+			inner.ServeHTTP(w, r)
+			rec.record("/" + name)
+		})
+	}
+}
+
+// Handler wraps h so reaching it is recorded.
+func (rec *Recorder) Handler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.record(name)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Calls returns a copy of the recorded calls.
+func (rec *Recorder) Calls() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return slices.Clone(rec.calls)
+}
+
+// Reset forgets all recorded calls.
+func (rec *Recorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.calls = nil
+}
+
+// AssertOrder fails the test unless the recorded calls equal want.
+func (rec *Recorder) AssertOrder(t testing.TB, want ...string) {
+	t.Helper()
+	if got := rec.Calls(); !slices.Equal(got, want) {
+		t.Errorf("call order:\n got  %q\n want %q", got, want)
+	}
+}
+
+// AssertNotCalled fails the test if name (or "/"+name) was recorded, e.g.
+// to check that AuthMiddleware stopped the request before the handler.
+func (rec *Recorder) AssertNotCalled(t testing.TB, name string) {
+	t.Helper()
+	for _, c := range rec.Calls() {
+		if c == name || c == "/"+name {
+			t.Errorf("%s was called, want not called (calls %q)", name, rec.Calls())
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package middlewaretest
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func chain(h http.Handler, mws ...func(http.Handler) http.Handler) http.Handler {
+	for _, mw := range mws {
+		h = mw(h)
+	}
+	return h
+}
+
+func passThrough(next http.Handler) http.Handler { return next }
+
+func TestRecorderOrder(t *testing.T) {
+	rec := NewRecorder()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	h := chain(
+		rec.Handler("hello", ok),
+		rec.Middleware("auth", passThrough),
+		rec.Middleware("logging", passThrough),
+	)
+
+	NewRequest("GET", "/").Do(t, h).AssertStatus(t, http.StatusOK).AssertBody(t, "ok")
+	rec.AssertOrder(t, "logging", "auth", "hello", "/auth", "/logging")
+
+	rec.Reset()
+	if got := rec.Calls(); len(got) != 0 {
+		t.Errorf("Calls after Reset = %q, want none", got)
+	}
+}
+
+func TestRecorderShortCircuit(t *testing.T) {
+	rec := NewRecorder()
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+	}
+	h := chain(
+		rec.Handler("hello", http.NotFoundHandler()),
+		rec.Middleware("auth", deny),
+		rec.Middleware("logging", passThrough),
+	)
+
+	NewRequest("GET", "/").Do(t, h).AssertStatus(t, http.StatusUnauthorized)
+	rec.AssertOrder(t, "logging", "auth", "/auth", "/logging")
+	rec.AssertNotCalled(t, "hello")
+}
+
+func TestRecorderCallsIsACopy(t *testing.T) {
+	rec := NewRecorder()
+	NewRequest("GET", "/").Do(t, rec.Handler("h", http.NotFoundHandler()))
+	calls := rec.Calls()
+	calls[0] = "changed"
+	if got := rec.Calls(); !slices.Equal(got, []string{"h"}) {
+		t.Errorf("Calls = %q after modifying a returned slice, want [\"h\"]", got)
+	}
+}
@@ -0,0 +1,141 @@
+package middlewaretest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// RequestBuilder builds an *http.Request step by step. Errors (e.g. a value
+// that cannot be encoded as JSON) are reported when the request is built.
+type RequestBuilder struct {
+	method  string
+	target  string
+	header  http.Header
+	query   url.Values
+	body    io.Reader
+	ctx     context.Context
+	remote  string
+	cookies []*http.Cookie
+	err     error
+}
+
+// NewRequest starts a request for method and target ("/path" or a full URL).
+func NewRequest(method, target string) *RequestBuilder {
+	return &RequestBuilder{
+		method: method,
+		target: target,
+		header: make(http.Header),
+		query:  make(url.Values),
+		ctx:    context.Background(),
+	}
+}
+
+// Header sets a request header.
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	b.header.Set(key, value)
+	return b
+}
+
+// Query adds a query parameter.
+func (b *RequestBuilder) Query(key, value string) *RequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+// Cookie adds a cookie.
+func (b *RequestBuilder) Cookie(c *http.Cookie) *RequestBuilder {
+	b.cookies = append(b.cookies, c)
+	return b
+}
+
+// Bearer sets "Authorization: Bearer <token>".
+func (b *RequestBuilder) Bearer(token string) *RequestBuilder {
+	return b.Header("Authorization", "Bearer "+token)
+}
+
+// RemoteAddr sets r.RemoteAddr, e.g. "203.0.113.7:5555".
+func (b *RequestBuilder) RemoteAddr(addr string) *RequestBuilder {
+	b.remote = addr
+	return b
+}
+
+// Body sets a raw body.
+func (b *RequestBuilder) Body(body string) *RequestBuilder {
+	b.body = strings.NewReader(body)
+	return b
+}
+
+// JSON encodes v as the body and sets Content-Type to application/json.
+func (b *RequestBuilder) JSON(v any) *RequestBuilder {
+	data, err := json.Marshal(v)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.body = bytes.NewReader(data)
+	return b.Header("Content-Type", "application/json")
+}
+
+// Form encodes values as the body and sets the form Content-Type.
+func (b *RequestBuilder) Form(values url.Values) *RequestBuilder {
+	b.body = strings.NewReader(values.Encode())
+	return b.Header("Content-Type", "application/x-www-form-urlencoded")
+}
+
+// Context sets the request context.
+func (b *RequestBuilder) Context(ctx context.Context) *RequestBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// WithValue adds a context value, like ContextMiddleware would.
+func (b *RequestBuilder) WithValue(key, value any) *RequestBuilder {
+	b.ctx = context.WithValue(b.ctx, key, value)
+	return b
+}
+
+// Build returns the request, failing the test on builder errors.
+func (b *RequestBuilder) Build(t testing.TB) *http.Request {
+	t.Helper()
+	if b.err != nil {
+		t.Fatalf("building request: %v", b.err)
+	}
+	r := httptest.NewRequest(b.method, b.target, b.body).WithContext(b.ctx)
+	if len(b.query) > 0 {
+		q := r.URL.Query()
+		for k, vs := range b.query {
+			q[k] = append(q[k], vs...)
+		}
+		r.URL.RawQuery = q.Encode()
+	}
+	for k, vs := range b.header {
+		r.Header[k] = vs
+	}
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	if b.remote != "" {
+		r.RemoteAddr = b.remote
+	}
+	return r
+}
+
+// Do builds the request and serves it with h.
+func (b *RequestBuilder) Do(t testing.TB, h http.Handler) *Response {
+	t.Helper()
+	return Serve(h, b.Build(t))
+}
+
+// Serve runs r through h and returns the recorded response.
+func Serve(h http.Handler, r *http.Request) *Response {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return &Response{ResponseRecorder: rr}
+}
@@ -0,0 +1,102 @@
+package middlewaretest
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Response is a recorded response with chainable assertions. Failed
+// assertions mark the test failed but keep going, so one run reports every
+// mismatch.
+type Response struct {
+	*httptest.ResponseRecorder
+}
+
+// AssertStatus checks the status code.
+func (resp *Response) AssertStatus(t testing.TB, want int) *Response {
+	t.Helper()
+	if resp.Code != want {
+		t.Errorf("status = %d, want %d (body %q)", resp.Code, want, resp.Body.String())
+	}
+	return resp
+}
+
+// AssertHeader checks a response header value.
+func (resp *Response) AssertHeader(t testing.TB, key, want string) *Response {
+	t.Helper()
+	if got := resp.Header().Get(key); got != want {
+		t.Errorf("header %s = %q, want %q", key, got, want)
+	}
+	return resp
+}
+
+// AssertNoHeader checks that a response header is absent.
+func (resp *Response) AssertNoHeader(t testing.TB, key string) *Response {
+	t.Helper()
+	if got, ok := resp.Header()[textproto.CanonicalMIMEHeaderKey(key)]; ok {
+		t.Errorf("header %s = %q, want absent", key, got)
+	}
+	return resp
+}
+
+// AssertBody checks the exact body.
+func (resp *Response) AssertBody(t testing.TB, want string) *Response {
+	t.Helper()
+	if got := resp.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	return resp
+}
+
+// AssertBodyContains checks that the body contains substr.
+func (resp *Response) AssertBodyContains(t testing.TB, substr string) *Response {
+	t.Helper()
+	if got := resp.Body.String(); !strings.Contains(got, substr) {
+		t.Errorf("body = %q, want it to contain %q", got, substr)
+	}
+	return resp
+}
+
+// AssertJSON compares the body with want semantically: key order and
+// whitespace do not matter. want may be a JSON string, []byte or any value
+// that encodes to JSON.
+func (resp *Response) AssertJSON(t testing.TB, want any) *Response {
+	t.Helper()
+	var wantData []byte
+	switch w := want.(type) {
+	case string:
+		wantData = []byte(w)
+	case []byte:
+		wantData = w
+	default:
+		var err error
+		if wantData, err = json.Marshal(w); err != nil {
+			t.Fatalf("encoding expected JSON: %v", err)
+		}
+	}
+	var got, exp any
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Errorf("body is not JSON: %v (body %q)", err, resp.Body.String())
+		return resp
+	}
+	if err := json.Unmarshal(wantData, &exp); err != nil {
+		t.Fatalf("expected value is not JSON: %v", err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("JSON body mismatch:\n got  %s\n want %s", resp.Body.Bytes(), wantData)
+	}
+	return resp
+}
+
+// DecodeJSON decodes the body into v, failing the test on error.
+func (resp *Response) DecodeJSON(t testing.TB, v any) *Response {
+	t.Helper()
+	if err := json.Unmarshal(resp.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding body: %v (body %q)", err, resp.Body.String())
+	}
+	return resp
+}
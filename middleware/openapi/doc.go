@@ -0,0 +1,30 @@
+// Package openapi generates an OpenAPI 3.1 document from route
+// registrations, so the API description is built by the same code that
+// builds the router instead of being written by hand next to it.
+//
+//	spec := openapi.New("httpServ", "1.0.0")
+//	api := openapi.NewMux(mux, spec)
+//	api.Handle("POST /items", typed.Handle(createItem), openapi.Tags("items"))
+//	mux.Handle("GET /openapi.json", spec.Handler())
+//	mux.Handle("GET /docs", openapi.DocsHandler("httpServ API", "/openapi.json"))
+//
+// Schemas come from the Go types by reflection:
+//
+//	json:"name,omitempty"      property name (embedded structs are flattened)
+//	path / uri                 path parameter (typed / gin)
+//	query / form               query parameter
+//	header                     header parameter
+//	binding / validate         required, min, max, len, oneof, email, url, uuid
+//	doc:"..."                  description
+//
+// Named structs go to components/schemas once and are referenced; pointers
+// become nullable (type: [T, "null"]). typed.Handler routes document
+// themselves: request, success response (204 for typed.Empty) and
+// application/problem+json errors. Frameworks that are not a ServeMux,
+// such as gin, call Spec.Add next to each route; gin paths (":id",
+// "*rest") are converted.
+//
+// Spec.Check compares the generated document with a committed file and
+// reports the first difference, so CI fails when the code changed and the
+// spec did not.
+package openapi
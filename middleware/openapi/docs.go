@@ -0,0 +1,21 @@
+package openapi
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed docs.html
+var docsHTML string
+
+var docsTmpl = template.Must(template.New("docs").Parse(docsHTML))
+
+// DocsHandler serves a self-contained HTML page (no CDN, no external
+// scripts) that renders the document at specURL.
+func DocsHandler(title, specURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		docsTmpl.Execute(w, struct{ Title, SpecURL string }{title, specURL})
+	})
+}
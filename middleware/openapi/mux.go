@@ -0,0 +1,45 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Router is what Mux registers handlers on; *http.ServeMux is one.
+type Router interface {
+	Handle(pattern string, h http.Handler)
+}
+
+// Mux registers routes on a Router and documents them in a Spec in the
+// same call, so a route cannot exist without its documentation:
+//
+//	api := openapi.NewMux(mux, spec)
+//	api.Handle("GET /items/{id}", typed.Handle(getItem), openapi.Tags("items"))
+//
+// typed.Handler routes are described from their Req/Resp types; other
+// handlers need Request/Returns/Produces options.
+type Mux struct {
+	Router
+	Spec *Spec
+}
+
+// NewMux wraps r.
+func NewMux(r Router, spec *Spec) *Mux {
+	return &Mux{Router: r, Spec: spec}
+}
+
+// Handle registers h for pattern ("METHOD /path") and documents it.
+// Patterns without a method are registered but not documented.
+func (m *Mux) Handle(pattern string, h http.Handler, opts ...Option) {
+	m.Router.Handle(pattern, h)
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || !strings.HasPrefix(strings.TrimSpace(path), "/") {
+		return
+	}
+	m.Spec.Add(method, strings.TrimSpace(path), append([]Option{fromHandler(h)}, opts...)...)
+}
+
+// HandleFunc is Handle for a function.
+func (m *Mux) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request), opts ...Option) {
+	m.Handle(pattern, http.HandlerFunc(h), opts...)
+}
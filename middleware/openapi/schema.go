@@ -0,0 +1,282 @@
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema (draft 2020-12, as used by OpenAPI 3.1).
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"` // "string", or ["string", "null"]
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+}
+
+var (
+	timeType    = reflect.TypeFor[time.Time]()
+	durationTyp = reflect.TypeFor[time.Duration]()
+	rawJSONType = reflect.TypeFor[json.RawMessage]()
+)
+
+// schemaFor returns the schema of t. Named struct types are added to
+// components and referenced, so each is described once.
+func (s *Spec) schemaFor(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+	sc := s.schemaForValue(t)
+	if nullable && sc.Ref == "" {
+		if typ, ok := sc.Type.(string); ok {
+			sc.Type = []string{typ, "null"}
+		}
+	}
+	return sc
+}
+
+func (s *Spec) schemaForValue(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationTyp:
+		return &Schema{Type: "integer", Format: "int64", Description: "nanoseconds"}
+	case rawJSONType:
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return &Schema{} // any value
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := s.doc.Components.Schemas[name]; !ok {
+			s.doc.Components.Schemas[name] = nil // placeholder: recursive types stop here
+			s.doc.Components.Schemas[name] = s.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+// structSchema describes the JSON body fields of t; fields tagged path,
+// uri, query, form or header are parameters and left out.
+func (s *Spec) structSchema(t reflect.Type) *Schema {
+	sc := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.eachField(t, func(f reflect.StructField, name string) {
+		if paramIn(f) != "" {
+			return
+		}
+		fs := s.schemaFor(f.Type)
+		required := applyBinding(fs, f)
+		if d := f.Tag.Get("doc"); d != "" {
+			if fs.Ref != "" {
+				fs = &Schema{AllOf: []*Schema{fs}}
+			}
+			fs.Description = d
+		}
+		sc.Properties[name] = fs
+		if required {
+			sc.Required = append(sc.Required, name)
+		}
+	})
+	return sc
+}
+
+// eachField walks the JSON-visible fields of t, flattening embedded
+// structs the way encoding/json does.
+func (s *Spec) eachField(t reflect.Type, fn func(f reflect.StructField, name string)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			s.eachField(ft, fn)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fn(f, name)
+	}
+}
+
+// paramIn reports where a tagged field comes from: "path", "query",
+// "header", or "" for the body. Both the typed package tags (path, query,
+// header) and gin's (uri, form, header) are understood.
+func paramIn(f reflect.StructField) string {
+	switch {
+	case f.Tag.Get("path") != "", f.Tag.Get("uri") != "":
+		return "path"
+	case f.Tag.Get("query") != "", f.Tag.Get("form") != "":
+		return "query"
+	case f.Tag.Get("header") != "":
+		return "header"
+	}
+	return ""
+}
+
+func paramName(f reflect.StructField) string {
+	for _, key := range []string{"path", "uri", "query", "form", "header"} {
+		if v := f.Tag.Get(key); v != "" {
+			name, _, _ := strings.Cut(v, ",")
+			return name
+		}
+	}
+	return ""
+}
+
+// applyBinding maps validator rules from binding:"..." or validate:"..."
+// tags onto sc and reports whether the field is required.
+//
+//	required  min=3 max=10  len=4  oneof=a b c  email  url  uuid
+//
+// min/max bound numbers, string lengths or item counts by the field type.
+func applyBinding(sc *Schema, f reflect.StructField) (required bool) {
+	rules := f.Tag.Get("binding")
+	if rules == "" {
+		rules = f.Tag.Get("validate")
+	}
+	if rules == "" {
+		return false
+	}
+	kind := f.Type.Kind()
+	if kind == reflect.Pointer {
+		kind = f.Type.Elem().Kind()
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			required = true
+		case "email":
+			sc.Format = "email"
+		case "url":
+			sc.Format = "uri"
+		case "uuid":
+			sc.Format = "uuid"
+		case "oneof":
+			for _, v := range strings.Fields(arg) {
+				sc.Enum = append(sc.Enum, enumValue(kind, v))
+			}
+		case "min", "max", "len", "gte", "lte":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			setBound(sc, kind, key, n)
+		}
+	}
+	return required
+}
+
+func setBound(sc *Schema, kind reflect.Kind, key string, n float64) {
+	lower := key == "min" || key == "gte" || key == "len"
+	upper := key == "max" || key == "lte" || key == "len"
+	i := int(n)
+	switch kind {
+	case reflect.String:
+		if lower {
+			sc.MinLength = &i
+		}
+		if upper {
+			sc.MaxLength = &i
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if lower {
+			sc.MinItems = &i
+		}
+		if upper {
+			sc.MaxItems = &i
+		}
+	default:
+		if lower {
+			sc.Minimum = &n
+		}
+		if upper {
+			sc.Maximum = &n
+		}
+	}
+}
+
+func enumValue(kind reflect.Kind, v string) any {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return v
+}
+
+var nonName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// schemaName is the components key of a named type: "Item" for types of
+// package main, "typed.Problem" for others, "Page_Item" for the generic
+// Page[Item].
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if pkg := path.Base(t.PkgPath()); pkg != "main" && pkg != "." {
+		name = pkg + "." + name
+	}
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		args := name[i+1 : len(name)-1]
+		var parts []string
+		for _, a := range strings.Split(args, ",") {
+			a = a[strings.LastIndexAny(a, "./")+1:]
+			parts = append(parts, a)
+		}
+		name = name[:i] + "_" + strings.Join(parts, "_")
+	}
+	return nonName.ReplaceAllString(name, "_")
+}
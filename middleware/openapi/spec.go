@@ -0,0 +1,367 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"middleware/typed"
+)
+
+// Document is the subset of an OpenAPI 3.1 document this package writes.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info names the API.
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Components holds the shared schemas.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem maps lower-case methods to operations.
+type PathItem map[string]*Operation
+
+// Operation is one method on one path.
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter is a path, query or header parameter.
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody is the JSON body an operation accepts.
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response is one documented status.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema for one content type.
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Envelope wraps every documented JSON response in a common type, e.g.
+// gogin_proj's APIResponse{Success, Message, Data}: Type is a value of
+// the envelope and Field the JSON name of the member holding the payload.
+// Responses documented as the envelope type itself are left as they are.
+type Envelope struct {
+	Type  any
+	Field string
+}
+
+// Spec collects operations into a Document.
+type Spec struct {
+	Envelope *Envelope
+
+	doc Document
+}
+
+// New starts an empty document.
+func New(title, version string) *Spec {
+	return &Spec{doc: Document{
+		OpenAPI:    "3.1.0",
+		Info:       Info{Title: title, Version: version},
+		Paths:      make(map[string]*PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}}
+}
+
+// Document returns the document built so far.
+func (s *Spec) Document() *Document { return &s.doc }
+
+// op is what the options fill in before Add turns it into an Operation.
+type op struct {
+	summary   string
+	tags      []string
+	id        string
+	request   reflect.Type
+	responses []response
+	hidden    bool
+}
+
+type response struct {
+	status      int
+	typ         reflect.Type // nil: no body
+	contentType string
+	description string
+}
+
+// Option describes an operation.
+type Option func(*op)
+
+// Summary sets the one-line description.
+func Summary(s string) Option { return func(o *op) { o.summary = s } }
+
+// Tags groups the operation in the docs page.
+func Tags(tags ...string) Option { return func(o *op) { o.tags = append(o.tags, tags...) } }
+
+// OperationID overrides the generated ID ("get_items_id").
+func OperationID(id string) Option { return func(o *op) { o.id = id } }
+
+// Request documents the request type: path/uri, query/form and header
+// tagged fields become parameters, the rest is the JSON body. Pass a zero
+// value: Request(CreateItemReq{}).
+func Request(v any) Option { return func(o *op) { o.request = reflect.TypeOf(v) } }
+
+// Returns documents a JSON response; v nil means no body.
+func Returns(status int, v any) Option {
+	return func(o *op) {
+		r := response{status: status}
+		if v != nil {
+			r.typ, r.contentType = reflect.TypeOf(v), "application/json"
+		}
+		o.responses = append(o.responses, r)
+	}
+}
+
+// Produces documents a non-JSON response, e.g. text/event-stream.
+func Produces(status int, contentType, description string) Option {
+	return func(o *op) {
+		o.responses = append(o.responses, response{status: status, contentType: contentType, description: description})
+	}
+}
+
+// Hidden leaves the route out of the document.
+func Hidden() Option { return func(o *op) { o.hidden = true } }
+
+// typedHandler is implemented by *typed.Handler.
+type typedHandler interface {
+	Types() (req, resp reflect.Type, status int)
+}
+
+var (
+	emptyType   = reflect.TypeFor[typed.Empty]()
+	problemType = reflect.TypeFor[typed.Problem]()
+)
+
+// fromHandler documents a typed.Handler from its types: the request, the
+// success response (204 for typed.Empty) and problem+json errors.
+func fromHandler(h http.Handler) Option {
+	th, ok := h.(typedHandler)
+	if !ok {
+		return func(*op) {}
+	}
+	req, resp, status := th.Types()
+	return func(o *op) {
+		if o.request == nil {
+			o.request = req
+		}
+		if resp == emptyType {
+			o.responses = append(o.responses, response{status: http.StatusNoContent})
+		} else {
+			o.responses = append(o.responses, response{status: status, typ: resp, contentType: "application/json"})
+		}
+		o.responses = append(o.responses, response{status: 0, typ: problemType, contentType: "application/problem+json", description: "Error"})
+	}
+}
+
+var ginParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// Add documents an operation. path may use ServeMux ("/items/{id}",
+// "{rest...}") or gin (":id", "*rest") syntax.
+func (s *Spec) Add(method, path string, opts ...Option) {
+	var o op
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.hidden {
+		return
+	}
+	path = ginParam.ReplaceAllString(path, "{$1}")
+	path = strings.ReplaceAll(path, "...}", "}")
+	method = strings.ToLower(method)
+
+	operation := &Operation{
+		OperationID: o.id,
+		Summary:     o.summary,
+		Tags:        o.tags,
+		Responses:   make(map[string]*Response),
+	}
+	if operation.OperationID == "" {
+		operation.OperationID = operationID(method, path)
+	}
+	if o.request != nil {
+		s.addRequest(operation, method, path, o.request)
+	}
+	for _, r := range o.responses {
+		key := "default"
+		if r.status != 0 {
+			key = fmt.Sprint(r.status)
+		}
+		desc := r.description
+		if desc == "" {
+			desc = http.StatusText(r.status)
+		}
+		res := &Response{Description: desc}
+		if r.contentType != "" {
+			mt := &MediaType{}
+			if r.typ != nil {
+				mt.Schema = s.schemaFor(r.typ)
+				if s.Envelope != nil && r.contentType == "application/json" && r.typ != reflect.TypeOf(s.Envelope.Type) {
+					mt.Schema = s.envelope(mt.Schema)
+				}
+			}
+			res.Content = map[string]*MediaType{r.contentType: mt}
+		}
+		operation.Responses[key] = res
+	}
+	if len(operation.Responses) == 0 {
+		operation.Responses["200"] = &Response{Description: "OK"}
+	}
+
+	item := s.doc.Paths[path]
+	if item == nil {
+		item = &PathItem{}
+		s.doc.Paths[path] = item
+	}
+	(*item)[method] = operation
+}
+
+// addRequest splits req into parameters and a JSON body.
+func (s *Spec) addRequest(operation *Operation, method, path string, req reflect.Type) {
+	for req.Kind() == reflect.Pointer {
+		req = req.Elem()
+	}
+	if req.Kind() != reflect.Struct {
+		return
+	}
+	hasBody := false
+	s.eachField(req, func(f reflect.StructField, name string) {
+		in := paramIn(f)
+		if in == "" {
+			hasBody = true
+			return
+		}
+		p := &Parameter{Name: paramName(f), In: in, Schema: s.schemaFor(f.Type)}
+		p.Required = applyBinding(p.Schema, f) || in == "path"
+		operation.Parameters = append(operation.Parameters, p)
+	})
+	// Sorted here, once, so JSON only reads the document and concurrent
+	// GET /openapi.json requests do not race.
+	sort.SliceStable(operation.Parameters, func(i, j int) bool {
+		a, b := operation.Parameters[i], operation.Parameters[j]
+		return a.In+a.Name < b.In+b.Name
+	})
+	if hasBody && method != "get" && method != "head" && method != "delete" {
+		operation.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{"application/json": {Schema: s.schemaFor(req)}},
+		}
+	}
+}
+
+// envelope wraps a payload schema: allOf the envelope with its payload
+// field narrowed to payload.
+func (s *Spec) envelope(payload *Schema) *Schema {
+	return &Schema{AllOf: []*Schema{
+		s.schemaFor(reflect.TypeOf(s.Envelope.Type)),
+		{Type: "object", Properties: map[string]*Schema{s.Envelope.Field: payload}},
+	}}
+}
+
+var nonID = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func operationID(method, path string) string {
+	id := strings.Trim(nonID.ReplaceAllString(path, "_"), "_")
+	if id == "" {
+		id = "root"
+	}
+	return method + "_" + id
+}
+
+// JSON renders the document; map keys are sorted, so the output is stable
+// enough to commit and diff.
+func (s *Spec) JSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s.doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Handler serves the document as JSON.
+func (s *Spec) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := s.JSON()
+		if err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+// WriteFile writes the document to name.
+func (s *Spec) WriteFile(name string) error {
+	data, err := s.JSON()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0o644)
+}
+
+// Check compares the document with the committed file and describes the
+// first difference; use it in CI so the spec cannot drift from the code.
+func (s *Spec) Check(name string) error {
+	want, err := s.JSON()
+	if err != nil {
+		return err
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(got, want) {
+		return nil
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < max(len(gotLines), len(wantLines)); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			return fmt.Errorf("%s is out of date at line %d:\n  committed: %s\n  generated: %s", name, i+1, strings.TrimSpace(g), strings.TrimSpace(w))
+		}
+	}
+	return fmt.Errorf("%s is out of date", name)
+}
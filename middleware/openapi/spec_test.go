@@ -0,0 +1,37 @@
+package openapi
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+type listReq struct {
+	Sort  string `query:"sort"`
+	ID    int    `path:"id"`
+	Limit int    `query:"limit"`
+}
+
+func TestJSONSortsParametersAndIsSafeForConcurrentUse(t *testing.T) {
+	s := New("test", "1")
+	s.Add("GET", "/items/{id}", Request(listReq{}))
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.JSON(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, _ := s.JSON()
+	out := string(data)
+	id, limit, sort := strings.Index(out, `"name": "id"`), strings.Index(out, `"name": "limit"`), strings.Index(out, `"name": "sort"`)
+	if !(id < limit && limit < sort) {
+		t.Errorf("parameters not sorted by location then name:\n%s", out)
+	}
+}
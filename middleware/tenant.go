@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Multi-tenant Middleware
+/*
+One process, many customers. Every request must be bound to exactly one tenant
+before it reaches anything else in the chain.
+
+Request
+  ↓
+[ Tenant Middleware ]  → resolve tenant id (subdomain / header / path / JWT)
+  ↓                    → look it up in the registry (unknown → rejected)
+[ Other Middlewares ]  → read Tenant from context (rate limits, flags, CORS)
+  ↓
+[ Final Handler ]
+
+Resolvers are tried in the order they are passed, the first one that finds an
+id wins:
+
+handler := Chain(
+    http.HandlerFunc(helloHandler),
+    TenantMiddleware(registry,
+        TenantFromHeader("X-Tenant-ID"),
+        TenantFromSubdomain("example.com"),
+    ),
+)
+*/
+
+// TenantConfig is the per-tenant configuration other middlewares consume.
+type TenantConfig struct {
+	RateLimit      int             `json:"rate_limit"` // requests per second, 0 = unlimited
+	Burst          int             `json:"burst"`
+	Features       map[string]bool `json:"features"`
+	AllowedOrigins []string        `json:"allowed_origins"`
+}
+
+// Tenant is a resolved customer.
+type Tenant struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Config TenantConfig `json:"config"`
+}
+
+// FeatureEnabled reports whether the named feature flag is on for the tenant.
+func (t *Tenant) FeatureEnabled(name string) bool {
+	return t.Config.Features[name]
+}
+
+// OriginAllowed reports whether a CORS origin is allowed for the tenant.
+// "*" in AllowedOrigins allows every origin.
+func (t *Tenant) OriginAllowed(origin string) bool {
+	for _, o := range t.Config.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantRegistry looks tenants up by id.
+type TenantRegistry interface {
+	Lookup(id string) (*Tenant, bool)
+}
+
+// MemoryTenantRegistry is an in-memory TenantRegistry safe for concurrent use.
+// Tenants can be added or replaced while the server is running.
+type MemoryTenantRegistry struct {
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+}
+
+// NewMemoryTenantRegistry returns a registry holding the given tenants.
+func NewMemoryTenantRegistry(tenants ...*Tenant) *MemoryTenantRegistry {
+	reg := &MemoryTenantRegistry{tenants: make(map[string]*Tenant)}
+	for _, t := range tenants {
+		reg.Register(t)
+	}
+	return reg
+}
+
+// LoadTenantRegistry reads a JSON array of tenants from path.
+func LoadTenantRegistry(path string) (*MemoryTenantRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("tenant registry %s: %w", path, err)
+	}
+	for i, t := range tenants {
+		if t == nil {
+			return nil, fmt.Errorf("tenant registry %s: entry %d is null", path, i)
+		}
+		if t.ID == "" {
+			return nil, fmt.Errorf("tenant registry %s: entry %d has no id", path, i)
+		}
+	}
+	return NewMemoryTenantRegistry(tenants...), nil
+}
+
+// Register adds or replaces a tenant.
+func (reg *MemoryTenantRegistry) Register(t *Tenant) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.tenants[strings.ToLower(t.ID)] = t
+}
+
+// Remove deletes a tenant; requests for it are rejected from then on.
+func (reg *MemoryTenantRegistry) Remove(id string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.tenants, strings.ToLower(id))
+}
+
+// Lookup implements TenantRegistry.
+func (reg *MemoryTenantRegistry) Lookup(id string) (*Tenant, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	t, ok := reg.tenants[strings.ToLower(id)]
+	return t, ok
+}
+
+// TenantResolver extracts a tenant id from a request. It returns the request
+// to continue with (resolvers may rewrite it, e.g. strip a path prefix) and
+// false when the request carries no tenant id for this strategy.
+type TenantResolver func(r *http.Request) (id string, next *http.Request, ok bool)
+
+// TenantFromHeader resolves the tenant from a request header, e.g. X-Tenant-ID.
+func TenantFromHeader(name string) TenantResolver {
+	return func(r *http.Request) (string, *http.Request, bool) {
+		id := strings.TrimSpace(r.Header.Get(name))
+		return id, r, id != ""
+	}
+}
+
+// TenantFromSubdomain resolves "acme" from "acme.example.com" when baseDomain
+// is "example.com". The bare base domain and nested subdomains do not match.
+func TenantFromSubdomain(baseDomain string) TenantResolver {
+	suffix := "." + strings.ToLower(strings.Trim(baseDomain, "."))
+	return func(r *http.Request) (string, *http.Request, bool) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return "", r, false
+		}
+		id := strings.TrimSuffix(host, suffix)
+		if id == "" || strings.Contains(id, ".") {
+			return "", r, false
+		}
+		return id, r, true
+	}
+}
+
+// TenantFromPathPrefix resolves the tenant from the first path segment after
+// prefix, e.g. "/t/acme/orders" with prefix "/t". The prefix and tenant
+// segment are stripped so downstream handlers see "/orders".
+func TenantFromPathPrefix(prefix string) TenantResolver {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		prefix = ""
+	}
+	return func(r *http.Request) (string, *http.Request, bool) {
+		rest, found := strings.CutPrefix(r.URL.Path, prefix+"/")
+		if !found {
+			return "", r, false
+		}
+		id, tail, _ := strings.Cut(rest, "/")
+		if id == "" {
+			return "", r, false
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = "/" + tail
+		r2.URL.RawPath = ""
+		return id, r2, true
+	}
+}
+
+// TenantFromJWTClaim resolves the tenant from a claim of an HS256 bearer token
+// in the Authorization header. The signature is verified with key, and "exp"
+// and "nbf" against the clock; tokens that fail verification resolve nothing.
+func TenantFromJWTClaim(claim string, key []byte) TenantResolver {
+	return func(r *http.Request) (string, *http.Request, bool) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			return "", r, false
+		}
+		claims, err := verifyHS256(strings.TrimSpace(token), key)
+		if err != nil {
+			return "", r, false
+		}
+		id, _ := claims[claim].(string)
+		return id, r, id != ""
+	}
+}
+
+func verifyHS256(token string, key []byte) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("jwt: malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("jwt: unsupported alg %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("jwt: bad signature encoding")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, fmt.Errorf("jwt: invalid signature")
+	}
+	var claims map[string]any
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if exp, ok := claims["exp"].(float64); ok && now >= int64(exp) {
+		return nil, fmt.Errorf("jwt: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return nil, fmt.Errorf("jwt: token not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("jwt: bad segment encoding")
+	}
+	return json.Unmarshal(data, v)
+}
+
+type tenantContextKey struct{}
+
+// WithTenant returns a copy of ctx carrying t.
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// TenantFromContext returns the tenant stored by TenantMiddleware.
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantContextKey{}).(*Tenant)
+	return t, ok
+}
+
+// TenantMiddleware resolves the tenant with the first matching resolver,
+// rejects requests without a tenant (400) or with an unknown one (404), and
+// stores the Tenant in the request context.
+func TenantMiddleware(registry TenantRegistry, resolvers ...TenantResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, resolve := range resolvers {
+				id, r2, ok := resolve(r)
+				if !ok {
+					continue
+				}
+				tenant, found := registry.Lookup(id)
+				if !found {
+					http.Error(w, "unknown tenant", http.StatusNotFound)
+					return
+				}
+				next.ServeHTTP(w, r2.WithContext(WithTenant(r2.Context(), tenant)))
+				return
+			}
+			http.Error(w, "tenant required", http.StatusBadRequest)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"middleware/middlewaretest"
+)
+
+var jwtKey = []byte("test-key")
+
+func signHS256(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	unsigned := enc(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + enc(claims)
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// tenantEcho answers with the resolved tenant id and the path it was given.
+var tenantEcho = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	t, ok := TenantFromContext(r.Context())
+	if !ok {
+		http.Error(w, "no tenant in context", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(t.ID + " " + r.URL.Path))
+})
+
+func TestTenantMiddleware(t *testing.T) {
+	reg := NewMemoryTenantRegistry(&Tenant{ID: "acme"}, &Tenant{ID: "globex"})
+	h := Chain(tenantEcho, TenantMiddleware(reg,
+		TenantFromJWTClaim("tenant", jwtKey),
+		TenantFromHeader("X-Tenant-ID"),
+		TenantFromSubdomain("example.com"),
+		TenantFromPathPrefix("/t"),
+	))
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name string
+		req  *middlewaretest.RequestBuilder
+		code int
+		body string
+	}{
+		{"header", middlewaretest.NewRequest("GET", "/orders").Header("X-Tenant-ID", "acme"), 200, "acme /orders"},
+		{"header case-insensitive", middlewaretest.NewRequest("GET", "/orders").Header("X-Tenant-ID", "ACME"), 200, "acme /orders"},
+		{"subdomain", middlewaretest.NewRequest("GET", "http://globex.example.com:8080/orders"), 200, "globex /orders"},
+		{"nested subdomain", middlewaretest.NewRequest("GET", "http://a.globex.example.com/orders"), 400, "tenant required\n"},
+		{"path prefix is stripped", middlewaretest.NewRequest("GET", "/t/acme/orders/7"), 200, "acme /orders/7"},
+		{"jwt", middlewaretest.NewRequest("GET", "/x").Bearer(signHS256(t, map[string]any{"tenant": "globex", "exp": now + 60})), 200, "globex /x"},
+		{"jwt wins over header", middlewaretest.NewRequest("GET", "/x").
+			Bearer(signHS256(t, map[string]any{"tenant": "globex"})).Header("X-Tenant-ID", "acme"), 200, "globex /x"},
+		{"expired jwt falls through", middlewaretest.NewRequest("GET", "/x").
+			Bearer(signHS256(t, map[string]any{"tenant": "globex", "exp": now - 1})), 400, "tenant required\n"},
+		{"jwt not valid yet falls through", middlewaretest.NewRequest("GET", "/x").
+			Bearer(signHS256(t, map[string]any{"tenant": "globex", "nbf": now + 60})).Header("X-Tenant-ID", "acme"), 200, "acme /x"},
+		{"jwt with bad signature", middlewaretest.NewRequest("GET", "/x").
+			Bearer(signHS256(t, map[string]any{"tenant": "globex"}) + "x"), 400, "tenant required\n"},
+		{"unknown tenant", middlewaretest.NewRequest("GET", "/").Header("X-Tenant-ID", "initech"), 404, "unknown tenant\n"},
+		{"no tenant", middlewaretest.NewRequest("GET", "/"), 400, "tenant required\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Do(t, h).AssertStatus(t, tt.code).AssertBody(t, tt.body)
+		})
+	}
+}
+
+func TestVerifyHS256Times(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		claims map[string]any
+		err    string
+	}{
+		{"no times", map[string]any{}, ""},
+		{"exp in the future", map[string]any{"exp": now + 60}, ""},
+		{"exp now", map[string]any{"exp": now}, "jwt: token expired"},
+		{"nbf in the past", map[string]any{"nbf": now - 60}, ""},
+		{"nbf in the future", map[string]any{"nbf": now + 60}, "jwt: token not valid yet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyHS256(signHS256(t, tt.claims), jwtKey)
+			if got := errString(err); got != tt.err {
+				t.Errorf("err = %q, want %q", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadTenantRegistry(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"ok", `[{"id": "acme", "config": {"rate_limit": 5}}]`, ""},
+		{"null entry", `[{"id": "acme"}, null]`, "entry 1 is null"},
+		{"missing id", `[{"name": "Acme"}]`, "entry 0 has no id"},
+		{"not an array", `{"id": "acme"}`, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			reg, err := LoadTenantRegistry(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := reg.Lookup("ACME"); !ok || got.Config.RateLimit != 5 {
+				t.Errorf("Lookup(ACME) = %+v, %v", got, ok)
+			}
+		})
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,205 @@
+package typed
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paramSources are the struct tags filled from outside the body, in the
+// order they are applied.
+var paramSources = []string{"path", "query", "header"}
+
+// decode builds a Req from the JSON body, then from path values, the query
+// string and headers for fields tagged with those sources.
+func decode[Req any](r *http.Request, maxBody int64) (Req, error) {
+	var req Req
+	if err := decodeBody(r, maxBody, &req); err != nil {
+		return req, err
+	}
+
+	v := reflect.ValueOf(&req).Elem()
+	if v.Kind() != reflect.Struct {
+		return req, nil
+	}
+	var verr ValidationError
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		for _, src := range paramSources {
+			name, ok := sf.Tag.Lookup(src)
+			if !ok {
+				continue
+			}
+			fv := v.Field(i)
+			fv.SetZero() // never taken from the body
+			vals := paramValues(r, src, name)
+			if len(vals) == 0 {
+				continue
+			}
+			if err := setField(fv, vals); err != nil {
+				verr.Add("/"+src+"/"+name, err.Error())
+			}
+		}
+	}
+	return req, verr.Err()
+}
+
+func paramValues(r *http.Request, src, name string) []string {
+	switch src {
+	case "path":
+		if v := r.PathValue(name); v != "" {
+			return []string{v}
+		}
+		return nil
+	case "query":
+		return r.URL.Query()[name]
+	default:
+		return r.Header.Values(name)
+	}
+}
+
+func decodeBody(r *http.Request, maxBody int64, dst any) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return &Error{Status: http.StatusBadRequest, Detail: "could not read request body", Err: err}
+	}
+	if int64(len(data)) > maxBody {
+		return &Error{Status: http.StatusRequestEntityTooLarge, Detail: fmt.Sprintf("request body exceeds %d bytes", maxBody)}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		return &Error{Status: http.StatusUnsupportedMediaType, Detail: "request body must be JSON"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(dst)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &typeErr):
+		var verr ValidationError
+		verr.Add("/body/"+strings.ReplaceAll(typeErr.Field, ".", "/"), "must be "+jsonType(typeErr.Type))
+		return &verr
+	default:
+		return &Error{Status: http.StatusBadRequest, Detail: "malformed JSON: " + strings.TrimPrefix(err.Error(), "json: ")}
+	}
+}
+
+// jsonType names a Go type the way a JSON client thinks of it.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+var (
+	textUnmarshaler = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType    = reflect.TypeFor[time.Duration]()
+)
+
+// setField parses vals into fv: scalars use the first value, slices all
+// of them.
+func setField(fv reflect.Value, vals []string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+		for i, raw := range vals {
+			if err := setScalar(s.Index(i), raw); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+		return nil
+	}
+	if fv.Kind() == reflect.Pointer {
+		p := reflect.New(fv.Type().Elem())
+		if err := setScalar(p.Elem(), vals[0]); err != nil {
+			return err
+		}
+		fv.Set(p)
+		return nil
+	}
+	return setScalar(fv, vals[0])
+}
+
+func setScalar(fv reflect.Value, raw string) error {
+	if reflect.PointerTo(fv.Type()).Implements(textUnmarshaler) {
+		if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw)); err != nil {
+			return fmt.Errorf("invalid value %q", raw)
+		}
+		return nil
+	}
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", raw)
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a non-negative integer", raw)
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Package typed turns plain Go functions into JSON HTTP handlers.
+//
+// A handler is written against typed values instead of ResponseWriter and
+// Request:
+//
+//	type GetUserReq struct {
+//		ID     int    `path:"id"`
+//		Fields string `query:"fields"`
+//	}
+//
+//	func getUser(ctx context.Context, req GetUserReq) (User, error) {
+//		u, ok := users[req.ID]
+//		if !ok {
+//			return User{}, typed.ErrNotFound
+//		}
+//		return u, nil
+//	}
+//
+//	mux.Handle("GET /users/{id}", typed.Handle(getUser))
+//
+// Fields tagged path, query or header are filled from r.PathValue, the
+// query string and the request headers; every other field comes from the
+// JSON body. If Req has a Validate() error method it runs after decoding.
+//
+// Every failure is answered with an RFC 7807 application/problem+json body,
+// the same shape whatever produced it:
+//
+//	decoding           400 malformed, 413 too large, 415 not JSON
+//	*ValidationError   422 with one entry per failing field
+//	*Error             its own status (Errorf(409, "email %s taken", e))
+//	ErrNotFound ...    404, 401, 403, 409 (wrapping with %w is fine)
+//	deadline exceeded  504
+//	anything else      500, details logged but not sent to the client
+//
+// A client that went away (context.Canceled) gets no response at all.
+package typed
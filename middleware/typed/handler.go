@@ -0,0 +1,96 @@
+package typed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// Empty is a response without a body; handlers returning it answer 204.
+type Empty struct{}
+
+// Handler adapts a typed function to http.Handler. Create it with Handle
+// and adjust the fields before serving.
+type Handler[Req, Resp any] struct {
+	MaxBody  int64       // request body limit in bytes, default 1 MiB
+	Status   int         // success status, default 200
+	ErrorLog *log.Logger // where 500s are logged, default log.Default()
+
+	fn func(context.Context, Req) (Resp, error)
+}
+
+// Handle returns an http.Handler running fn.
+func Handle[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) *Handler[Req, Resp] {
+	return &Handler[Req, Resp]{MaxBody: 1 << 20, Status: http.StatusOK, ErrorLog: log.Default(), fn: fn}
+}
+
+// Types reports the request and response types and the success status;
+// middleware/openapi uses it to document the route.
+func (h *Handler[Req, Resp]) Types() (req, resp reflect.Type, status int) {
+	return reflect.TypeFor[Req](), reflect.TypeFor[Resp](), h.Status
+}
+
+type requestKey struct{}
+
+// Request returns the *http.Request being served, for the rare handler that
+// needs cookies, TLS state or the raw URL.
+func Request(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestKey{}).(*http.Request)
+	return r
+}
+
+func (h *Handler[Req, Resp]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := decode[Req](r, h.MaxBody)
+	if err == nil {
+		err = validate(&req)
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	resp, err := h.fn(context.WithValue(r.Context(), requestKey{}, r), req)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if _, ok := any(resp).(Empty); ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	status := h.Status
+	if sc, ok := any(resp).(interface{ StatusCode() int }); ok {
+		status = sc.StatusCode()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// validate runs Req's Validate method, declared on the value or pointer.
+func validate(req any) error {
+	if v, ok := req.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+func (h *Handler[Req, Resp]) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		return // the client is gone
+	}
+	p, internal := ProblemFor(err, r)
+	if internal {
+		h.ErrorLog.Printf("typed: %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	WriteProblem(w, p)
+}
@@ -0,0 +1,139 @@
+package typed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Status   int             `json:"status"`
+	Detail   string          `json:"detail,omitempty"`
+	Instance string          `json:"instance,omitempty"`
+	Errors   []ProblemDetail `json:"errors,omitempty"`
+}
+
+// ProblemDetail is one failing location inside a Problem.
+type ProblemDetail struct {
+	Pointer string `json:"pointer"`
+	Keyword string `json:"keyword,omitempty"`
+	Message string `json:"message"`
+}
+
+// WriteProblem writes p as application/problem+json.
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Sentinel errors for the common outcomes; wrap them to add detail:
+// fmt.Errorf("user %d: %w", id, typed.ErrNotFound).
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+var sentinelStatus = []struct {
+	err    error
+	status int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{ErrConflict, http.StatusConflict},
+	{ErrUnauthorized, http.StatusUnauthorized},
+	{ErrForbidden, http.StatusForbidden},
+}
+
+// Error is an error with an HTTP status. Detail is sent to the client.
+type Error struct {
+	Status int
+	Type   string // URI identifying the problem type, default about:blank
+	Title  string // default the status text
+	Detail string
+	Err    error // cause, logged but not sent
+}
+
+// Errorf returns an *Error with a formatted detail. A %w verb sets Err.
+func Errorf(status int, format string, args ...any) *Error {
+	err := fmt.Errorf(format, args...)
+	return &Error{Status: status, Detail: err.Error(), Err: errors.Unwrap(err)}
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%d %s", e.Status, e.Detail)
+	}
+	return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// ValidationError lists every invalid field of a request; it maps to 422.
+type ValidationError struct {
+	Details []ProblemDetail
+}
+
+// Add records a problem at pointer (a JSON pointer such as "/body/email").
+func (v *ValidationError) Add(pointer, message string) {
+	v.Details = append(v.Details, ProblemDetail{Pointer: pointer, Message: message})
+}
+
+// Err returns v, or nil when nothing was added, so Validate methods can end
+// with `return v.Err()`.
+func (v *ValidationError) Err() error {
+	if v == nil || len(v.Details) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v *ValidationError) Error() string {
+	msgs := make([]string, len(v.Details))
+	for i, d := range v.Details {
+		msgs[i] = d.Pointer + ": " + d.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// ProblemFor maps err to the Problem sent to the client. internal reports
+// whether err was unexpected (500) and should be logged.
+func ProblemFor(err error, r *http.Request) (p Problem, internal bool) {
+	p.Instance = r.URL.Path
+
+	var he *Error
+	var ve *ValidationError
+	switch {
+	case errors.As(err, &he):
+		p.Status, p.Type, p.Title, p.Detail = he.Status, he.Type, he.Title, he.Detail
+		return p, he.Status >= 500
+	case errors.As(err, &ve):
+		p.Status, p.Title, p.Errors = http.StatusUnprocessableEntity, "Request validation failed", ve.Details
+		return p, false
+	case errors.Is(err, context.DeadlineExceeded):
+		p.Status, p.Detail = http.StatusGatewayTimeout, "the request took too long"
+		return p, false
+	}
+	for _, s := range sentinelStatus {
+		if errors.Is(err, s.err) {
+			p.Status, p.Detail = s.status, err.Error()
+			return p, false
+		}
+	}
+	p.Status = http.StatusInternalServerError
+	return p, true
+}
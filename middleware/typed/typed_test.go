@@ -0,0 +1,195 @@
+package typed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"middleware/middlewaretest"
+)
+
+type itemReq struct {
+	ID      int           `path:"id"`
+	Verbose bool          `query:"verbose"`
+	Tags    []string      `query:"tag"`
+	Limit   *int          `query:"limit"`
+	Wait    time.Duration `query:"wait"`
+	Trace   string        `header:"X-Trace"`
+	Name    string        `json:"name"`
+	Count   int           `json:"count"`
+}
+
+func (r itemReq) Validate() error {
+	var v ValidationError
+	if r.Name == "forbidden" {
+		v.Add("/body/name", "is not allowed")
+	}
+	return v.Err()
+}
+
+// itemMux routes POST /items/{id} to a handler that echoes the decoded
+// request, so path values are set the way ServeMux sets them.
+func itemMux(maxBody int64) http.Handler {
+	h := Handle(func(ctx context.Context, req itemReq) (itemReq, error) { return req, nil })
+	if maxBody > 0 {
+		h.MaxBody = maxBody
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /items/{id}", h)
+	return mux
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *middlewaretest.RequestBuilder
+		maxBody int64
+		status  int
+		want    string // JSON body on success
+		pointer string // first problem pointer on 422
+	}{
+		{name: "every source", req: middlewaretest.NewRequest("POST", "/items/7?verbose=true&tag=a&tag=b&limit=5&wait=2s").
+			Header("X-Trace", "t1").JSON(map[string]any{"name": "box", "count": 3}),
+			status: 200, want: `{"ID": 7, "Verbose": true, "Tags": ["a", "b"], "Limit": 5, "Wait": 2000000000,
+				"Trace": "t1", "name": "box", "count": 3}`},
+		{name: "no body", req: middlewaretest.NewRequest("POST", "/items/7"),
+			status: 200, want: `{"ID": 7, "Verbose": false, "Tags": null, "Limit": null, "Wait": 0, "Trace": "", "name": "", "count": 0}`},
+		{name: "tagged fields never come from the body", req: middlewaretest.NewRequest("POST", "/items/7").
+			JSON(map[string]any{"ID": 99, "Trace": "body"}),
+			status: 200, want: `{"ID": 7, "Verbose": false, "Tags": null, "Limit": null, "Wait": 0, "Trace": "", "name": "", "count": 0}`},
+		{name: "json suffix content type", req: middlewaretest.NewRequest("POST", "/items/7").
+			Header("Content-Type", "application/merge-patch+json").Body(`{"name": "box"}`),
+			status: 200, want: `{"ID": 7, "Verbose": false, "Tags": null, "Limit": null, "Wait": 0, "Trace": "", "name": "box", "count": 0}`},
+
+		{name: "bad path value", req: middlewaretest.NewRequest("POST", "/items/seven"), status: 422, pointer: "/path/id"},
+		{name: "bad query bool", req: middlewaretest.NewRequest("POST", "/items/7?verbose=maybe"), status: 422, pointer: "/query/verbose"},
+		{name: "bad query pointer", req: middlewaretest.NewRequest("POST", "/items/7?limit=x"), status: 422, pointer: "/query/limit"},
+		{name: "bad duration", req: middlewaretest.NewRequest("POST", "/items/7?wait=soon"), status: 422, pointer: "/query/wait"},
+		{name: "wrong body type", req: middlewaretest.NewRequest("POST", "/items/7").Header("Content-Type", "application/json").
+			Body(`{"count": "three"}`), status: 422, pointer: "/body/count"},
+		{name: "validate", req: middlewaretest.NewRequest("POST", "/items/7").JSON(map[string]any{"name": "forbidden"}),
+			status: 422, pointer: "/body/name"},
+
+		{name: "bad json", req: middlewaretest.NewRequest("POST", "/items/7").Header("Content-Type", "application/json").
+			Body(`{"name":`), status: 400},
+		{name: "unknown field", req: middlewaretest.NewRequest("POST", "/items/7").Header("Content-Type", "application/json").
+			Body(`{"colour": "red"}`), status: 400},
+		{name: "data after the value", req: middlewaretest.NewRequest("POST", "/items/7").Header("Content-Type", "application/json").
+			Body(`{} {}`), status: 400},
+		{name: "wrong content type", req: middlewaretest.NewRequest("POST", "/items/7").Header("Content-Type", "text/plain").
+			Body(`{"name": "box"}`), status: 415},
+		{name: "no content type", req: middlewaretest.NewRequest("POST", "/items/7").Body(`{"name": "box"}`), status: 415},
+		{name: "too large", req: middlewaretest.NewRequest("POST", "/items/7").JSON(map[string]any{"name": strings.Repeat("x", 64)}),
+			maxBody: 32, status: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := tt.req.Do(t, itemMux(tt.maxBody)).AssertStatus(t, tt.status)
+			if tt.status == http.StatusOK {
+				resp.AssertJSON(t, tt.want)
+				return
+			}
+			resp.AssertHeader(t, "Content-Type", "application/problem+json")
+			var p Problem
+			resp.DecodeJSON(t, &p)
+			if p.Status != tt.status || p.Instance != "/items/7" && p.Instance != "/items/seven" {
+				t.Errorf("problem = %+v", p)
+			}
+			if tt.pointer != "" && (len(p.Errors) == 0 || p.Errors[0].Pointer != tt.pointer) {
+				t.Errorf("errors = %+v, want pointer %s", p.Errors, tt.pointer)
+			}
+		})
+	}
+}
+
+func TestProblemFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		status   int
+		typ      string
+		title    string
+		detail   string
+		internal bool
+	}{
+		{"typed error", &Error{Status: 409, Type: "https://example.com/taken", Title: "Taken", Detail: "email taken"},
+			409, "https://example.com/taken", "Taken", "email taken", false},
+		{"wrapped typed error", fmt.Errorf("create: %w", Errorf(http.StatusPaymentRequired, "plan %s exhausted", "free")),
+			402, "", "", "plan free exhausted", false},
+		{"typed 5xx is logged", Errorf(http.StatusServiceUnavailable, "db: %w", errors.New("down")),
+			503, "", "", "db: down", true},
+		{"validation", &ValidationError{Details: []ProblemDetail{{Pointer: "/body/name", Message: "is required"}}},
+			422, "", "Request validation failed", "", false},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), 504, "", "", "the request took too long", false},
+		{"not found", fmt.Errorf("user 7: %w", ErrNotFound), 404, "", "", "user 7: not found", false},
+		{"conflict", ErrConflict, 409, "", "", "conflict", false},
+		{"unauthorized", ErrUnauthorized, 401, "", "", "unauthorized", false},
+		{"forbidden", ErrForbidden, 403, "", "", "forbidden", false},
+		{"unexpected", errors.New("db password is hunter2"), 500, "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := middlewaretest.NewRequest("GET", "/users/7").Build(t)
+			p, internal := ProblemFor(tt.err, r)
+			if p.Status != tt.status || p.Type != tt.typ || p.Title != tt.title || p.Detail != tt.detail || internal != tt.internal {
+				t.Errorf("ProblemFor = %+v, internal %v\nwant status %d, type %q, title %q, detail %q, internal %v",
+					p, internal, tt.status, tt.typ, tt.title, tt.detail, tt.internal)
+			}
+			if p.Instance != "/users/7" {
+				t.Errorf("instance = %q", p.Instance)
+			}
+		})
+	}
+}
+
+type created struct {
+	ID int `json:"id"`
+}
+
+func (created) StatusCode() int { return http.StatusCreated }
+
+func TestHandlerResponses(t *testing.T) {
+	var logged bytes.Buffer
+	secret := Handle(func(ctx context.Context, _ struct{}) (int, error) { return 0, errors.New("db password is hunter2") })
+	secret.ErrorLog = log.New(&logged, "", 0)
+
+	middlewaretest.NewRequest("GET", "/").
+		Do(t, Handle(func(ctx context.Context, _ struct{}) (Empty, error) { return Empty{}, nil })).
+		AssertStatus(t, http.StatusNoContent).AssertBody(t, "")
+	middlewaretest.NewRequest("POST", "/").
+		Do(t, Handle(func(ctx context.Context, _ struct{}) (created, error) { return created{ID: 1}, nil })).
+		AssertStatus(t, http.StatusCreated).AssertJSON(t, `{"id": 1}`)
+	middlewaretest.NewRequest("GET", "/").
+		Do(t, Handle(func(ctx context.Context, _ struct{}) (*http.Request, error) {
+			if Request(ctx) == nil {
+				return nil, errors.New("no request in context")
+			}
+			return nil, nil
+		})).
+		AssertStatus(t, http.StatusOK)
+
+	middlewaretest.NewRequest("GET", "/").Do(t, secret).
+		AssertStatus(t, http.StatusInternalServerError).
+		AssertHeader(t, "Content-Type", "application/problem+json").
+		AssertJSON(t, `{"type": "about:blank", "title": "Internal Server Error", "status": 500, "instance": "/"}`)
+	if !strings.Contains(logged.String(), "hunter2") {
+		t.Errorf("500 not logged: %q", logged.String())
+	}
+}
+
+func TestHandlerClientGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := Handle(func(ctx context.Context, _ struct{}) (int, error) {
+		cancel()
+		return 0, ctx.Err()
+	})
+	middlewaretest.NewRequest("GET", "/").Context(ctx).Do(t, h).
+		AssertBody(t, "").
+		AssertNoHeader(t, "Content-Type")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"middleware/typed"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Request Validation Middleware
+/*
+gin validates with `binding:"required"` tags; net/http has nothing built in.
+ValidationMiddleware checks the request against JSON Schema (draft 2020-12)
+documents before the handler runs:
+
+schemas := NewSchemaValidator("./schemas")
+mux.Handle("/users", Chain(
+    http.HandlerFunc(createUser),
+    ValidationMiddleware(schemas, RouteSchemas{Body: "create_user.json"}),
+))
+
+Every failing value is reported, not only the first one:
+
+HTTP/1.1 422 Unprocessable Entity
+Content-Type: application/problem+json
+
+{
+  "type": "about:blank",
+  "title": "Request validation failed",
+  "status": 422,
+  "errors": [
+    {"pointer": "/body/name", "keyword": "minLength", "message": "minLength: got 1, want 3"}
+  ]
+}
+
+Query parameters are validated as an object of strings (arrays of strings for
+repeated keys), so use "pattern", "enum" or "format" rather than numeric types.
+*/
+
+// Problem, ProblemDetail and WriteProblem live in package typed so the
+// JSON handler adapter answers errors in exactly the same shape.
+type (
+	Problem       = typed.Problem
+	ProblemDetail = typed.ProblemDetail
+)
+
+// WriteProblem writes p as application/problem+json.
+func WriteProblem(w http.ResponseWriter, p Problem) { typed.WriteProblem(w, p) }
+
+// RouteSchemas names the schema files, relative to the validator directory,
+// used for one route. Empty names skip that part of the request.
+type RouteSchemas struct {
+	Body  string
+	Query string
+}
+
+// SchemaValidator loads schemas from a directory and caches them compiled.
+type SchemaValidator struct {
+	dir     string
+	maxBody int64
+
+	mu       sync.Mutex
+	compiler *jsonschema.Compiler
+	cache    map[string]*jsonschema.Schema
+}
+
+// NewSchemaValidator returns a validator reading schemas from dir.
+// Request bodies larger than 1 MiB are rejected.
+func NewSchemaValidator(dir string) *SchemaValidator {
+	return &SchemaValidator{
+		dir:      dir,
+		maxBody:  1 << 20,
+		compiler: newSchemaCompiler(),
+		cache:    make(map[string]*jsonschema.Schema),
+	}
+}
+
+func newSchemaCompiler() *jsonschema.Compiler {
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.AssertFormat()
+	return c
+}
+
+// Schema returns the compiled schema for name, compiling it on first use.
+func (v *SchemaValidator) Schema(name string) (*jsonschema.Schema, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if sch, ok := v.cache[name]; ok {
+		return sch, nil
+	}
+	path, err := filepath.Abs(filepath.Join(v.dir, filepath.Clean("/"+name)))
+	if err != nil {
+		return nil, err
+	}
+	sch, err := v.compiler.Compile(path)
+	if err != nil {
+		// The compiler keeps partial state after a failure; start over.
+		v.compiler = newSchemaCompiler()
+		return nil, fmt.Errorf("schema %s: %w", name, err)
+	}
+	v.cache[name] = sch
+	return sch, nil
+}
+
+// Preload compiles the named schemas so broken files fail at startup
+// instead of on the first request.
+func (v *SchemaValidator) Preload(names ...string) error {
+	for _, name := range names {
+		if _, err := v.Schema(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidationMiddleware rejects requests whose JSON body or query parameters
+// do not match the route's schemas with a 422 problem+json response.
+func ValidationMiddleware(v *SchemaValidator, rs RouteSchemas) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var details []ProblemDetail
+
+			if rs.Query != "" {
+				sch, err := v.Schema(rs.Query)
+				if err != nil {
+					WriteProblem(w, Problem{Status: http.StatusInternalServerError, Detail: "query schema unavailable"})
+					return
+				}
+				details = append(details, validationDetails(sch.Validate(queryInstance(r)), "/query")...)
+			}
+
+			if rs.Body != "" {
+				sch, err := v.Schema(rs.Body)
+				if err != nil {
+					WriteProblem(w, Problem{Status: http.StatusInternalServerError, Detail: "body schema unavailable"})
+					return
+				}
+				if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+					WriteProblem(w, Problem{Status: http.StatusUnsupportedMediaType, Detail: "request body must be JSON", Instance: r.URL.Path})
+					return
+				}
+				body, err := io.ReadAll(io.LimitReader(r.Body, v.maxBody+1))
+				if err != nil {
+					WriteProblem(w, Problem{Status: http.StatusBadRequest, Detail: "could not read request body", Instance: r.URL.Path})
+					return
+				}
+				if int64(len(body)) > v.maxBody {
+					WriteProblem(w, Problem{Status: http.StatusRequestEntityTooLarge, Instance: r.URL.Path})
+					return
+				}
+				inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+				if err != nil {
+					WriteProblem(w, Problem{Status: http.StatusBadRequest, Detail: "malformed JSON: " + err.Error(), Instance: r.URL.Path})
+					return
+				}
+				details = append(details, validationDetails(sch.Validate(inst), "/body")...)
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			if len(details) > 0 {
+				WriteProblem(w, Problem{
+					Status:   http.StatusUnprocessableEntity,
+					Title:    "Request validation failed",
+					Instance: r.URL.Path,
+					Errors:   details,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// queryInstance turns the query string into a JSON-like object.
+func queryInstance(r *http.Request) map[string]any {
+	obj := make(map[string]any)
+	for k, vals := range r.URL.Query() {
+		if len(vals) == 1 {
+			obj[k] = vals[0]
+			continue
+		}
+		arr := make([]any, len(vals))
+		for i, s := range vals {
+			arr[i] = s
+		}
+		obj[k] = arr
+	}
+	return obj
+}
+
+var schemaMessages = message.NewPrinter(language.English)
+
+// validationDetails flattens a validation error into its leaf failures.
+func validationDetails(err error, prefix string) []ProblemDetail {
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return nil
+	}
+	var out []ProblemDetail
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, c := range e.Causes {
+				walk(c)
+			}
+			return
+		}
+		var ptr strings.Builder
+		ptr.WriteString(prefix)
+		for _, tok := range e.InstanceLocation {
+			tok = strings.ReplaceAll(tok, "~", "~0")
+			ptr.WriteString("/" + strings.ReplaceAll(tok, "/", "~1"))
+		}
+		var keyword string
+		if kw := e.ErrorKind.KeywordPath(); len(kw) > 0 {
+			keyword = kw[len(kw)-1]
+		}
+		out = append(out, ProblemDetail{
+			Pointer: ptr.String(),
+			Keyword: keyword,
+			Message: e.ErrorKind.LocalizedString(schemaMessages),
+		})
+	}
+	walk(verr)
+	return out
+}
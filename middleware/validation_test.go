@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"middleware/middlewaretest"
+)
+
+func TestValidationMiddleware(t *testing.T) {
+	v := NewSchemaValidator("testdata/schemas")
+	if err := v.Preload("create_user.json", "list_users.json"); err != nil {
+		t.Fatal(err)
+	}
+	rec := middlewaretest.NewRecorder()
+	handler := rec.Handler("handler", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	create := Chain(handler, ValidationMiddleware(v, RouteSchemas{Body: "create_user.json"}))
+	list := Chain(handler, ValidationMiddleware(v, RouteSchemas{Query: "list_users.json"}))
+
+	tests := []struct {
+		name     string
+		h        http.Handler
+		req      *middlewaretest.RequestBuilder
+		code     int
+		pointers []string
+	}{
+		{"valid body", create, middlewaretest.NewRequest("POST", "/users").
+			JSON(map[string]any{"name": "Alice", "email": "alice@example.com"}), http.StatusOK, nil},
+		{"every failure reported", create, middlewaretest.NewRequest("POST", "/users").
+			JSON(map[string]any{"name": "Al", "email": "nope", "age": -1, "admin": true}),
+			http.StatusUnprocessableEntity, []string{"/body", "/body/age", "/body/email", "/body/name"}},
+		{"missing required", create, middlewaretest.NewRequest("POST", "/users").JSON(map[string]any{}),
+			http.StatusUnprocessableEntity, []string{"/body"}},
+		{"malformed json", create, middlewaretest.NewRequest("POST", "/users").
+			Header("Content-Type", "application/json").Body(`{"name":`), http.StatusBadRequest, nil},
+		{"not json", create, middlewaretest.NewRequest("POST", "/users").
+			Header("Content-Type", "text/plain").Body(`{}`), http.StatusUnsupportedMediaType, nil},
+		{"json suffix type", create, middlewaretest.NewRequest("POST", "/users").
+			Header("Content-Type", "application/merge-patch+json").
+			Body(`{"name": "Alice", "email": "alice@example.com"}`), http.StatusOK, nil},
+		{"too large", create, middlewaretest.NewRequest("POST", "/users").
+			Header("Content-Type", "application/json").Body(`"` + strings.Repeat("x", 1<<20) + `"`),
+			http.StatusRequestEntityTooLarge, nil},
+		{"valid query", list, middlewaretest.NewRequest("GET", "/users").Query("page", "2").Query("sort", "name"),
+			http.StatusOK, nil},
+		{"bad query", list, middlewaretest.NewRequest("GET", "/users").Query("page", "two").Query("sort", "age"),
+			http.StatusUnprocessableEntity, []string{"/query/page", "/query/sort"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec.Reset()
+			resp := tt.req.Do(t, tt.h).AssertStatus(t, tt.code)
+			if tt.code == http.StatusOK {
+				rec.AssertOrder(t, "handler")
+				return
+			}
+			rec.AssertNotCalled(t, "handler")
+			resp.AssertHeader(t, "Content-Type", "application/problem+json")
+			var p Problem
+			resp.DecodeJSON(t, &p)
+			var got []string
+			for _, d := range p.Errors {
+				got = append(got, d.Pointer)
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tt.pointers, " ") {
+				t.Errorf("error pointers = %q, want %q", got, tt.pointers)
+			}
+		})
+	}
+}
+
+func TestValidationMiddlewareProblem(t *testing.T) {
+	v := NewSchemaValidator("testdata/schemas")
+	h := Chain(http.NotFoundHandler(), ValidationMiddleware(v, RouteSchemas{Body: "create_user.json"}))
+	middlewaretest.NewRequest("POST", "/users").
+		JSON(map[string]any{"name": "Al", "email": "alice@example.com"}).
+		Do(t, h).
+		Golden(t, "validation_422")
+}
+
+func TestValidationMiddlewareBrokenSchema(t *testing.T) {
+	v := NewSchemaValidator("testdata/schemas")
+	if err := v.Preload("broken.json"); err == nil {
+		t.Error("Preload accepted a broken schema")
+	}
+	h := Chain(http.NotFoundHandler(), ValidationMiddleware(v, RouteSchemas{Body: "missing.json"}))
+	middlewaretest.NewRequest("POST", "/users").JSON(map[string]any{}).
+		Do(t, h).
+		AssertStatus(t, http.StatusInternalServerError)
+
+	// A failed compile must not poison the validator for good schemas.
+	if _, err := v.Schema("create_user.json"); err != nil {
+		t.Errorf("Schema after a failure: %v", err)
+	}
+}
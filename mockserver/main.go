@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"mockserver/mock"
+)
+
+/*
+mockserver: stand in for a downstream service
+
+	go run ./mockserver -addr :9090 -f inventory.yaml -f recorded.jsonl
+	curl localhost:9090/items/7                       → canned response
+	curl -X POST localhost:9090/__mock/expectations --data-binary @more.yaml
+	curl localhost:9090/__mock/verify                 → 200, or 409 with what is off
+
+Expectation files, matching, templates and faults are described in
+mock/doc.go. With -verify the process exits 1 on shutdown (Ctrl+C,
+SIGTERM) when Verify fails, so a CI job can run the mock around a test
+suite and fail on missing or unexpected calls.
+*/
+
+// fileFlags collects repeated -f flags.
+type fileFlags []string
+
+func (f *fileFlags) String() string     { return strings.Join(*f, ",") }
+func (f *fileFlags) Set(s string) error { *f = append(*f, s); return nil }
+
+func main() {
+	var files fileFlags
+	addr := flag.String("addr", ":9090", "listen address")
+	flag.Var(&files, "f", "expectations file (.yaml, .json, .jsonl recording), repeatable")
+	adminPrefix := flag.String("admin-prefix", "/__mock/", "admin API path prefix; empty disables it")
+	verify := flag.Bool("verify", false, "exit 1 on shutdown when call counts are off or requests went unmatched")
+	quiet := flag.Bool("q", false, "do not log requests")
+	flag.Parse()
+
+	s := mock.NewServer()
+	s.AdminPrefix = *adminPrefix
+	if !*quiet {
+		s.Logger = log.Default()
+	}
+	for _, f := range files {
+		if err := s.LoadFile(f); err != nil {
+			log.Fatalf("mockserver: %v", err)
+		}
+	}
+	log.Printf("mockserver: %d expectations, listening on %s", len(s.Expectations()), *addr)
+
+	srv := &http.Server{Addr: *addr, Handler: s, ReadHeaderTimeout: 5 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("mockserver: %v", err)
+	}
+
+	if *verify {
+		if err := s.Verify(); err != nil {
+			fmt.Fprintln(os.Stderr, "mockserver: verify failed:")
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "mockserver: verify ok")
+	}
+}
@@ -0,0 +1,86 @@
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// AdminHandler serves the admin API under AdminPrefix:
+//
+//	GET    /__mock/expectations        list, with call counts
+//	POST   /__mock/expectations        add (YAML or JSON: one, a list, or {expectations: [...]})
+//	DELETE /__mock/expectations        remove all and forget all requests
+//	DELETE /__mock/expectations/{id}   remove one
+//	GET    /__mock/requests            received requests (?unmatched=1: only unmatched)
+//	GET    /__mock/verify              200, or 409 with what is off
+//
+// ServeHTTP routes to it already; use it directly to serve the admin API
+// on a separate listener.
+func (s *Server) AdminHandler() http.Handler {
+	p := s.AdminPrefix
+	if p == "" {
+		p = "/__mock/"
+	}
+	p = strings.TrimSuffix(p, "/")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+p+"/expectations", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Expectations())
+	})
+	mux.HandleFunc("POST "+p+"/expectations", func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return
+		}
+		exps, err := ParseExpectations(data)
+		if err == nil && len(exps) == 0 {
+			err = errNoExpectations
+		}
+		var ids []string
+		if err == nil {
+			ids, err = s.Add(exps...)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string][]string{"ids": ids})
+	})
+	mux.HandleFunc("DELETE "+p+"/expectations", func(w http.ResponseWriter, r *http.Request) {
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE "+p+"/expectations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Remove(r.PathValue("id")) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no expectation " + r.PathValue("id")})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET "+p+"/requests", func(w http.ResponseWriter, r *http.Request) {
+		unmatched := r.URL.Query().Get("unmatched")
+		writeJSON(w, http.StatusOK, s.Journal(unmatched != "" && unmatched != "0" && unmatched != "false"))
+	})
+	mux.HandleFunc("GET "+p+"/verify", func(w http.ResponseWriter, r *http.Request) {
+		err := s.Verify()
+		if err == nil {
+			writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+			return
+		}
+		writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "errors": strings.Split(err.Error(), "\n")})
+	})
+	return mux
+}
+
+var errNoExpectations = errors.New("no expectations in body")
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false) // calls: ">=1" stays readable
+	enc.Encode(v)
+}
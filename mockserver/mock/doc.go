@@ -0,0 +1,51 @@
+// Package mock stands in for a downstream HTTP service in integration
+// tests: requests are matched against expectations and answered with
+// canned responses.
+//
+//	expectations:
+//	  - id: get-item
+//	    request:
+//	      method: GET
+//	      path: /items/{id}           # {name} one segment, {name...} the rest, ~regex
+//	      query: {verbose: "true"}
+//	      headers: {Authorization: "~^Bearer "}
+//	    response:
+//	      status: 200
+//	      json: {id: "{{.Path.id}}", name: pen}
+//	      delay: 50ms
+//	    calls: ">=1"                  # checked by Verify
+//	  - request: {method: POST, path: /items, body: {json: {name: pen}}}
+//	    response: {status: 503, fault: {kind: reset, rate: 0.2}}
+//
+// A request is checked against the expectations by descending priority,
+// then in the order they were added; the first that matches and has not
+// used up its times answers it:
+//
+//	request ─→ method? path? query? headers? body? times left?
+//	            ↓ yes                                      ↓ no match at all
+//	          delay (+ jitter) → fault? → status, headers, body   404 + why each one missed
+//
+// Value matchers (query, headers) are exact strings, "*" for "present", or
+// "~regex". Body matchers are equals, contains, regex, or json: a partial
+// match where every field given must be present with the same value.
+// Response bodies are text/template with .Path, .Query, .Header, .Body and
+// .JSON of the request.
+//
+// Faults: reset (TCP RST), close (drop the connection without answering),
+// truncate (headers and half the body, then close), hang (never answer
+// until the client gives up). rate makes a fault occasional.
+//
+// Expectations load from YAML or JSON (Load) and from JSON lines
+// recordings: each line a request with its response, as written by a
+// recording proxy or reused from loadgen templates:
+//
+//	{"method": "GET", "path": "/items/7", "response": {"status": 200, "body": "{\"id\":7}"}}
+//
+// Repeated requests in a recording answer in sequence, the last one
+// repeats.
+//
+// The admin API under /__mock/ adds expectations at runtime, lists them
+// with their call counts, shows unmatched requests and runs Verify; see
+// AdminHandler. In Go tests, NewTestServer starts a Server and verifies
+// it when the test ends.
+package mock
@@ -0,0 +1,321 @@
+package mock
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Expectation pairs a request matcher with the response it gets.
+type Expectation struct {
+	ID       string         `yaml:"id" json:"id"`
+	Priority int            `yaml:"priority" json:"priority,omitempty"`
+	Request  RequestMatcher `yaml:"request" json:"request"`
+	Response Response       `yaml:"response" json:"response"`
+	Times    int            `yaml:"times" json:"times,omitempty"` // answers at most this many requests, 0 = no limit
+	Calls    CallRange      `yaml:"calls" json:"calls,omitempty"` // expected count for Verify
+}
+
+// RequestMatcher selects requests; empty fields match anything.
+type RequestMatcher struct {
+	Method  string            `yaml:"method" json:"method,omitempty"` // "GET" or "GET|HEAD"
+	Path    string            `yaml:"path" json:"path,omitempty"`
+	Query   map[string]string `yaml:"query" json:"query,omitempty"`
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+	Body    *BodyMatcher      `yaml:"body" json:"body,omitempty"`
+}
+
+// BodyMatcher checks the request body; all given conditions must hold.
+type BodyMatcher struct {
+	Equals   string `yaml:"equals" json:"equals,omitempty"`
+	Contains string `yaml:"contains" json:"contains,omitempty"`
+	Regex    string `yaml:"regex" json:"regex,omitempty"`
+	JSON     any    `yaml:"json" json:"json,omitempty"`
+}
+
+// Response is what a matched request gets.
+type Response struct {
+	Status  int               `yaml:"status" json:"status,omitempty"` // default 200
+	Headers map[string]string `yaml:"headers" json:"headers,omitempty"`
+	Body    string            `yaml:"body" json:"body,omitempty"`
+	JSON    any               `yaml:"json" json:"json,omitempty"` // encoded as the body, with Content-Type
+	Delay   Duration          `yaml:"delay" json:"delay,omitempty"`
+	Jitter  Duration          `yaml:"jitter" json:"jitter,omitempty"` // extra random delay up to this
+	Fault   *Fault            `yaml:"fault" json:"fault,omitempty"`
+}
+
+// Fault breaks the response on purpose.
+type Fault struct {
+	Kind string  `yaml:"kind" json:"kind"`           // reset, close, truncate, hang
+	Rate float64 `yaml:"rate" json:"rate,omitempty"` // share of requests hit, default 1
+}
+
+var faultKinds = map[string]bool{"reset": true, "close": true, "truncate": true, "hang": true}
+
+// Duration reads and prints as "150ms".
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) { return []byte(time.Duration(d).String()), nil }
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration (use e.g. 150ms)", b)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// CallRange is an expected call count: "2", ">=1", "<=3" or "1..3". The
+// zero value expects nothing.
+type CallRange struct {
+	Min, Max int // Max -1 = no upper bound
+	set      bool
+}
+
+func (c CallRange) String() string {
+	switch {
+	case !c.set:
+		return ""
+	case c.Min == c.Max:
+		return strconv.Itoa(c.Min)
+	case c.Max < 0:
+		return ">=" + strconv.Itoa(c.Min)
+	case c.Min == 0:
+		return "<=" + strconv.Itoa(c.Max)
+	}
+	return fmt.Sprintf("%d..%d", c.Min, c.Max)
+}
+
+func (c CallRange) IsZero() bool { return !c.set }
+
+func (c CallRange) contains(n int) bool {
+	return !c.set || (n >= c.Min && (c.Max < 0 || n <= c.Max))
+}
+
+func (c CallRange) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *CallRange) UnmarshalText(b []byte) error {
+	s := strings.ReplaceAll(string(b), " ", "")
+	bad := fmt.Errorf("calls %q: use 2, >=1, <=3 or 1..3", b)
+	var err error
+	switch {
+	case strings.HasPrefix(s, ">="):
+		c.Max = -1
+		c.Min, err = strconv.Atoi(s[2:])
+	case strings.HasPrefix(s, "<="):
+		c.Max, err = strconv.Atoi(s[2:])
+	case strings.Contains(s, ".."):
+		lo, hi, _ := strings.Cut(s, "..")
+		if c.Min, err = strconv.Atoi(lo); err == nil {
+			c.Max, err = strconv.Atoi(hi)
+		}
+	default:
+		c.Min, err = strconv.Atoi(s)
+		c.Max = c.Min
+	}
+	if err != nil || c.Min < 0 || (c.Max >= 0 && c.Max < c.Min) {
+		return bad
+	}
+	c.set = true
+	return nil
+}
+
+// UnmarshalJSON also accepts a bare number.
+func (c *CallRange) UnmarshalJSON(b []byte) error {
+	return c.UnmarshalText(bytes.Trim(b, `"`))
+}
+
+// validate checks the patterns once, so a bad regex fails at load time
+// rather than on the first request.
+func (e *Expectation) validate() error {
+	if _, err := compilePath(e.Request.Path); err != nil {
+		return err
+	}
+	for _, m := range []map[string]string{e.Request.Query, e.Request.Headers} {
+		for name, v := range m {
+			if re, ok := strings.CutPrefix(v, "~"); ok {
+				if _, err := regexp.Compile(re); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+	}
+	if b := e.Request.Body; b != nil && b.Regex != "" {
+		if _, err := regexp.Compile(b.Regex); err != nil {
+			return fmt.Errorf("body regex: %w", err)
+		}
+	}
+	if f := e.Response.Fault; f != nil {
+		if !faultKinds[f.Kind] {
+			return fmt.Errorf("fault %q: use reset, close, truncate or hang", f.Kind)
+		}
+		if f.Rate < 0 || f.Rate > 1 {
+			return fmt.Errorf("fault rate %g is not between 0 and 1", f.Rate)
+		}
+	}
+	if s := e.Response.Status; s != 0 && (s < 100 || s > 999) {
+		return fmt.Errorf("status %d is not an HTTP status", s)
+	}
+	_, err := parseTemplate(e)
+	return err
+}
+
+// file is the layout of an expectations file.
+type file struct {
+	Expectations []Expectation `yaml:"expectations"`
+}
+
+// ParseExpectations reads YAML or JSON: {"expectations": [...]}, a bare
+// list, or a single expectation.
+func ParseExpectations(data []byte) ([]Expectation, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	if len(node.Content) == 0 {
+		return nil, nil
+	}
+	root := node.Content[0]
+	var out []Expectation
+	var err error
+	switch {
+	case root.Kind == yaml.SequenceNode:
+		err = root.Decode(&out)
+	case hasKey(root, "expectations"):
+		var f file
+		err = root.Decode(&f)
+		out = f.Expectations
+	default:
+		var e Expectation
+		err = root.Decode(&e)
+		out = []Expectation{e}
+	}
+	return out, err
+}
+
+func hasKey(n *yaml.Node, key string) bool {
+	if n.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// recorded is one line of a JSON lines recording.
+type recorded struct {
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	Path     string `json:"path"`
+	Response *struct {
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+		Body    json.RawMessage   `json:"body"`
+	} `json:"response"`
+}
+
+// ParseRecording turns a JSON lines recording into expectations that match
+// method, path and query. Lines without a response answer 200.
+func ParseRecording(data []byte) ([]Expectation, error) {
+	var out []Expectation
+	last := make(map[string]int) // method+path+query → index of the latest in out
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		var rec recorded
+		if err := json.Unmarshal(text, &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		target := rec.Path
+		if target == "" {
+			target = rec.URL
+		}
+		u, err := url.Parse(target)
+		if err != nil || u.Path == "" {
+			return nil, fmt.Errorf("line %d: no usable path or url", line)
+		}
+		method := strings.ToUpper(rec.Method)
+		if method == "" {
+			method = "GET"
+		}
+		e := Expectation{
+			ID:      fmt.Sprintf("rec-%d", line),
+			Request: RequestMatcher{Method: method, Path: "~^" + regexp.QuoteMeta(u.Path) + "$"},
+		}
+		if q := u.Query(); len(q) > 0 {
+			e.Request.Query = make(map[string]string)
+			for k := range q {
+				e.Request.Query[k] = q.Get(k)
+			}
+		}
+		if r := rec.Response; r != nil {
+			e.Response = Response{Status: r.Status, Headers: r.Headers}
+			if body := bytes.TrimSpace(r.Body); len(body) > 0 && body[0] == '"' {
+				json.Unmarshal(body, &e.Response.Body)
+			} else if len(body) > 0 && !bytes.Equal(body, []byte("null")) {
+				e.Response.Body = string(body)
+				if !hasHeader(e.Response.Headers, "Content-Type") {
+					if e.Response.Headers == nil {
+						e.Response.Headers = make(map[string]string)
+					}
+					e.Response.Headers["Content-Type"] = "application/json"
+				}
+			}
+		}
+		// Recorded bodies are literal: keep {{ }} in them from being run.
+		e.Response.Body = escapeTemplate(e.Response.Body)
+
+		key := method + " " + u.RequestURI()
+		if i, ok := last[key]; ok {
+			out[i].Times = 1 // answered once, then the next recording takes over
+		}
+		last[key] = len(out)
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
+
+// LoadFile reads expectations from a .yaml, .yml, .json or .jsonl file.
+func LoadFile(name string) ([]Expectation, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var exps []Expectation
+	if filepath.Ext(name) == ".jsonl" {
+		exps, err = ParseRecording(data)
+	} else {
+		exps, err = ParseExpectations(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return exps, nil
+}
+
+func hasHeader(h map[string]string, name string) bool {
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
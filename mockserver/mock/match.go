@@ -0,0 +1,233 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// pathPattern matches a URL path and extracts its {name} segments.
+type pathPattern struct {
+	re    *regexp.Regexp
+	names []string
+}
+
+// compilePath turns "/items/{id}" or "/files/{rest...}" into a regexp; a
+// pattern starting with "~" is a regexp already (named groups become
+// parameters). "" matches any path.
+func compilePath(p string) (*pathPattern, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if re, ok := strings.CutPrefix(p, "~"); ok {
+		c, err := regexp.Compile(re)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %w", p, err)
+		}
+		return &pathPattern{re: c, names: c.SubexpNames()[1:]}, nil
+	}
+	var b strings.Builder
+	var names []string
+	b.WriteString("^")
+	for i, seg := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if i > 0 || strings.HasPrefix(p, "/") {
+			b.WriteString("/")
+		}
+		name, ok := strings.CutPrefix(seg, "{")
+		if name, ok = strings.CutSuffix(name, "}"); !ok {
+			b.WriteString(regexp.QuoteMeta(seg))
+			continue
+		}
+		if rest, ok := strings.CutSuffix(name, "..."); ok {
+			names = append(names, rest)
+			b.WriteString("(.*)")
+			continue
+		}
+		names = append(names, name)
+		b.WriteString("([^/]+)")
+	}
+	b.WriteString("$")
+	return &pathPattern{re: regexp.MustCompile(b.String()), names: names}, nil
+}
+
+func (p *pathPattern) match(path string) (map[string]string, bool) {
+	params := make(map[string]string)
+	if p == nil {
+		return params, true
+	}
+	m := p.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	for i, name := range p.names {
+		if name != "" {
+			params[name] = m[i+1]
+		}
+	}
+	return params, true
+}
+
+var regexps sync.Map // pattern → *regexp.Regexp, or nil when invalid
+
+// cachedRegexp compiles a matcher pattern once; validate has already
+// rejected invalid ones.
+func cachedRegexp(pattern string) *regexp.Regexp {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = nil
+	}
+	regexps.Store(pattern, re)
+	return re
+}
+
+// matchValue applies a value matcher: exact, "*" (present) or "~regex".
+func matchValue(want string, got []string) bool {
+	if len(got) == 0 {
+		return false
+	}
+	switch {
+	case want == "*":
+		return true
+	case strings.HasPrefix(want, "~"):
+		re := cachedRegexp(want[1:])
+		if re == nil {
+			return false
+		}
+		for _, g := range got {
+			if re.MatchString(g) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, g := range got {
+		if g == want {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRequest reports whether r matches, and if not, the first reason
+// why (shown to the caller of an unmatched request).
+func (e *entry) matchRequest(r *http.Request, body []byte) (params map[string]string, miss string) {
+	m := e.Request
+	if m.Method != "" && !methodMatches(m.Method, r.Method) {
+		return nil, fmt.Sprintf("method %s, want %s", r.Method, m.Method)
+	}
+	params, ok := e.path.match(r.URL.Path)
+	if !ok {
+		return nil, fmt.Sprintf("path %s, want %s", r.URL.Path, m.Path)
+	}
+	q := r.URL.Query()
+	for name, want := range m.Query {
+		if !matchValue(want, q[name]) {
+			return nil, fmt.Sprintf("query %s=%q, want %q", name, q.Get(name), want)
+		}
+	}
+	for name, want := range m.Headers {
+		if !matchValue(want, r.Header.Values(name)) {
+			return nil, fmt.Sprintf("header %s: %q, want %q", name, r.Header.Get(name), want)
+		}
+	}
+	if b := m.Body; b != nil {
+		if why := b.match(body); why != "" {
+			return nil, "body " + why
+		}
+	}
+	return params, ""
+}
+
+func methodMatches(pattern, method string) bool {
+	for _, m := range strings.Split(pattern, "|") {
+		if strings.EqualFold(strings.TrimSpace(m), method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BodyMatcher) match(body []byte) string {
+	if b.Equals != "" && string(body) != b.Equals {
+		return "is not equal"
+	}
+	if b.Contains != "" && !bytes.Contains(body, []byte(b.Contains)) {
+		return fmt.Sprintf("does not contain %q", b.Contains)
+	}
+	if b.Regex != "" {
+		if re := cachedRegexp(b.Regex); re == nil || !re.Match(body) {
+			return fmt.Sprintf("does not match %s", b.Regex)
+		}
+	}
+	if b.JSON != nil {
+		var got any
+		if err := json.Unmarshal(body, &got); err != nil {
+			return "is not JSON"
+		}
+		if path, ok := jsonContains(b.JSON, got, ""); !ok {
+			return "differs at " + path
+		}
+	}
+	return ""
+}
+
+// jsonContains reports whether got has every field of want with the same
+// value; arrays must have the same length. It returns the JSON pointer of
+// the first difference.
+func jsonContains(want, got any, at string) (string, bool) {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			return at + "/", false
+		}
+		for k, wv := range w {
+			gv, ok := g[k]
+			if !ok {
+				return at + "/" + k, false
+			}
+			if p, ok := jsonContains(wv, gv, at+"/"+k); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			return at + "/", false
+		}
+		for i := range w {
+			if p, ok := jsonContains(w[i], g[i], fmt.Sprintf("%s/%d", at, i)); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	}
+	if !reflect.DeepEqual(want, got) {
+		if at == "" {
+			at = "/"
+		}
+		return at, false
+	}
+	return "", true
+}
+
+// normalize converts a YAML-decoded value to what encoding/json produces
+// (float64 numbers, map[string]any), so the two compare equal.
+func normalize(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	json.Unmarshal(b, &out)
+	return out
+}
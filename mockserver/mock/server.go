@@ -0,0 +1,450 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Server answers requests from its expectations. The zero value is not
+// usable; call NewServer.
+type Server struct {
+	AdminPrefix string      // admin API path prefix, default "/__mock/"; "" disables it
+	MaxJournal  int         // requests kept for the admin API, default 1000
+	Logger      *log.Logger // nil = quiet
+
+	adminOnce sync.Once
+	admin     http.Handler
+
+	mu        sync.Mutex
+	entries   []*entry
+	seq       int
+	journal   []Call
+	unmatched []Call
+}
+
+type entry struct {
+	Expectation
+	path  *pathPattern
+	tmpl  compiled
+	calls int
+	order int
+}
+
+// Call is a request the server received.
+type Call struct {
+	Time        time.Time   `json:"time"`
+	Method      string      `json:"method"`
+	URI         string      `json:"uri"`
+	Header      http.Header `json:"header"`
+	Body        string      `json:"body,omitempty"`
+	Expectation string      `json:"expectation,omitempty"` // ID that answered, "" if none
+	Misses      []string    `json:"misses,omitempty"`      // why each expectation did not match
+}
+
+// NewServer returns an empty Server.
+func NewServer() *Server {
+	return &Server{AdminPrefix: "/__mock/", MaxJournal: 1000}
+}
+
+// Add validates and adds expectations, returning their IDs (generated
+// when empty). Adding an existing ID replaces that expectation.
+func (s *Server) Add(exps ...Expectation) ([]string, error) {
+	entries := make([]*entry, 0, len(exps))
+	for _, e := range exps {
+		if err := e.validate(); err != nil {
+			if e.ID != "" {
+				err = fmt.Errorf("%s: %w", e.ID, err)
+			}
+			return nil, err
+		}
+		if b := e.Request.Body; b != nil && b.JSON != nil {
+			b.JSON = normalize(b.JSON)
+		}
+		path, _ := compilePath(e.Request.Path)
+		tmpl, _ := parseTemplate(&e)
+		entries = append(entries, &entry{Expectation: e, path: path, tmpl: tmpl})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, len(entries))
+	for i, en := range entries {
+		s.seq++
+		en.order = s.seq
+		if en.ID == "" {
+			en.ID = fmt.Sprintf("exp-%d", s.seq)
+		}
+		s.entries = remove(s.entries, en.ID)
+		s.entries = append(s.entries, en)
+		ids[i] = en.ID
+	}
+	sort.SliceStable(s.entries, func(i, j int) bool {
+		if s.entries[i].Priority != s.entries[j].Priority {
+			return s.entries[i].Priority > s.entries[j].Priority
+		}
+		return s.entries[i].order < s.entries[j].order
+	})
+	return ids, nil
+}
+
+// LoadFile adds the expectations of a file (see LoadFile).
+func (s *Server) LoadFile(name string) error {
+	exps, err := LoadFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Add(exps...); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Remove deletes an expectation; it reports whether it existed.
+func (s *Server) Remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.entries)
+	s.entries = remove(s.entries, id)
+	return len(s.entries) < n
+}
+
+func remove(entries []*entry, id string) []*entry {
+	out := entries[:0]
+	for _, e := range entries {
+		if e.ID != id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Reset removes all expectations and forgets all requests.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries, s.journal, s.unmatched = nil, nil, nil
+}
+
+// Calls returns how many requests the expectation answered.
+func (s *Server) Calls(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.ID == id {
+			return e.calls
+		}
+	}
+	return 0
+}
+
+// Verify reports expectations whose call count is outside their calls
+// range, and requests nothing matched.
+func (s *Server) Verify() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, e := range s.entries {
+		if !e.Calls.contains(e.calls) {
+			errs = append(errs, fmt.Errorf("%s: called %d times, want %s", e.ID, e.calls, e.Calls))
+		}
+	}
+	for _, c := range s.unmatched {
+		errs = append(errs, fmt.Errorf("unmatched request %s %s", c.Method, c.URI))
+	}
+	return errors.Join(errs...)
+}
+
+// ExpectationStatus is an expectation with its call count (admin API).
+type ExpectationStatus struct {
+	Expectation
+	Called int `json:"called"`
+}
+
+// Expectations lists the expectations in matching order.
+func (s *Server) Expectations() []ExpectationStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]ExpectationStatus, len(s.entries))
+	for i, e := range s.entries {
+		out[i] = ExpectationStatus{Expectation: e.Expectation, Called: e.calls}
+	}
+	return out
+}
+
+// Journal returns the received requests, oldest first; unmatched only
+// returns those no expectation answered.
+func (s *Server) Journal(unmatched bool) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if unmatched {
+		return append([]Call(nil), s.unmatched...)
+	}
+	return append([]Call(nil), s.journal...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.AdminPrefix != "" && strings.HasPrefix(r.URL.Path, s.AdminPrefix) {
+		s.adminOnce.Do(func() { s.admin = s.AdminHandler() })
+		s.admin.ServeHTTP(w, r)
+		return
+	}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 10<<20))
+	call := Call{Time: time.Now(), Method: r.Method, URI: r.URL.RequestURI(), Header: r.Header.Clone(), Body: string(body)}
+
+	e, params := s.match(r, body, &call)
+	if e == nil {
+		if s.Logger != nil {
+			s.Logger.Printf("mock: no match for %s %s", r.Method, call.URI)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"error": "no expectation matched", "misses": call.Misses})
+		return
+	}
+	if s.Logger != nil {
+		s.Logger.Printf("mock: %s %s → %s", r.Method, call.URI, e.ID)
+	}
+	s.respond(w, r, e, params, body)
+}
+
+// match picks the expectation for r and records the call.
+// The returned entry's Expectation and template never change after Add,
+// so they can be read without the lock.
+func (s *Server) match(r *http.Request, body []byte, call *Call) (*entry, map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hit *entry
+	var params map[string]string
+	for _, e := range s.entries {
+		if e.Times > 0 && e.calls >= e.Times {
+			call.Misses = append(call.Misses, e.ID+": used up ("+fmt.Sprint(e.Times)+" times)")
+			continue
+		}
+		p, miss := e.matchRequest(r, body)
+		if miss != "" {
+			call.Misses = append(call.Misses, e.ID+": "+miss)
+			continue
+		}
+		hit, params = e, p
+		break
+	}
+	if hit != nil {
+		hit.calls++
+		call.Expectation, call.Misses = hit.ID, nil
+	} else {
+		s.unmatched = appendBounded(s.unmatched, *call, s.MaxJournal)
+	}
+	s.journal = appendBounded(s.journal, *call, s.MaxJournal)
+	return hit, params
+}
+
+func appendBounded(calls []Call, c Call, max int) []Call {
+	if max > 0 && len(calls) >= max {
+		calls = calls[1:]
+	}
+	return append(calls, c)
+}
+
+// respond waits, injects the fault if any, and writes the response.
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, e *entry, params map[string]string, reqBody []byte) {
+	res := e.Response
+	delay := time.Duration(res.Delay)
+	if res.Jitter > 0 {
+		delay += rand.N(time.Duration(res.Jitter))
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		}
+	}
+
+	body, err := e.render(r, params, reqBody)
+	if err != nil {
+		http.Error(w, "mock: response template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := res.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	for k, v := range res.Headers {
+		w.Header().Set(k, v)
+	}
+	if res.JSON != nil && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	if f := res.Fault; f != nil && (f.Rate == 0 || rand.Float64() < f.Rate) {
+		s.fault(w, r, f.Kind, status, body)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// fault breaks the exchange the way a failing service or network would.
+func (s *Server) fault(w http.ResponseWriter, r *http.Request, kind string, status int, body []byte) {
+	if kind == "hang" {
+		<-r.Context().Done()
+		return
+	}
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 cannot hand over the connection; abort the stream instead.
+		panic(http.ErrAbortHandler)
+	}
+	defer conn.Close()
+	switch kind {
+	case "reset":
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetLinger(0) // close sends RST instead of FIN
+		}
+	case "truncate":
+		fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\nContent-Length: %d\r\n", status, http.StatusText(status), len(body))
+		w.Header().Write(buf)
+		buf.WriteString("\r\n")
+		buf.Write(body[:len(body)/2])
+		buf.Flush()
+	}
+}
+
+// render runs the body template (or encodes JSON) for this request.
+func (e *entry) render(r *http.Request, params map[string]string, reqBody []byte) ([]byte, error) {
+	if e.tmpl.body == nil && e.tmpl.json == nil {
+		return nil, nil
+	}
+	data := map[string]any{
+		"Path":   params,
+		"Query":  r.URL.Query(),
+		"Header": r.Header,
+		"Body":   string(reqBody),
+	}
+	var parsed any
+	if json.Unmarshal(reqBody, &parsed) == nil {
+		data["JSON"] = parsed
+	}
+	if e.tmpl.json != nil {
+		v, err := renderJSON(e.tmpl.json, data)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+	var out bytes.Buffer
+	if err := e.tmpl.body.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// compiled is a response body ready to render: a template for Body, or
+// the JSON value with its template strings parsed.
+type compiled struct {
+	body *template.Template
+	json any // like normalize(JSON), strings with {{ }} replaced by *template.Template
+}
+
+// parseTemplate compiles the response body of e. In JSON responses each
+// string value is its own template, so the output stays valid JSON.
+func parseTemplate(e *Expectation) (compiled, error) {
+	if e.Response.JSON != nil {
+		v, err := compileJSON(e.ID, normalize(e.Response.JSON))
+		if err != nil {
+			return compiled{}, fmt.Errorf("response json: %w", err)
+		}
+		return compiled{json: v}, nil
+	}
+	if e.Response.Body == "" {
+		return compiled{}, nil
+	}
+	t, err := template.New(e.ID).Option("missingkey=zero").Parse(e.Response.Body)
+	if err != nil {
+		return compiled{}, fmt.Errorf("response body: %w", err)
+	}
+	return compiled{body: t}, nil
+}
+
+func compileJSON(name string, v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		return template.New(name).Option("missingkey=zero").Parse(v)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, x := range v {
+			c, err := compileJSON(name, x)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = c
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, x := range v {
+			c, err := compileJSON(name, x)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func renderJSON(v any, data any) (any, error) {
+	switch v := v.(type) {
+	case *template.Template:
+		var b strings.Builder
+		err := v.Execute(&b, data)
+		return b.String(), err
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, x := range v {
+			r, err := renderJSON(x, data)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, x := range v {
+			r, err := renderJSON(x, data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// escapeTemplate makes literal text safe to use as a template.
+func escapeTemplate(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	return strings.ReplaceAll(s, "{{", `{{"{{"}}`)
+}
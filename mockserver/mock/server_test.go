@@ -0,0 +1,507 @@
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// load parses YAML expectations into a new Server.
+func load(t *testing.T, yaml string) *Server {
+	t.Helper()
+	exps, err := ParseExpectations([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	if _, err := s.Add(exps...); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// send serves one request in memory and returns the recorder.
+func send(s http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestMatching(t *testing.T) {
+	s := load(t, `
+- id: method-list
+  request: {method: "GET|HEAD", path: /list}
+- id: param
+  request: {method: GET, path: "/items/{id}"}
+  response: {body: "item {{.Path.id}}"}
+- id: rest
+  request: {path: "/files/{rest...}"}
+  response: {body: "file {{.Path.rest}}"}
+- id: regex-path
+  request: {path: "~^/v(?P<version>[0-9]+)/ping$"}
+  response: {body: "v{{.Path.version}}"}
+- id: query
+  request: {path: /search, query: {q: "~^go", page: "*", lang: en}}
+- id: header
+  request: {path: /secure, headers: {Authorization: "~^Bearer ", X-Tenant: acme}}
+- id: body-equals
+  request: {method: POST, path: /equals, body: {equals: "ping"}}
+- id: body-contains
+  request: {method: POST, path: /contains, body: {contains: needle, regex: "^hay"}}
+- id: body-json
+  request:
+    method: POST
+    path: /orders
+    body: {json: {customer: {id: 7}, items: [{sku: pen, qty: 2}]}}
+`)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		header []string
+		id     string // expectation that should answer; "" = none
+		resp   string
+		miss   string // part of a miss reason on 404
+	}{
+		{name: "method alternatives", method: "HEAD", target: "/list", id: "method-list"},
+		{name: "method mismatch", method: "DELETE", target: "/list", miss: "method-list: method DELETE, want GET|HEAD"},
+		{name: "path parameter", method: "GET", target: "/items/42", id: "param", resp: "item 42"},
+		{name: "parameter is one segment", method: "GET", target: "/items/4/2", miss: "param: path /items/4/2"},
+		{name: "rest parameter", method: "PUT", target: "/files/a/b.txt", id: "rest", resp: "file a/b.txt"},
+		{name: "regex path", method: "GET", target: "/v2/ping", id: "regex-path", resp: "v2"},
+		{name: "query", method: "GET", target: "/search?q=golang&page=3&lang=en", id: "query"},
+		{name: "query regex miss", method: "GET", target: "/search?q=rust&page=3&lang=en", miss: `query q="rust", want "~^go"`},
+		{name: "query missing", method: "GET", target: "/search?q=go&lang=en", miss: `query page="", want "*"`},
+		{name: "headers", method: "GET", target: "/secure", header: []string{"Authorization", "Bearer t", "X-Tenant", "acme"}, id: "header"},
+		{name: "header miss", method: "GET", target: "/secure", header: []string{"Authorization", "Basic x", "X-Tenant", "acme"},
+			miss: "header Authorization"},
+		{name: "body equals", method: "POST", target: "/equals", body: "ping", id: "body-equals"},
+		{name: "body not equal", method: "POST", target: "/equals", body: "pong", miss: "body is not equal"},
+		{name: "body contains and regex", method: "POST", target: "/contains", body: "haystack with a needle", id: "body-contains"},
+		{name: "body regex miss", method: "POST", target: "/contains", body: "a needle in hay", miss: "does not match ^hay"},
+		{name: "partial json", method: "POST", target: "/orders", id: "body-json",
+			body: `{"customer": {"id": 7, "name": "Ann"}, "items": [{"sku": "pen", "qty": 2, "price": 1.5}], "note": "x"}`},
+		{name: "json value differs", method: "POST", target: "/orders",
+			body: `{"customer": {"id": 8}, "items": [{"sku": "pen", "qty": 2}]}`, miss: "body differs at /customer/id"},
+		{name: "json array length", method: "POST", target: "/orders",
+			body: `{"customer": {"id": 7}, "items": []}`, miss: "body differs at /items/"},
+		{name: "not json", method: "POST", target: "/orders", body: "id=7", miss: "body is not JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(s, tt.method, tt.target, tt.body, tt.header...)
+			if tt.id == "" {
+				var got struct{ Misses []string }
+				json.Unmarshal(w.Body.Bytes(), &got)
+				if w.Code != http.StatusNotFound || !strings.Contains(strings.Join(got.Misses, "\n"), tt.miss) {
+					t.Errorf("%d %s, want 404 mentioning %q", w.Code, w.Body, tt.miss)
+				}
+				return
+			}
+			if w.Code != http.StatusOK || w.Body.String() != tt.resp {
+				t.Errorf("%d %q, want 200 %q", w.Code, w.Body, tt.resp)
+			}
+			if got := s.Journal(false); got[len(got)-1].Expectation != tt.id {
+				t.Errorf("answered by %q, want %q", got[len(got)-1].Expectation, tt.id)
+			}
+		})
+	}
+}
+
+func TestPriority(t *testing.T) {
+	s := load(t, `
+- {id: first, request: {path: /p}, response: {body: first}}
+- {id: second, request: {path: /p}, response: {body: second}}
+- {id: catch-all, priority: -1, response: {status: 418}}
+`)
+	if got := send(s, "GET", "/p", "").Body.String(); got != "first" {
+		t.Errorf("equal priority: %q answered, want the one added first", got)
+	}
+	s.Add(Expectation{ID: "urgent", Priority: 10, Request: RequestMatcher{Path: "/p"}, Response: Response{Body: "urgent"}})
+	if got := send(s, "GET", "/p", "").Body.String(); got != "urgent" {
+		t.Errorf("%q answered, want the higher priority", got)
+	}
+	if got := send(s, "GET", "/other", "").Code; got != http.StatusTeapot {
+		t.Errorf("status %d, want the low-priority catch-all", got)
+	}
+
+	// Re-adding an ID replaces it and moves it to the end of its priority.
+	s.Add(Expectation{ID: "first", Request: RequestMatcher{Path: "/p"}, Response: Response{Body: "first again"}})
+	s.Remove("urgent")
+	if got := send(s, "GET", "/p", "").Body.String(); got != "second" {
+		t.Errorf("%q answered, want second", got)
+	}
+	var ids []string
+	for _, e := range s.Expectations() {
+		ids = append(ids, e.ID)
+	}
+	if strings.Join(ids, ",") != "second,first,catch-all" {
+		t.Errorf("order = %v", ids)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	s := load(t, `
+- {id: once, request: {path: /job}, response: {status: 202}, times: 1}
+- {id: twice, request: {path: /job}, response: {status: 200}, times: 2}
+`)
+	for i, want := range []int{202, 200, 200, 404} {
+		if w := send(s, "GET", "/job", ""); w.Code != want {
+			t.Fatalf("request %d: %d, want %d", i+1, w.Code, want)
+		}
+	}
+	misses := s.Journal(true)[0].Misses
+	if len(misses) != 2 || misses[0] != "once: used up (1 times)" || misses[1] != "twice: used up (2 times)" {
+		t.Errorf("misses = %q", misses)
+	}
+	if s.Calls("once") != 1 || s.Calls("twice") != 2 || s.Calls("nope") != 0 {
+		t.Errorf("calls = %d, %d", s.Calls("once"), s.Calls("twice"))
+	}
+}
+
+func TestVerify(t *testing.T) {
+	s := load(t, `
+- {id: exact, request: {path: /a}, calls: 2}
+- {id: at-least, request: {path: /b}, calls: ">=1"}
+- {id: at-most, request: {path: /c}, calls: "<=1"}
+- {id: between, request: {path: /d}, calls: "1..2"}
+- {id: unchecked, request: {path: /e}}
+`)
+	send(s, "GET", "/a", "")
+	send(s, "GET", "/c", "")
+	send(s, "GET", "/c", "")
+	send(s, "GET", "/d", "")
+	send(s, "GET", "/nowhere", "")
+
+	err := s.Verify()
+	if err == nil {
+		t.Fatal("Verify passed")
+	}
+	want := []string{
+		"exact: called 1 times, want 2",
+		"at-least: called 0 times, want >=1",
+		"at-most: called 2 times, want <=1",
+		"unmatched request GET /nowhere",
+	}
+	if got := strings.Split(err.Error(), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Verify:\n%s\nwant:\n%s", err, strings.Join(want, "\n"))
+	}
+
+	s.Reset()
+	if err := s.Verify(); err != nil {
+		t.Errorf("Verify after Reset: %v", err)
+	}
+}
+
+func TestCallRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		min     int
+		max     int
+		printed string
+		err     bool
+	}{
+		{in: "2", min: 2, max: 2, printed: "2"},
+		{in: ">=1", min: 1, max: -1, printed: ">=1"},
+		{in: "<= 3", min: 0, max: 3, printed: "<=3"},
+		{in: "1..3", min: 1, max: 3, printed: "1..3"},
+		{in: "3..1", err: true},
+		{in: "-1", err: true},
+		{in: "some", err: true},
+	}
+	for _, tt := range tests {
+		var c CallRange
+		err := c.UnmarshalText([]byte(tt.in))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error", tt.in)
+			}
+			continue
+		}
+		if err != nil || c.Min != tt.min || c.Max != tt.max || c.String() != tt.printed {
+			t.Errorf("%q: %+v %q, %v", tt.in, c, c.String(), err)
+		}
+	}
+	var c CallRange
+	if err := json.Unmarshal([]byte(`2`), &c); err != nil || !c.contains(2) || c.contains(3) {
+		t.Errorf("bare JSON number: %+v, %v", c, err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	s := load(t, `
+- request: {method: POST, path: "/users/{id}"}
+  response:
+    status: 201
+    json:
+      id: "{{.Path.id}}"
+      name: "{{.JSON.name}}"
+      trace: "{{.Header.Get \"X-Trace\"}}"
+      verbose: "{{index .Query.verbose 0}}"
+      quoted: "{{.JSON.quote}}"
+      tags: ["{{.JSON.name}}", 7]
+      fixed: {n: 1.5, ok: true}
+- request: {path: /text}
+  response:
+    headers: {Content-Type: text/plain}
+    body: "hello {{.Query.Get \"who\"}}, you sent {{len .Body}} bytes{{.Path.missing}}"
+- request: {path: /literal}
+  response: {headers: {Content-Type: application/vnd.custom+json}, json: {a: "no template"}}
+`)
+	w := send(s, "POST", "/users/7?verbose=yes", `{"name": "Ann", "quote": "say \"hi\""}`, "X-Trace", "t-1")
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("%d %s %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("rendered JSON is invalid: %v\n%s", err, w.Body)
+	}
+	want := `{"fixed":{"n":1.5,"ok":true},"id":"7","name":"Ann","quoted":"say \"hi\"","tags":["Ann",7],"trace":"t-1","verbose":"yes"}`
+	if b, _ := json.Marshal(got); string(b) != want {
+		t.Errorf("body = %s\nwant   %s", b, want)
+	}
+
+	w = send(s, "GET", "/text?who=Bob", "12345")
+	if w.Body.String() != "hello Bob, you sent 5 bytes" {
+		t.Errorf("text body = %q", w.Body)
+	}
+	w = send(s, "GET", "/literal", "")
+	if w.Header().Get("Content-Type") != "application/vnd.custom+json" || w.Body.String() != `{"a":"no template"}` {
+		t.Errorf("%s %s", w.Header().Get("Content-Type"), w.Body)
+	}
+}
+
+func TestRecordingBodiesAreLiteral(t *testing.T) {
+	exps, err := ParseRecording([]byte(`
+{"method": "GET", "path": "/items/7?x=1", "response": {"status": 200, "body": {"tpl": "{{.Path}}"}}}
+{"method": "GET", "path": "/next", "response": {"status": 200, "body": "first"}}
+{"method": "GET", "path": "/next", "response": {"status": 201, "body": "second"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	if _, err := s.Add(exps...); err != nil {
+		t.Fatal(err)
+	}
+	if w := send(s, "GET", "/items/7?x=1", ""); w.Body.String() != `{"tpl": "{{.Path}}"}` ||
+		w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("recorded body = %q, %s", w.Body, w.Header().Get("Content-Type"))
+	}
+	if w := send(s, "GET", "/items/7", ""); w.Code != http.StatusNotFound {
+		t.Errorf("without the recorded query: %d", w.Code)
+	}
+	for i, want := range []string{"first", "second", "second"} {
+		if w := send(s, "GET", "/next", ""); w.Body.String() != want {
+			t.Errorf("request %d: %q, want %q", i+1, w.Body, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  string
+		err  string
+	}{
+		{"path regex", `{id: x, request: {path: "~(["}}`, "x: path ~(["},
+		{"query regex", `{request: {query: {q: "~("}}}`, "q: error parsing regexp"},
+		{"body regex", `{request: {body: {regex: "("}}}`, "body regex"},
+		{"fault kind", `{response: {fault: {kind: explode}}}`, `fault "explode"`},
+		{"fault rate", `{response: {fault: {kind: reset, rate: 2}}}`, "fault rate 2"},
+		{"status", `{response: {status: 42}}`, "status 42"},
+		{"body template", `{response: {body: "{{.Path"}}`, "response body"},
+		{"json template", `{response: {json: {a: "{{end}}"}}}`, "response json: a:"},
+		{"delay", `{response: {delay: soon}}`, "not a duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exps, err := ParseExpectations([]byte(tt.exp))
+			if err == nil {
+				_, err = NewServer().Add(exps...)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// faultClient does not reuse connections, so a broken one is not retried.
+var faultClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 2 * time.Second}
+
+func TestFaults(t *testing.T) {
+	s := load(t, `
+- {request: {path: /reset}, response: {body: "never sent", fault: {kind: reset}}}
+- {request: {path: /close}, response: {body: "never sent", fault: {kind: close}}}
+- {request: {path: /truncate}, response: {status: 200, body: "0123456789", fault: {kind: truncate}}}
+- {request: {path: /never}, response: {body: "fine", fault: {kind: reset, rate: 0.0000001}}}
+`)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	_, err := faultClient.Get(srv.URL + "/reset")
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("reset: err = %v, want ECONNRESET", err)
+	}
+	_, err = faultClient.Get(srv.URL + "/close")
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("close: err = %v, want EOF", err)
+	}
+
+	resp, err := faultClient.Get(srv.URL + "/truncate")
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.ContentLength != 10 || string(body) != "01234" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncate: %d, length %d, body %q, %v", resp.StatusCode, resp.ContentLength, body, err)
+	}
+
+	resp, err = faultClient.Get(srv.URL + "/never")
+	if err != nil {
+		t.Fatalf("rare fault: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "fine" {
+		t.Errorf("rare fault fired: %q", body)
+	}
+}
+
+func TestHangAndDelay(t *testing.T) {
+	s := load(t, `
+- {request: {path: /hang}, response: {fault: {kind: hang}}}
+- {request: {path: /slow}, response: {body: late, delay: 50ms, jitter: 10ms}}
+`)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c := &http.Client{Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if _, err := c.Get(srv.URL + "/hang"); err == nil || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("hang: %v after %v", err, time.Since(start))
+	}
+
+	start = time.Now()
+	resp, err := faultClient.Get(srv.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("answered after %v, before the delay", d)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	s := NewServer()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	call := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	status, body := call("POST", "/__mock/expectations", `
+expectations:
+  - {id: item, request: {path: "/items/{id}"}, response: {json: {id: "{{.Path.id}}"}}, calls: 1}
+  - {request: {path: /health}}
+`)
+	if status != http.StatusCreated || !strings.Contains(body, `"ids": [`) || !strings.Contains(body, `"item"`) ||
+		!strings.Contains(body, `"exp-2"`) {
+		t.Fatalf("add: %d %s", status, body)
+	}
+	if status, body := call("POST", "/__mock/expectations", `{"id": "x", "request": {"path": "~("}}`); status != http.StatusBadRequest ||
+		!strings.Contains(body, "x: path") {
+		t.Errorf("add invalid: %d %s", status, body)
+	}
+	if status, body := call("POST", "/__mock/expectations", ``); status != http.StatusBadRequest ||
+		!strings.Contains(body, "no expectations") {
+		t.Errorf("add empty: %d %s", status, body)
+	}
+
+	if status, body := call("GET", "/items/9", ""); status != 200 || body != `{"id":"9"}` {
+		t.Errorf("mocked: %d %s", status, body)
+	}
+	call("GET", "/missing", "")
+
+	status, body = call("GET", "/__mock/expectations", "")
+	var listed []struct {
+		ID     string `json:"id"`
+		Called int    `json:"called"`
+		Calls  string `json:"calls"`
+	}
+	if err := json.Unmarshal([]byte(body), &listed); err != nil || status != 200 || len(listed) != 2 ||
+		listed[0].ID != "item" || listed[0].Called != 1 || listed[0].Calls != "1" {
+		t.Errorf("list: %d %s", status, body)
+	}
+
+	var journal []Call
+	_, body = call("GET", "/__mock/requests", "")
+	json.Unmarshal([]byte(body), &journal)
+	if len(journal) != 2 || journal[0].Expectation != "item" || journal[1].URI != "/missing" {
+		t.Errorf("requests: %s", body)
+	}
+	_, body = call("GET", "/__mock/requests?unmatched=1", "")
+	journal = nil
+	json.Unmarshal([]byte(body), &journal)
+	if len(journal) != 1 || journal[0].URI != "/missing" || len(journal[0].Misses) != 2 {
+		t.Errorf("unmatched: %s", body)
+	}
+
+	if status, body := call("GET", "/__mock/verify", ""); status != http.StatusConflict ||
+		!strings.Contains(body, "unmatched request GET /missing") {
+		t.Errorf("verify: %d %s", status, body)
+	}
+
+	if status, _ := call("DELETE", "/__mock/expectations/exp-2", ""); status != http.StatusNoContent {
+		t.Errorf("delete one: %d", status)
+	}
+	if status, body := call("DELETE", "/__mock/expectations/exp-2", ""); status != http.StatusNotFound ||
+		!strings.Contains(body, "no expectation exp-2") {
+		t.Errorf("delete again: %d %s", status, body)
+	}
+	if status, _ := call("DELETE", "/__mock/expectations", ""); status != http.StatusNoContent {
+		t.Errorf("delete all: %d", status)
+	}
+	if status, body := call("GET", "/__mock/verify", ""); status != 200 || !strings.Contains(body, `"ok": true`) {
+		t.Errorf("verify after reset: %d %s", status, body)
+	}
+}
+
+func TestAdminPrefix(t *testing.T) {
+	s := load(t, `{request: {path: "/__mock/expectations"}, response: {body: mocked}}`)
+	s.AdminPrefix = ""
+	if w := send(s, "GET", "/__mock/expectations", ""); w.Body.String() != "mocked" {
+		t.Errorf("admin API not disabled: %s", w.Body)
+	}
+
+	s = NewServer()
+	s.AdminPrefix = "/_admin/"
+	if w := send(s, "GET", "/_admin/verify", ""); w.Code != 200 {
+		t.Errorf("custom prefix: %d %s", w.Code, w.Body)
+	}
+	if w := send(s, "GET", "/__mock/verify", ""); w.Code != http.StatusNotFound {
+		t.Errorf("default prefix still served: %d", w.Code)
+	}
+}
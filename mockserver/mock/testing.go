@@ -0,0 +1,43 @@
+package mock
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestServer is a Server listening on a local port for one test.
+type TestServer struct {
+	*Server
+	URL string
+}
+
+// NewTestServer starts a Server with the expectations from files. When the
+// test ends it fails the test if Verify does, then shuts down.
+//
+//	inventory := mock.NewTestServer(t, "testdata/inventory.yaml")
+//	svc := NewService(inventory.URL)
+func NewTestServer(tb testing.TB, files ...string) *TestServer {
+	tb.Helper()
+	s := NewServer()
+	for _, f := range files {
+		if err := s.LoadFile(f); err != nil {
+			tb.Fatalf("mock: %v", err)
+		}
+	}
+	hs := httptest.NewServer(s)
+	tb.Cleanup(func() {
+		hs.Close()
+		if err := s.Verify(); err != nil {
+			tb.Errorf("mock: %v", err)
+		}
+	})
+	return &TestServer{Server: s, URL: hs.URL}
+}
+
+// Expect adds expectations, failing the test if one is invalid.
+func (ts *TestServer) Expect(tb testing.TB, exps ...Expectation) {
+	tb.Helper()
+	if _, err := ts.Add(exps...); err != nil {
+		tb.Fatalf("mock: %v", err)
+	}
+}